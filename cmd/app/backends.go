@@ -0,0 +1,12 @@
+package app
+
+// The built-in agent backends, which register themselves to the agent registry. The backends built outside
+// this repo are linked in the same way, by importing their packages in main.
+import (
+	_ "github.com/fatsheep9146/kirklog/pkg/embedded"
+	_ "github.com/fatsheep9146/kirklog/pkg/filebeat"
+	_ "github.com/fatsheep9146/kirklog/pkg/fluentbit"
+	_ "github.com/fatsheep9146/kirklog/pkg/logexporter"
+	_ "github.com/fatsheep9146/kirklog/pkg/logkit"
+	_ "github.com/fatsheep9146/kirklog/pkg/vector"
+)
@@ -1,13 +1,55 @@
 package app
 
 import (
+	"time"
+
 	"github.com/spf13/pflag"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
 )
 
 func (s *LogManagerServer) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&s.Cfg.LogConfigDir, "log-config-dir", "", "The dir where to store the log config files")
 	fs.StringVar(&s.Cfg.Name, "name", "", "The name of logmanager instance")
 	fs.StringVar(&s.Cfg.Namespace, "namespace", "", "The namespace of logmanger instance")
-	fs.StringVar(&s.Cfg.AgentType, "agent-type", "logkit", "the agent type that used to collect logs")
+	fs.StringVar(&s.Cfg.AgentType, "agent-type", "logkit", "the default agent type that used to collect logs, a log config can choose another one by agent_type, "+agent.DescribeBackends())
+	fs.StringVar(&s.Cfg.Scheduler, "scheduler", "least-count", "the algorithm to schedule log sources to log agents, [least-count], [least-bytes], [consistent-hash] or [controller-affinity]")
+	fs.StringVar(&s.Cfg.ConfFileMode, "agent-conf-file-mode", "0644", "the file mode of the config files written for log agents")
+	fs.StringVar(&s.Cfg.ConfFileOwner, "agent-conf-file-owner", "", "the owner(uid:gid) of the config files written for log agents, default is the user of logmanager")
+	fs.StringVar(&s.Cfg.AgentImage, "agent-image", "", "the image of log agents, default is decided by the agent type")
+	fs.Int32Var(&s.Cfg.AgentReplicas, "agent-replicas", 1, "the replicas of log agents deployed by logmanager")
+	fs.StringVar(&s.Cfg.AgentMode, "agent-mode", "deployment", "the workload of log agents, [deployment] or [daemonset], the node-local logs on hostpath or emptydir volumes are only collected in daemonset mode")
+	fs.StringVar(&s.Cfg.AgentCPURequest, "agent-cpu-request", "", "the cpu request of log agents")
+	fs.StringVar(&s.Cfg.AgentMemoryRequest, "agent-memory-request", "", "the memory request of log agents")
+	fs.StringVar(&s.Cfg.AgentCPULimit, "agent-cpu-limit", "", "the cpu limit of log agents")
+	fs.StringVar(&s.Cfg.AgentMemoryLimit, "agent-memory-limit", "", "the memory limit of log agents")
+	fs.StringVar(&s.Cfg.AgentConfClaim, "agent-conf-claim", "", "the pvc shared by logmanager and log agents to store the config files of agents, which is only mounted by logmanager in daemonset mode")
+	fs.StringVar(&s.Cfg.AgentConfServer, "agent-conf-server", "", "the address of the conf server of logmanager reached by log agents, from which the agents of daemonset sync their config files")
+	fs.StringVar(&s.Cfg.AgentAPIUsername, "agent-api-username", "", "the username of the basic auth of the http api of log agents, empty means no auth")
+	fs.StringVar(&s.Cfg.AgentAPIPassword, "agent-api-password", "", "the password of the basic auth of the http api of log agents")
+	fs.DurationVar(&s.Cfg.AgentAPITimeout, "agent-api-timeout", 5*time.Second, "the timeout of the requests to the http api of log agents")
+	fs.Int32Var(&s.Cfg.AutoscaleMinReplicas, "autoscale-min-replicas", 1, "the min replicas of log agents when autoscale is enabled")
+	fs.Int32Var(&s.Cfg.AutoscaleMaxReplicas, "autoscale-max-replicas", 0, "the max replicas of log agents, 0 means autoscale is disabled")
+	fs.IntVar(&s.Cfg.AutoscaleSourcesPerAgent, "autoscale-sources-per-agent", 50, "the target count of log sources per log agent")
+	fs.Float64Var(&s.Cfg.AutoscaleLagRatio, "autoscale-lag-ratio", 0, "scale up one log agent when the ratio of lagging log sources exceeds it, 0 means lag is not considered")
+	fs.DurationVar(&s.Cfg.AutoscaleInterval, "autoscale-interval", time.Minute, "the interval to scale log agents")
+	fs.Float64Var(&s.Cfg.RebalanceThreshold, "rebalance-threshold", 0, "rebalance when the log sources of the most loaded agent exceed the average by this ratio, 0 means rebalance is disabled")
+	fs.IntVar(&s.Cfg.RebalanceMaxMoves, "rebalance-max-moves", 10, "the max count of log sources moved in one rebalance cycle")
+	fs.DurationVar(&s.Cfg.RebalanceCooldown, "rebalance-cooldown", 30*time.Minute, "the min interval between two rebalance moves of one log source")
+	fs.DurationVar(&s.Cfg.RebalanceInterval, "rebalance-interval", time.Minute, "the interval to rebalance log sources among log agents")
+	fs.IntVar(&s.Cfg.AgentMaxSources, "agent-max-sources", 0, "the max count of log sources of one log agent, 0 means unlimited")
+	fs.Float64Var(&s.Cfg.AgentMaxBytesRate, "agent-max-bytes-rate", 0, "the max bytes/s of the log sources of one log agent, 0 means unlimited")
+	fs.DurationVar(&s.Cfg.SampleInterval, "sample-interval", time.Minute, "the interval to sample the log volume of log sources, 0 means disabled")
+	fs.Float64Var(&s.Cfg.SampleAlpha, "sample-alpha", 0.3, "the weight of the newest sample in the moving average of bytes/s, in (0, 1]")
+	fs.DurationVar(&s.Cfg.MoveStopTimeout, "move-stop-timeout", 2*time.Minute, "the max time to wait for the old log agent to stop collecting a moved log source")
+	fs.DurationVar(&s.Cfg.StatusInterval, "status-interval", time.Minute, "the interval to collect the status of runners from log agents, 0 means disabled")
+	fs.StringVar(&s.Cfg.MetricsAddr, "metrics-addr", "", "the address to serve metrics and the admin api which is not authenticated, such as 127.0.0.1:9100, empty means disabled")
+	fs.StringVar(&s.Cfg.AgentConfAddr, "agent-conf-addr", "", "the address of the conf server which serves the config files to the agents of daemonset, authenticated by the token in the secret <name>-conf-token, such as :9200")
+	fs.StringVar(&s.Cfg.WebhookAddr, "webhook-addr", "", "the address to serve the webhook which injects the sidecar log agents, empty means disabled, it should be registered by a MutatingWebhookConfiguration of pods at path /mutate")
+	fs.StringVar(&s.Cfg.WebhookCertFile, "webhook-cert-file", "/etc/kirklog/webhook/tls.crt", "the tls cert file of the webhook")
+	fs.StringVar(&s.Cfg.WebhookKeyFile, "webhook-key-file", "/etc/kirklog/webhook/tls.key", "the tls key file of the webhook")
+	fs.DurationVar(&s.Cfg.GCInterval, "gc-interval", 5*time.Minute, "the interval to collect the orphaned config files of log agents, 0 means disabled")
+	fs.DurationVar(&s.Cfg.GCGracePeriod, "gc-grace-period", time.Minute, "the config files modified within the grace period are never collected")
+	fs.StringVar(&s.Cfg.GCMode, "gc-mode", "quarantine", "the way to collect the orphaned config files, [remove] or [quarantine]")
 	fs.IntVar(&s.logLevel, "log-level", 5, "the log level, [0]:Panic, [1]:Fatal, [2]:Error, [3]:Error, [4]:Warn, [5]:Info, [6]:Debug, default is info")
 }
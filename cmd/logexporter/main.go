@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+
+	"github.com/fatsheep9146/kirklog/pkg/logexporter"
+)
+
+func main() {
+	confDir := pflag.String("conf-dir", "", "the dir of the runner config files written by logmanager")
+	listen := pflag.String("listen", ":8090", "the address to serve the status of runners")
+	scanInterval := pflag.Duration("scan-interval", logexporter.DefaultScanInterval, "the interval to scan the conf dir and the log dirs")
+	pflag.Parse()
+
+	if *confDir == "" {
+		fmt.Fprintf(os.Stderr, "error: --conf-dir is required\n")
+		os.Exit(1)
+	}
+
+	exporter := logexporter.NewExporter(*confDir, *scanInterval)
+
+	mux := http.NewServeMux()
+	mux.Handle(logexporter.RunnersPath, exporter)
+	go func() {
+		err := http.ListenAndServe(*listen, mux)
+		if err != nil {
+			log.Fatalf("Serve the api of logexporter failed, err: %v", err)
+		}
+	}()
+
+	// The pending batches are shipped before exiting
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	exporter.Run(stop)
+}
@@ -1,27 +1,47 @@
 package logmanager
 
 import (
-	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
 )
 
 type LogSourceAction string
 
+// The steps to move a logSource between agents
+const (
+	MoveStepRemoving   = "Removing"
+	MoveStepWaiting    = "WaitingForStop"
+	MoveStepAdding     = "Adding"
+	MoveStepRolledBack = "RolledBack"
+)
+
 const (
 	LogSourceAdd LogSourceAction = "LogSourceAdd"
 	LogSourceDel LogSourceAction = "LogSourceDel"
 	LogSourceMov LogSourceAction = "LogSourceMov"
+	LogSourceUpd LogSourceAction = "LogSourceUpd"
 	LogSourceNop LogSourceAction = "LogSourceNop"
+
+	// The logSource can not be scheduled to any agent for now
+	LogSourcePending LogSourceAction = "LogSourcePending"
 )
 
-func judgeAction(m *Match) LogSourceAction {
+// Judge the action of the match, confAgent is the agent which the config file of the match belongs to
+func judgeAction(m *Match, confAgent string) LogSourceAction {
 	if m.PodName != "" && m.AgentName != "" && m.ConfPath == "" {
 		return LogSourceAdd
 	} else if m.PodName == "" && m.AgentName != "" && m.ConfPath != "" {
 		return LogSourceDel
-	} else if m.PodName != "" && m.AgentName != "" && m.ConfPath != "" && strings.Index(m.ConfPath, m.AgentName) == -1 {
+	} else if m.PodName != "" && m.AgentName != "" && m.ConfPath != "" && confAgent != m.AgentName {
 		return LogSourceMov
+	} else if m.PodName != "" && m.AgentName != "" && m.ConfPath != "" && m.Version != m.ConfVersion {
+		return LogSourceUpd
+	} else if m.PodName != "" && m.AgentName == "" {
+		return LogSourcePending
 	} else {
 		return LogSourceNop
 	}
@@ -36,16 +56,43 @@ func (lm *LogManager) logSourceAddFunc(key string) (bool, error) {
 	})
 
 	logSource := lm.LogSources[key]
+	manager := lm.agentManagerOf(logSource)
 	logAgentName := lm.Match[key].AgentName
+	version := lm.Match[key].Version
 
 	logger.Infof("Add logsource %s to agent %s", logSource.Meta.Name, logAgentName)
-	filePath, err := lm.LogAgentManager.AddConfig(logSource, logAgentName)
+	filePath, err := manager.AddConfig(logSource, logAgentName)
 	if err != nil {
 		logger.Error("Add config failed, err: %v", err)
 		return false, err
 	}
 	// Add log config file to logAgent
 	lm.Match[key].ConfPath = filePath
+	lm.Match[key].ConfVersion = version
+	return true, nil
+}
+
+// Re-render the config file of logSource in the same logAgent, for example when the secrets it references change
+func (lm *LogManager) logSourceUpdFunc(key string) (bool, error) {
+	logger := log.WithFields(log.Fields{
+		"func":   "sync",
+		"action": "logSourceUpd",
+		"key":    key,
+	})
+
+	logSource := lm.LogSources[key]
+	manager := lm.agentManagerOf(logSource)
+	logAgentName := lm.Match[key].AgentName
+	version := lm.Match[key].Version
+
+	logger.Infof("Update logsource %s in agent %s", logSource.Meta.Name, logAgentName)
+	filePath, err := manager.AddConfig(logSource, logAgentName)
+	if err != nil {
+		logger.Errorf("Update config failed, err: %v", err)
+		return false, err
+	}
+	lm.Match[key].ConfPath = filePath
+	lm.Match[key].ConfVersion = version
 	return true, nil
 }
 
@@ -57,13 +104,14 @@ func (lm *LogManager) logSourceDelFunc(key string) (bool, error) {
 	})
 	// Check the log lag
 	logSource := lm.LogSources[key]
+	manager := lm.agentManagerOf(logSource)
 	logAgentName := lm.Match[key].AgentName
 	logger.Infof("Start removing logSource %s", key)
 
 	// For now we just remove config
 	// if logSource.Status.LogStatus.Done {
 	// If the log is done collecting, then delete this logSource and config
-	err := lm.LogAgentManager.DelConfig(logSource, logAgentName)
+	err := manager.DelConfig(logSource, logAgentName)
 	if err != nil {
 		logger.Error("Add config failed, err: %v", err)
 		return false, err
@@ -81,6 +129,9 @@ func (lm *LogManager) logSourceDelFunc(key string) (bool, error) {
 	// }
 }
 
+// Move the logSource from the old agent to the new agent in steps, so that the two agents never collect it at the same time:
+// remove the config from the old agent, wait for the old agent to confirm the runner stopped, and add the config to the new agent.
+// The step is recorded in the match, so that a retried move continues from where it failed.
 func (lm *LogManager) logSourceMovFunc(key string) (bool, error) {
 	logger := log.WithFields(log.Fields{
 		"func":   "sync",
@@ -88,26 +139,102 @@ func (lm *LogManager) logSourceMovFunc(key string) (bool, error) {
 		"key":    key,
 	})
 
-	// Get old agent name from conf path
 	logSource := lm.LogSources[key]
-	newLogAgentName := lm.Match[key].AgentName
-	logConfPath := lm.Match[key].ConfPath
-	oldLogAgentName := lm.LogAgentManager.GetAgentNameFromConf(logConfPath)
+	manager := lm.agentManagerOf(logSource)
+	m := lm.Match[key]
+	newLogAgentName := m.AgentName
+
+	// Get old agent name from conf path when the move starts
+	if m.MoveStep == "" || m.MoveStep == MoveStepRolledBack || m.MoveTo != newLogAgentName {
+		m.MoveStep = MoveStepRemoving
+		m.MoveFrom = manager.GetAgentNameFromConf(m.ConfPath)
+		m.MoveTo = newLogAgentName
+		m.MoveStarted = time.Now()
+	}
+	oldLogAgentName := m.MoveFrom
+	defer func() {
+		logSource.Status.ConfigStatus.MoveStep = m.MoveStep
+	}()
+
+	if m.MoveStep == MoveStepRemoving {
+		logger.Infof("Remove the config of logSource %s from old agent %s", key, oldLogAgentName)
+		err := manager.DelConfig(logSource, oldLogAgentName)
+		if err != nil {
+			logger.Errorf("Delete old config failed, err: %v", err)
+			return false, err
+		}
+		m.MoveStep = MoveStepWaiting
+	}
 
-	// remove old agent config
-	err := lm.LogAgentManager.DelConfig(logSource, oldLogAgentName)
-	if err != nil {
-		logger.Error("Delete old config failed, err: %v", err)
-		return false, err
+	if m.MoveStep == MoveStepWaiting {
+		stopped, err := lm.runnerStopped(logSource, oldLogAgentName)
+		if err != nil {
+			logger.Warnf("Check the runner of old agent %s failed, err: %v", oldLogAgentName, err)
+		}
+		if !stopped && time.Since(m.MoveStarted) < lm.MoveStopTimeout {
+			logger.Infof("Wait for old agent %s to stop collecting logSource %s", oldLogAgentName, key)
+			return false, nil
+		}
+		if !stopped {
+			logger.Warnf("Old agent %s does not confirm the runner stopped in %v, continue moving", oldLogAgentName, lm.MoveStopTimeout)
+		}
+		m.MoveStep = MoveStepAdding
 	}
 
 	// add new agent conf
-	filePath, err := lm.LogAgentManager.AddConfig(logSource, newLogAgentName)
+	version := m.Version
+	filePath, err := manager.AddConfig(logSource, newLogAgentName)
 	if err != nil {
-		logger.Error("Add new config failed, err: %v", err)
-		return false, err
+		logger.Errorf("Add new config failed, err: %v", err)
+
+		// Roll back to the old agent, so that the logSource is still collected
+		oldFilePath, rollbackErr := manager.AddConfig(logSource, oldLogAgentName)
+		if rollbackErr != nil {
+			logger.Errorf("Roll back to old agent %s failed, err: %v", oldLogAgentName, rollbackErr)
+			return false, err
+		}
+		logger.Infof("Roll back logSource %s to old agent %s", key, oldLogAgentName)
+		m.AgentName = oldLogAgentName
+		m.ConfPath = oldFilePath
+		m.ConfVersion = version
+		m.MoveStep = MoveStepRolledBack
+		return true, nil
 	}
-	lm.Match[key].ConfPath = filePath
+	m.ConfPath = filePath
+	m.ConfVersion = version
+	m.MoveStep = ""
+	logger.Infof("Move logSource %s from agent %s to agent %s succeeded", key, oldLogAgentName, newLogAgentName)
 
 	return true, nil
 }
+
+// Check whether the agent stops collecting the logSource, the agent which can not be checked is regarded as stopped
+func (lm *LogManager) runnerStopped(logSource *api.LogSource, agentName string) (bool, error) {
+	checker, ok := lm.agentManagerOf(logSource).(agent.RunnerChecker)
+	if !ok {
+		return true, nil
+	}
+	return checker.RunnerStopped(logSource, agentName)
+}
+
+// Return the AgentManager of the agent type of the logSource
+func (lm *LogManager) agentManagerOf(logSource *api.LogSource) agent.AgentManager {
+	return lm.LogAgentManagers[agent.AgentType(logSource.Spec.AgentType)]
+}
+
+// Return the agent which has the config file of the logSource of key, "" if the config is not added to any agent
+func (lm *LogManager) confAgentOf(key string) string {
+	m, exist := lm.Match[key]
+	if !exist || m.ConfPath == "" {
+		return ""
+	}
+	logSource, exist := lm.LogSources[key]
+	if !exist {
+		return ""
+	}
+	manager := lm.agentManagerOf(logSource)
+	if manager == nil {
+		return ""
+	}
+	return manager.GetAgentNameFromConf(m.ConfPath)
+}
@@ -1,17 +1,26 @@
 package agent
 
 import (
+	"time"
+
 	"k8s.io/client-go/kubernetes"
 
 	"github.com/fatsheep9146/kirklog/pkg/api"
+	"github.com/fatsheep9146/kirklog/pkg/secret"
 )
 
 type AgentType string
 
 const (
-	Logkit      AgentType = "logkit"
-	LogExporter AgentType = "logexporter"
-	PiliDsync   AgentType = "pili-dsync"
+	Logkit        AgentType = "logkit"
+	LogkitAPI     AgentType = "logkit-api"
+	LogkitSidecar AgentType = "logkit-sidecar"
+	Fluentbit     AgentType = "fluentbit"
+	Filebeat      AgentType = "filebeat"
+	Vector        AgentType = "vector"
+	LogExporter   AgentType = "logexporter"
+	Embedded      AgentType = "embedded"
+	PiliDsync     AgentType = "pili-dsync"
 )
 
 type AgentManagerConfig struct {
@@ -24,9 +33,31 @@ type AgentManagerConfig struct {
 	// The logConfigs that the logManager needed to collect
 	LogConfigs []api.LogConfig
 
+	// The store used to resolve the secrets referenced by the config of logSources
+	Secrets *secret.Store
+
+	// The ownership and mode of the config files written for log agents
+	ConfFileOptions FileOptions
+
+	// The config used to deploy the log agent components
+	DeployConfig DeployConfig
+
+	// The config used to call the http api of log agents
+	APIConfig APIConfig
+
 	Cli *kubernetes.Clientset
 }
 
+// The config used to call the http api of log agents
+type APIConfig struct {
+	// The basic auth of the api, empty username means no auth
+	Username string
+	Password string
+
+	// The timeout of every request
+	Timeout time.Duration
+}
+
 type AgentManager interface {
 	// This function is use to deploy a log agent deployment
 	Deploy() error
@@ -47,6 +78,28 @@ type AgentManager interface {
 	GetAgentNameFromConf(confpath string) string
 }
 
+// Scaler is implemented by the AgentManager whose log agents can be scaled
+type Scaler interface {
+	// Return the desired replicas of log agents
+	GetReplicas() (int32, error)
+
+	// Scale log agents to replicas, the agents in victims are removed when scaling down
+	Scale(replicas int32, victims []string) error
+}
+
+// RunnerChecker is implemented by the AgentManager which can confirm that a log agent stops collecting a logSource
+type RunnerChecker interface {
+	// Return true if the agent does not collect the logSource anymore
+	RunnerStopped(logSource *api.LogSource, agent string) (bool, error)
+}
+
+// StatusCollector is implemented by the AgentManager which can report the status of the runners of log agents
+type StatusCollector interface {
+	// Return the status of the runners of logSources in the agent keyed by the name of logSource,
+	// the logSource whose runner is not found is absent
+	CollectStatus(agent string, logSources []*api.LogSource) (map[string]api.RunnerStatus, error)
+}
+
 type Agent struct {
 
 	// The pod name of this log agent instance
@@ -54,4 +107,19 @@ type Agent struct {
 
 	// The config path for this agent to read log source config
 	ConfPath string `json:"path"`
+
+	// The order of this agent by creation time, the newest agent has the highest ordinal
+	Ordinal int `json:"ordinal"`
+
+	// The pod IP of this log agent instance, used to query its status
+	IP string `json:"ip"`
+
+	// The type of this log agent, which is set by logmanager after listing the agents of every type
+	Type AgentType `json:"type"`
+
+	// The node whose node-local logs this log agent collects, only the agents of daemonset have it
+	Node string `json:"node,omitempty"`
+
+	// The pod whose logs this log agent collects in "<namespace>/<name>", only the sidecar agents have it
+	Pod string `json:"pod,omitempty"`
 }
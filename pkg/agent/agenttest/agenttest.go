@@ -0,0 +1,39 @@
+// Package agenttest has the fixtures shared by the tests of agent backends
+package agenttest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+// Return the logSource of log stream applog of pod test-xxx-yyy, which is on pvc of deployment test
+func NewLogSource(config string) *api.LogSource {
+	return &api.LogSource{
+		Meta: api.Meta{
+			Name: "deployment_test_applog_test-xxx-yyy",
+		},
+		Spec: api.LogSourceSpec{
+			PodName:        "test-xxx-yyy",
+			Namespace:      "test-ns",
+			NodeName:       "node-1",
+			ControllerName: "deployment_test",
+			Stream:         "applog",
+			VolumeMount:    "applog",
+			PodLabels:      map[string]string{"team": "infra"},
+			Config:         config,
+		},
+	}
+}
+
+// Check that render rejects every config of the log stream, and the error has the reason of the config
+func CheckRenderInvalid(t *testing.T, render agent.RenderFunc, reasons map[string]string) {
+	for config, reason := range reasons {
+		_, err := render(NewLogSource(config), nil)
+		if err == nil || !strings.Contains(err.Error(), reason) {
+			t.Errorf("expect config %q rejected by %q, got %v", config, reason, err)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+// Compiler compiles the agent-neutral collect spec, whose templates are rendered already, into the config of log stream
+type Compiler func(spec *api.CollectSpec) (string, error)
+
+// Render the config of log stream of logSource. The collect spec is rendered and compiled by compile if it is set,
+// otherwise the raw config is rendered, whose values are escaped by format, the Schema.Format of the backend.
+// The secrets are the resolved values, the result should never be logged.
+func RenderConfig(logSource *api.LogSource, secrets map[string]string, format string, compile Compiler) (string, error) {
+	if logSource.Spec.Collect == nil {
+		return logSource.RenderConfigTemplate(secrets, format)
+	}
+
+	spec, err := logSource.RenderCollectSpec(secrets)
+	if err != nil {
+		return "", err
+	}
+	config, err := compile(spec)
+	if err != nil {
+		return "", fmt.Errorf("compile collect spec of logSource %s failed, err: %v", logSource.Meta.Name, err)
+	}
+	return config, nil
+}
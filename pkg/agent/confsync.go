@@ -0,0 +1,185 @@
+package agent
+
+import (
+	"archive/tar"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// The api of the conf server of logmanager serving the config files of one agent as a tar, followed by the
+	// agent name
+	ConfsAPIPath = "/api/v1/confs/"
+
+	// The key of the token in the conf token secret, which the agents of daemonset send to the conf server
+	ConfTokenKey = "token"
+
+	// The image of the conf-sync container, which needs a shell with wget, tar and cmp
+	DefaultConfSyncImage = "busybox:1.36"
+
+	// The interval to sync the config files in seconds
+	ConfSyncInterval = 5
+)
+
+// Sync the config files of this agent from logmanager into its conf dir, and post to the reload url if any file
+// changes. The files are renamed into the conf dir, so that the agent never reads a partial file. The config files
+// hold the values of secrets, so the conf server only serves the requests with the conf token.
+const confSyncCommand = `mkdir -p %[1]s/$POD_NAME
+while true; do
+  rm -rf /tmp/confs && mkdir /tmp/confs
+  if wget -q --header "Authorization: Bearer $CONF_TOKEN" -O /tmp/confs.tar "http://$CONF_SERVER%[2]s$POD_NAME" && tar -x -f /tmp/confs.tar -C /tmp/confs; then
+    changed=
+    for f in %[1]s/$POD_NAME/*; do
+      if [ -f "$f" ] && [ ! -f "/tmp/confs/${f##*/}" ]; then rm -f "$f" && changed=1; fi
+    done
+    for f in /tmp/confs/*; do
+      [ -f "$f" ] || continue
+      if ! cmp -s "$f" "%[1]s/$POD_NAME/${f##*/}"; then
+        cp "$f" "%[1]s/.${f##*/}" && mv "%[1]s/.${f##*/}" "%[1]s/$POD_NAME/${f##*/}" && changed=1
+      fi
+    done
+    if [ -n "$changed" ] && [ -n "$RELOAD_URL" ]; then wget -q -O /dev/null --post-data "" "$RELOAD_URL"; fi
+  fi
+  sleep %[3]d
+done`
+
+// Return the volume of the conf dirs of agents mounted at confDir, and the containers delivering the config files
+// into it. The agents of deployment share the conf pvc with logmanager. The agents of daemonset run on every node,
+// where the conf pvc may not be mounted, so their conf dir is an emptyDir synced from the conf server of logmanager,
+// and reloadURL is posted after syncing if the agents do not watch their conf dirs.
+func GetConfVolume(name, claimName, confDir string, config DeployConfig, reloadURL string) (v1.Volume, v1.VolumeMount, []v1.Container) {
+	volumeMount := v1.VolumeMount{
+		Name:      name,
+		MountPath: confDir,
+	}
+	if config.Mode != DeployModeDaemonSet {
+		return v1.Volume{
+			Name: name,
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: claimName,
+				},
+			},
+		}, volumeMount, nil
+	}
+
+	volume := v1.Volume{
+		Name: name,
+		VolumeSource: v1.VolumeSource{
+			EmptyDir: &v1.EmptyDirVolumeSource{},
+		},
+	}
+	container := v1.Container{
+		Name:    "conf-sync",
+		Image:   DefaultConfSyncImage,
+		Command: []string{"/bin/sh", "-c", fmt.Sprintf(confSyncCommand, confDir, ConfsAPIPath, ConfSyncInterval)},
+		Env: []v1.EnvVar{
+			{
+				Name: "POD_NAME",
+				ValueFrom: &v1.EnvVarSource{
+					FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.name"},
+				},
+			},
+			{Name: "CONF_SERVER", Value: config.ConfServer},
+			{
+				Name: "CONF_TOKEN",
+				ValueFrom: &v1.EnvVarSource{
+					SecretKeyRef: &v1.SecretKeySelector{
+						LocalObjectReference: v1.LocalObjectReference{Name: config.ConfTokenSecret},
+						Key:                  ConfTokenKey,
+					},
+				},
+			},
+			{Name: "RELOAD_URL", Value: reloadURL},
+		},
+		VolumeMounts: []v1.VolumeMount{volumeMount},
+	}
+	return volume, volumeMount, []v1.Container{container}
+}
+
+// Return the name of the secret of the conf token of the agents of logmanager name
+func GetConfTokenSecretName(name string) string {
+	return fmt.Sprintf("%s-conf-token", name)
+}
+
+// Return the conf token kept in the secret, which is generated when the secret is created. The token is never
+// rotated by logmanager, since the running agents read it only when they start.
+func EnsureConfToken(cli *kubernetes.Clientset, namespace, name string, ownerReferences []metav1.OwnerReference) (string, error) {
+	secrets := cli.CoreV1().Secrets(namespace)
+	old, err := secrets.Get(name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return "", err
+	}
+	if err == nil && len(old.Data[ConfTokenKey]) != 0 {
+		return string(old.Data[ConfTokenKey]), nil
+	}
+
+	buf := make([]byte, 32)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	err = ApplySecret(cli, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       namespace,
+			OwnerReferences: ownerReferences,
+		},
+		Data: map[string][]byte{ConfTokenKey: []byte(token)},
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Check whether the request to the conf server has the conf token
+func CheckConfToken(r *http.Request, token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) == 1
+}
+
+// Write the config files in dir as a tar, no file is written if dir does not exist
+func WriteConfTar(w io.Writer, dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	for _, file := range files {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return err
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Name:    file.Name(),
+			Mode:    int64(file.Mode().Perm()),
+			Size:    int64(len(data)),
+			ModTime: file.ModTime(),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
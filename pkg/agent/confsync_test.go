@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestWriteConfTar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kirklog-confs")
+	if err != nil {
+		t.Fatalf("create temp dir failed, err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "a.conf"), []byte("a"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "b.conf"), []byte("bb"), 0600)
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+
+	buf := &bytes.Buffer{}
+	err = WriteConfTar(buf, dir)
+	if err != nil {
+		t.Fatalf("write conf tar failed, err: %v", err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(buf)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		data, _ := ioutil.ReadAll(tr)
+		files[header.Name] = string(data)
+	}
+	if len(files) != 2 || files["a.conf"] != "a" || files["b.conf"] != "bb" {
+		t.Errorf("only the config files should be in tar, files are %v", files)
+	}
+
+	// The conf dir not created yet is an empty tar
+	buf.Reset()
+	err = WriteConfTar(buf, filepath.Join(dir, "not-exist"))
+	if err != nil {
+		t.Errorf("write conf tar of dir not exist failed, err: %v", err)
+	}
+	if _, err := tar.NewReader(buf).Next(); err == nil {
+		t.Errorf("tar of dir not exist should be empty")
+	}
+}
+
+func TestEnsureConfToken(t *testing.T) {
+	var created *v1.Secret
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && created != nil:
+			json.NewEncoder(w).Encode(created)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/namespaces/kirklog-ns/secrets":
+			created = &v1.Secret{}
+			json.NewDecoder(r.Body).Decode(created)
+			json.NewEncoder(w).Encode(created)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	cli, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("create client failed, err: %v", err)
+	}
+
+	token, err := EnsureConfToken(cli, "kirklog-ns", "kirklog-conf-token", nil)
+	if err != nil || len(token) != 64 || created == nil || string(created.Data[ConfTokenKey]) != token {
+		t.Fatalf("conf token should be generated into the secret, token: %s, err: %v", token, err)
+	}
+
+	// The token is kept after logmanager restarts
+	again, err := EnsureConfToken(cli, "kirklog-ns", "kirklog-conf-token", nil)
+	if err != nil || again != token {
+		t.Errorf("conf token should not be rotated, is %s, err: %v", again, err)
+	}
+}
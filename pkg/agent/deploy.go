@@ -0,0 +1,457 @@
+package agent
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+const (
+	// The label of the log agent pods which indicates the logmanager they belong to
+	ManagerLabel = "kirklog.io/manager"
+
+	// The interval and timeout to wait for the victims of scaling down to terminate
+	VictimPollInterval     = time.Second
+	VictimTerminateTimeout = 2 * time.Minute
+)
+
+// DeployMode is the kind of workload which runs the log agents
+type DeployMode string
+
+const (
+	// The agents are a Deployment, which collect the logs on pvc of any node
+	DeployModeDeployment DeployMode = "deployment"
+
+	// The agents are a DaemonSet, every agent collects the node-local logs of its node besides the logs on pvc
+	DeployModeDaemonSet DeployMode = "daemonset"
+)
+
+// The config used to deploy the log agent components
+type DeployConfig struct {
+	// The image of log agent
+	Image string
+
+	// The replicas of log agent deployment
+	Replicas int32
+
+	// The resource requirements of log agent container
+	Resources v1.ResourceRequirements
+
+	// The pvc shared by logmanager and log agents, which holds the config files of agents
+	ConfClaimName string
+
+	// The kind of workload of log agents, default is deployment
+	Mode DeployMode
+
+	// The address of the conf server of logmanager reached by log agents, from which the agents of daemonset sync
+	// their config files
+	ConfServer string
+
+	// The secret of the conf token, which the agents of daemonset send to the conf server
+	ConfTokenSecret string
+}
+
+// Parse the resource requirements of log agent container, empty quantity is not set
+func ParseResources(cpuRequest, memoryRequest, cpuLimit, memoryLimit string) (v1.ResourceRequirements, error) {
+	resources := v1.ResourceRequirements{
+		Requests: v1.ResourceList{},
+		Limits:   v1.ResourceList{},
+	}
+
+	quantities := []struct {
+		list  v1.ResourceList
+		name  v1.ResourceName
+		value string
+	}{
+		{resources.Requests, v1.ResourceCPU, cpuRequest},
+		{resources.Requests, v1.ResourceMemory, memoryRequest},
+		{resources.Limits, v1.ResourceCPU, cpuLimit},
+		{resources.Limits, v1.ResourceMemory, memoryLimit},
+	}
+	for _, q := range quantities {
+		if q.value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(q.value)
+		if err != nil {
+			return resources, fmt.Errorf("invalid %s quantity %s, err: %v", q.name, q.value, err)
+		}
+		q.list[q.name] = quantity
+	}
+
+	return resources, nil
+}
+
+// Return the volumes and volumeMounts of all the log streams on pvc, every volume is mounted at "/<controller>_<volume>",
+// which is the same as the mountPath of logmanager.
+func GetLogVolumes(logConfigs []api.LogConfig) ([]v1.Volume, []v1.VolumeMount) {
+	volumes := make([]v1.Volume, 0)
+	volumeMounts := make([]v1.VolumeMount, 0)
+	visited := make(map[string]bool)
+
+	for i := range logConfigs {
+		logConfig := &logConfigs[i]
+		streams := logConfig.GetStreams()
+		for j := range streams {
+			if streams[j].IsNodeLocal() {
+				continue
+			}
+			mountPath := api.GetVolumeMountPath(logConfig.GetControllerName(), streams[j].VolumeMount)
+			if visited[mountPath] {
+				continue
+			}
+			visited[mountPath] = true
+
+			name := getVolumeName(mountPath)
+			volumes = append(volumes, v1.Volume{
+				Name: name,
+				VolumeSource: v1.VolumeSource{
+					PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+						ClaimName: logConfig.GetClaimName(&streams[j]),
+					},
+				},
+			})
+			volumeMounts = append(volumeMounts, v1.VolumeMount{
+				Name:      name,
+				MountPath: mountPath,
+			})
+		}
+	}
+
+	return volumes, volumeMounts
+}
+
+// Return the hostPath volumes and volumeMounts of the node-local log streams, every dir is mounted at the same path
+// as on the node. The emptyDir volumes of pods are reached through the pods dir of kubelet, and the stdout/stderr
+// of containers through the pod logs dir of kubelet.
+func GetNodeLogVolumes(logConfigs []api.LogConfig) ([]v1.Volume, []v1.VolumeMount) {
+	volumes := make([]v1.Volume, 0)
+	volumeMounts := make([]v1.VolumeMount, 0)
+	visited := make(map[string]bool)
+
+	for i := range logConfigs {
+		for _, stream := range logConfigs[i].GetStreams() {
+			var paths []string
+			switch {
+			case stream.IsContainer():
+				// The container logs of docker link to its json log files, which are mounted as well
+				paths = []string{api.PodLogsDir, api.DockerContainersDir}
+			case stream.VolumeType == api.VolumeHostPath:
+				paths = []string{stream.HostPath}
+			case stream.VolumeType == api.VolumeEmptyDir:
+				paths = []string{api.KubeletPodsDir}
+			default:
+				continue
+			}
+			for _, path := range paths {
+				if visited[path] {
+					continue
+				}
+				visited[path] = true
+
+				name := getVolumeName("node" + path)
+				volume := v1.Volume{
+					Name: name,
+					VolumeSource: v1.VolumeSource{
+						HostPath: &v1.HostPathVolumeSource{Path: path},
+					},
+				}
+				volumeMount := v1.VolumeMount{
+					Name:      name,
+					MountPath: path,
+				}
+				if path == api.DockerContainersDir {
+					// The nodes of other runtimes have no such dir, an empty one is created there
+					dirOrCreate := v1.HostPathDirectoryOrCreate
+					volume.HostPath.Type = &dirOrCreate
+					volumeMount.ReadOnly = true
+				}
+				volumes = append(volumes, volume)
+				volumeMounts = append(volumeMounts, volumeMount)
+			}
+		}
+	}
+
+	return volumes, volumeMounts
+}
+
+// The volume name should be a DNS-1123 label, so "/deployment_boots-gate_applog" is converted to "deployment-boots-gate-applog".
+// The long name is truncated with the hash of mountPath, so that the mount paths with the same prefix never share a name.
+func getVolumeName(mountPath string) string {
+	name := strings.Replace(strings.Replace(strings.Trim(mountPath, "/"), "_", "-", -1), "/", "-", -1)
+	if len(name) > 63 {
+		h := fnv.New32a()
+		h.Write([]byte(mountPath))
+		name = fmt.Sprintf("%s-%08x", strings.Trim(name[:54], "-"), h.Sum32())
+	}
+	return name
+}
+
+// Return the owner references of the log agent components, which is the deployment of logmanager if it exists,
+// so that the log agent components are deleted together with logmanager.
+func GetOwnerReferences(cli *kubernetes.Clientset, namespace, name string) ([]metav1.OwnerReference, error) {
+	deploy, err := cli.ExtensionsV1beta1().Deployments(namespace).Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return []metav1.OwnerReference{
+		{
+			APIVersion: "extensions/v1beta1",
+			Kind:       "Deployment",
+			Name:       deploy.Name,
+			UID:        deploy.UID,
+		},
+	}, nil
+}
+
+// Create the configmap, or update it if it exists
+func ApplyConfigMap(cli *kubernetes.Clientset, configMap *v1.ConfigMap) error {
+	old, err := cli.CoreV1().ConfigMaps(configMap.Namespace).Get(configMap.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = cli.CoreV1().ConfigMaps(configMap.Namespace).Create(configMap)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	old.Labels = configMap.Labels
+	old.OwnerReferences = configMap.OwnerReferences
+	old.Data = configMap.Data
+	_, err = cli.CoreV1().ConfigMaps(configMap.Namespace).Update(old)
+	return err
+}
+
+// Create the secret, or update it if it exists
+func ApplySecret(cli *kubernetes.Clientset, secret *v1.Secret) error {
+	old, err := cli.CoreV1().Secrets(secret.Namespace).Get(secret.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = cli.CoreV1().Secrets(secret.Namespace).Create(secret)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	old.Labels = secret.Labels
+	old.OwnerReferences = secret.OwnerReferences
+	old.Data = secret.Data
+	old.StringData = secret.StringData
+	_, err = cli.CoreV1().Secrets(secret.Namespace).Update(old)
+	return err
+}
+
+// Create the deployment, or update it if it exists
+func ApplyDeployment(cli *kubernetes.Clientset, deploy *v1beta1.Deployment) error {
+	old, err := cli.ExtensionsV1beta1().Deployments(deploy.Namespace).Get(deploy.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = cli.ExtensionsV1beta1().Deployments(deploy.Namespace).Create(deploy)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	// The selector of deployment is immutable, so only the others are updated. The replicas are only set when
+	// creating, after which they are owned by the autoscaler
+	old.Labels = deploy.Labels
+	old.OwnerReferences = deploy.OwnerReferences
+	old.Spec.Template = deploy.Spec.Template
+	_, err = cli.ExtensionsV1beta1().Deployments(deploy.Namespace).Update(old)
+	return err
+}
+
+// Apply the workload of log agents in the deploy mode. The deployment built by the backend is applied as it is,
+// or converted to the DaemonSet of the same name whose pods mount the node-local log volumes too. The workload
+// of the other mode is deleted, so that the mode can be switched.
+func ApplyWorkload(cli *kubernetes.Clientset, deploy *v1beta1.Deployment, config DeployConfig, logConfigs []api.LogConfig) error {
+	deletePolicy := metav1.DeletePropagationBackground
+	deleteOptions := &metav1.DeleteOptions{PropagationPolicy: &deletePolicy}
+
+	if config.Mode != DeployModeDaemonSet {
+		err := cli.ExtensionsV1beta1().DaemonSets(deploy.Namespace).Delete(deploy.Name, deleteOptions)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		return ApplyDeployment(cli, deploy)
+	}
+
+	// The agents of every node mount the log pvcs at the same time, which must be shared among nodes
+	for _, volume := range deploy.Spec.Template.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		err := checkSharedClaim(cli, deploy.Namespace, volume.PersistentVolumeClaim.ClaimName)
+		if err != nil {
+			return err
+		}
+	}
+
+	template := deploy.Spec.Template.DeepCopy()
+	volumes, volumeMounts := GetNodeLogVolumes(logConfigs)
+	template.Spec.Volumes = append(template.Spec.Volumes, volumes...)
+	for i := range template.Spec.Containers {
+		template.Spec.Containers[i].VolumeMounts = append(template.Spec.Containers[i].VolumeMounts, volumeMounts...)
+	}
+	daemonSet := &v1beta1.DaemonSet{
+		ObjectMeta: deploy.ObjectMeta,
+		Spec: v1beta1.DaemonSetSpec{
+			Selector: deploy.Spec.Selector,
+			Template: *template,
+			UpdateStrategy: v1beta1.DaemonSetUpdateStrategy{
+				Type: v1beta1.RollingUpdateDaemonSetStrategyType,
+			},
+		},
+	}
+	err := ApplyDaemonSet(cli, daemonSet)
+	if err != nil {
+		return err
+	}
+
+	err = cli.ExtensionsV1beta1().Deployments(deploy.Namespace).Delete(deploy.Name, deleteOptions)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// Check that the pvc can be mounted by the pods on different nodes
+func checkSharedClaim(cli *kubernetes.Clientset, namespace, name string) error {
+	claim, err := cli.CoreV1().PersistentVolumeClaims(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("get pvc %s failed, err: %v", name, err)
+	}
+	for _, mode := range claim.Spec.AccessModes {
+		if mode == v1.ReadWriteMany || mode == v1.ReadOnlyMany {
+			return nil
+		}
+	}
+	return fmt.Errorf("pvc %s of access modes %v can not be mounted by the log agents of daemonset on every node", name, claim.Spec.AccessModes)
+}
+
+// Create the daemonset, or update it if it exists
+func ApplyDaemonSet(cli *kubernetes.Clientset, daemonSet *v1beta1.DaemonSet) error {
+	old, err := cli.ExtensionsV1beta1().DaemonSets(daemonSet.Namespace).Get(daemonSet.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = cli.ExtensionsV1beta1().DaemonSets(daemonSet.Namespace).Create(daemonSet)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	// The selector of daemonset is immutable, so only the others are updated
+	old.Labels = daemonSet.Labels
+	old.OwnerReferences = daemonSet.OwnerReferences
+	old.Spec.Template = daemonSet.Spec.Template
+	old.Spec.UpdateStrategy = daemonSet.Spec.UpdateStrategy
+	_, err = cli.ExtensionsV1beta1().DaemonSets(daemonSet.Namespace).Update(old)
+	return err
+}
+
+// Return the pods of the log agent deployment or daemonset sorted by creation time, no pod is returned if
+// neither is deployed yet
+func ListAgentPods(cli *kubernetes.Clientset, namespace, name string) ([]v1.Pod, error) {
+	var selector *metav1.LabelSelector
+	deploy, err := cli.ExtensionsV1beta1().Deployments(namespace).Get(name, metav1.GetOptions{})
+	if err == nil {
+		selector = deploy.Spec.Selector
+	} else if errors.IsNotFound(err) {
+		daemonSet, err := cli.ExtensionsV1beta1().DaemonSets(namespace).Get(name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		selector = daemonSet.Spec.Selector
+	} else {
+		return nil, err
+	}
+
+	labelSelectors := make([]string, 0)
+	for k, v := range selector.MatchLabels {
+		labelSelectors = append(labelSelectors, fmt.Sprintf("%s=%s", k, v))
+	}
+	pods, err := cli.CoreV1().Pods(namespace).List(metav1.ListOptions{
+		LabelSelector: strings.Join(labelSelectors, ","),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The ordinal of agents follows their creation time
+	sort.Slice(pods.Items, func(i, j int) bool {
+		ti, tj := pods.Items[i].CreationTimestamp, pods.Items[j].CreationTimestamp
+		if ti.Equal(&tj) {
+			return pods.Items[i].Name < pods.Items[j].Name
+		}
+		return ti.Before(&tj)
+	})
+	return pods.Items, nil
+}
+
+// Return the desired replicas of the log agent deployment
+func GetDeploymentReplicas(cli *kubernetes.Clientset, namespace, name string) (int32, error) {
+	deploy, err := cli.ExtensionsV1beta1().Deployments(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+	if deploy.Spec.Replicas == nil {
+		return 1, nil
+	}
+	return *deploy.Spec.Replicas, nil
+}
+
+// Scale the log agent deployment. The drained victims are deleted first, and the replicas are lowered after they
+// terminate, so that the replicaset controller never removes an agent not drained. It removes the agents recreated
+// for the victims instead, which are the newest and have no logSources yet. The replicas are not lowered if the
+// victims do not terminate in time, the replacements are drained in the next scaling down then.
+func ScaleDeployment(cli *kubernetes.Clientset, namespace, name string, replicas int32, victims []string) error {
+	for _, victim := range victims {
+		err := cli.CoreV1().Pods(namespace).Delete(victim, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	err := wait.PollImmediate(VictimPollInterval, VictimTerminateTimeout, func() (bool, error) {
+		for _, victim := range victims {
+			_, err := cli.CoreV1().Pods(namespace).Get(victim, metav1.GetOptions{})
+			if err == nil {
+				return false, nil
+			}
+			if !errors.IsNotFound(err) {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("wait for victims %v to terminate failed, err: %v", victims, err)
+	}
+
+	deploy, err := cli.ExtensionsV1beta1().Deployments(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	deploy.Spec.Replicas = &replicas
+	_, err = cli.ExtensionsV1beta1().Deployments(namespace).Update(deploy)
+	return err
+}
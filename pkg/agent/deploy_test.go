@@ -0,0 +1,139 @@
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+func TestGetLogVolumes(t *testing.T) {
+	logConfigs := []api.LogConfig{
+		{
+			Name: "boots-gate",
+			Kind: "deployment",
+			Streams: []api.LogStream{
+				{VolumeMount: "applog"},
+				{VolumeMount: "auditlog", ClaimName: "audit"},
+			},
+		},
+		{
+			Name:        "boots-gate",
+			Kind:        "deployment",
+			VolumeMount: "applog",
+		},
+	}
+
+	volumes, volumeMounts := GetLogVolumes(logConfigs)
+	if len(volumes) != 2 || len(volumeMounts) != 2 {
+		t.Fatalf("the same volume should be mounted once, volumes: %d, volumeMounts: %d", len(volumes), len(volumeMounts))
+	}
+
+	if volumes[0].Name != "deployment-boots-gate-applog" || volumes[0].PersistentVolumeClaim.ClaimName != "boots-gate-applog" {
+		t.Errorf("volume 0 is wrong, is %+v", volumes[0])
+	}
+	if volumes[1].PersistentVolumeClaim.ClaimName != "audit" {
+		t.Errorf("volume 1 claim name is wrong, is %s", volumes[1].PersistentVolumeClaim.ClaimName)
+	}
+	if volumeMounts[1].MountPath != "/deployment_boots-gate_auditlog" {
+		t.Errorf("volume mount 1 path is wrong, is %s", volumeMounts[1].MountPath)
+	}
+}
+
+func TestGetVolumeName(t *testing.T) {
+	prefix := "/deployment_" + strings.Repeat("a", 60)
+	a, b := getVolumeName(prefix+"_applog"), getVolumeName(prefix+"_auditlog")
+	if len(a) > 63 || len(b) > 63 || a == b {
+		t.Errorf("long mount paths with the same prefix should have different names within 63 characters, are %s and %s", a, b)
+	}
+	if name := getVolumeName("/deployment_boots-gate_applog"); name != "deployment-boots-gate-applog" {
+		t.Errorf("short volume name should not be changed, is %s", name)
+	}
+}
+
+func TestGetConfVolume(t *testing.T) {
+	volume, volumeMount, containers := GetConfVolume("test-conf", "test-conf-claim", "/test", DeployConfig{}, "")
+	if volume.PersistentVolumeClaim == nil || volume.PersistentVolumeClaim.ClaimName != "test-conf-claim" || len(containers) != 0 {
+		t.Errorf("agents of deployment should mount the conf pvc, volume is %+v, containers %d", volume, len(containers))
+	}
+
+	// The agents of daemonset never mount the conf pvc, the conf-sync container delivers the config files
+	config := DeployConfig{Mode: DeployModeDaemonSet, ConfServer: "kirklog.kirklog-ns:8080", ConfTokenSecret: "kirklog-conf-token"}
+	volume, volumeMount, containers = GetConfVolume("test-conf", "test-conf-claim", "/test", config, "http://127.0.0.1:2020/reload")
+	if volume.EmptyDir == nil || volume.PersistentVolumeClaim != nil {
+		t.Errorf("conf dir of daemonset should be an emptyDir, is %+v", volume)
+	}
+	if len(containers) != 1 || len(containers[0].VolumeMounts) != 1 || containers[0].VolumeMounts[0] != volumeMount || volumeMount.MountPath != "/test" {
+		t.Fatalf("conf-sync container should mount the conf dir at /test, containers are %+v", containers)
+	}
+	env := make(map[string]string)
+	for _, e := range containers[0].Env {
+		env[e.Name] = e.Value
+		if e.Name == "CONF_TOKEN" && (e.ValueFrom == nil || e.ValueFrom.SecretKeyRef == nil || e.ValueFrom.SecretKeyRef.Name != "kirklog-conf-token") {
+			t.Errorf("conf token should be read from the secret kirklog-conf-token, is %+v", e.ValueFrom)
+		}
+	}
+	if _, exist := env["CONF_TOKEN"]; !exist || env["CONF_SERVER"] != "kirklog.kirklog-ns:8080" || env["RELOAD_URL"] != "http://127.0.0.1:2020/reload" {
+		t.Errorf("env of conf-sync container is wrong, is %v", env)
+	}
+}
+
+func TestScaleDeployment(t *testing.T) {
+	requests := make([]string, 0)
+	var updated v1beta1.Deployment
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/v1/namespaces/test-ns/pods/logkit-test-2" && r.Method == http.MethodDelete:
+			w.Write([]byte("{}"))
+		case r.URL.Path == "/apis/extensions/v1beta1/namespaces/test-ns/deployments/logkit-test" && r.Method == http.MethodGet:
+			replicas := int32(3)
+			json.NewEncoder(w).Encode(v1beta1.Deployment{
+				TypeMeta:   metav1.TypeMeta{Kind: "Deployment", APIVersion: "extensions/v1beta1"},
+				ObjectMeta: metav1.ObjectMeta{Name: "logkit-test", Namespace: "test-ns"},
+				Spec:       v1beta1.DeploymentSpec{Replicas: &replicas},
+			})
+		case r.URL.Path == "/apis/extensions/v1beta1/namespaces/test-ns/deployments/logkit-test" && r.Method == http.MethodPut:
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &updated)
+			w.Write(body)
+		default:
+			// The victim is terminated
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	cli, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("create client failed, err: %v", err)
+	}
+
+	err = ScaleDeployment(cli, "test-ns", "logkit-test", 2, []string{"logkit-test-2"})
+	if err != nil {
+		t.Fatalf("scale deployment failed, err: %v", err)
+	}
+
+	// The victim is deleted and terminated before the replicas are lowered
+	expected := []string{
+		"DELETE /api/v1/namespaces/test-ns/pods/logkit-test-2",
+		"GET /api/v1/namespaces/test-ns/pods/logkit-test-2",
+		"GET /apis/extensions/v1beta1/namespaces/test-ns/deployments/logkit-test",
+		"PUT /apis/extensions/v1beta1/namespaces/test-ns/deployments/logkit-test",
+	}
+	if strings.Join(requests, ",") != strings.Join(expected, ",") {
+		t.Errorf("requests of scaling down are wrong, are %v", requests)
+	}
+	if updated.Spec.Replicas == nil || *updated.Spec.Replicas != 2 {
+		t.Errorf("replicas should be lowered to 2, is %v", updated.Spec.Replicas)
+	}
+}
@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The ownership and mode of the config files written into the conf dir of log agents
+type FileOptions struct {
+	Mode os.FileMode
+
+	// The owner of the file, -1 means keeping the owner of the process
+	UID int
+	GID int
+}
+
+func DefaultFileOptions() FileOptions {
+	return FileOptions{
+		Mode: 0644,
+		UID:  -1,
+		GID:  -1,
+	}
+}
+
+// Parse the file options from the mode like "0644" and the owner like "1000:1000"
+// Empty mode or owner keeps the default one
+func ParseFileOptions(mode, owner string) (FileOptions, error) {
+	opts := DefaultFileOptions()
+
+	if mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return opts, fmt.Errorf("invalid file mode %s, err: %v", mode, err)
+		}
+		opts.Mode = os.FileMode(m)
+	}
+
+	if owner != "" {
+		ids := strings.Split(owner, ":")
+		if len(ids) != 2 {
+			return opts, fmt.Errorf("invalid file owner %s, should be uid:gid", owner)
+		}
+		uid, err := strconv.Atoi(ids[0])
+		if err != nil {
+			return opts, fmt.Errorf("invalid file owner uid %s, err: %v", ids[0], err)
+		}
+		gid, err := strconv.Atoi(ids[1])
+		if err != nil {
+			return opts, fmt.Errorf("invalid file owner gid %s, err: %v", ids[1], err)
+		}
+		opts.UID = uid
+		opts.GID = gid
+	}
+
+	return opts, nil
+}
+
+// Write the config file atomically, the content is written to a hidden temp file in the same dir first,
+// and then renamed to path, so that the log agent never reads a half-written file.
+// If the file already has the same content, nothing is written and false is returned.
+func WriteConfigFile(path string, data []byte, opts FileOptions) (bool, error) {
+	if same, err := sameContent(path, data); err != nil {
+		return false, err
+	} else if same {
+		return false, nil
+	}
+
+	dir, base := filepath.Split(path)
+	tmp, err := ioutil.TempFile(dir, fmt.Sprintf(".%s.*.tmp", base))
+	if err != nil {
+		return false, err
+	}
+	tmpPath := tmp.Name()
+	// The temp file is useless once it is renamed or anything fails
+	defer os.Remove(tmpPath)
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = os.Chmod(tmpPath, opts.Mode)
+	if err != nil {
+		return false, err
+	}
+	if opts.UID >= 0 || opts.GID >= 0 {
+		err = os.Chown(tmpPath, opts.UID, opts.GID)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Check whether the file of path has the same content hash with data
+func sameContent(path string, data []byte) (bool, error) {
+	old, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	oldSum := sha256.Sum256(old)
+	newSum := sha256.Sum256(data)
+	return bytes.Equal(oldSum[:], newSum[:]), nil
+}
@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kirklog-agent")
+	if err != nil {
+		t.Fatalf("create temp dir failed, err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "applog_test-xxx-yyy.conf")
+	opts, err := ParseFileOptions("0600", "")
+	if err != nil {
+		t.Fatalf("parse file options failed, err: %v", err)
+	}
+
+	written, err := WriteConfigFile(path, []byte(`{"name": "applog"}`), opts)
+	if err != nil || !written {
+		t.Fatalf("config file should be written, written: %v, err: %v", written, err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat config file failed, err: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("config file mode is wrong, is %v", info.Mode().Perm())
+	}
+
+	// Make the mtime distinguishable, an identical write should not touch the file
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(path, old, old)
+	written, err = WriteConfigFile(path, []byte(`{"name": "applog"}`), opts)
+	if err != nil || written {
+		t.Errorf("identical config file should not be written, written: %v, err: %v", written, err)
+	}
+	info, _ = os.Stat(path)
+	if !info.ModTime().Equal(old) {
+		t.Errorf("identical config file should not be touched")
+	}
+
+	written, err = WriteConfigFile(path, []byte(`{"name": "auditlog"}`), opts)
+	if err != nil || !written {
+		t.Errorf("changed config file should be written, written: %v, err: %v", written, err)
+	}
+	raw, _ := ioutil.ReadFile(path)
+	if string(raw) != `{"name": "auditlog"}` {
+		t.Errorf("config file content is wrong, is %s", raw)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("temp files should be cleaned, there are %d files", len(files))
+	}
+}
+
+func TestParseFileOptions(t *testing.T) {
+	opts, err := ParseFileOptions("", "1000:2000")
+	if err != nil {
+		t.Fatalf("parse file options failed, err: %v", err)
+	}
+	if opts.Mode != 0644 || opts.UID != 1000 || opts.GID != 2000 {
+		t.Errorf("file options are wrong, are %+v", opts)
+	}
+
+	if _, err := ParseFileOptions("0999", ""); err == nil {
+		t.Errorf("invalid file mode should fail")
+	}
+	if _, err := ParseFileOptions("", "1000"); err == nil {
+		t.Errorf("invalid file owner should fail")
+	}
+}
@@ -0,0 +1,273 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/fatsheep9146/kirklog/pkg/api"
+	"github.com/fatsheep9146/kirklog/pkg/secret"
+)
+
+// Render the config file of logSource with the secrets it references
+type RenderFunc func(logSource *api.LogSource, secrets map[string]string) (string, error)
+
+// FileAgentManager is the AgentManager shared by the backends which deliver the config of every logSource
+// by a file in the conf dir of its agent, on the conf pvc shared by logmanager and the agents. The backend
+// embeds it, sets the hooks to render the files and reload the agents, and builds the agent container in Deploy.
+type FileAgentManager struct {
+	Cli             *kubernetes.Clientset
+	Name            string
+	Namespace       string
+	LogConfigs      []api.LogConfig
+	Secrets         *secret.Store
+	ConfFileOptions FileOptions
+	DeployConfig    DeployConfig
+
+	// The agent type, and the name of the workload, configmap and conf pvc of the agents, which is "<agent type>-<name>"
+	Type       AgentType
+	DeployName string
+
+	// The dir where the conf pvc is mounted, every agent reads the files in the dir named by its pod name
+	ConfDir string
+
+	// Render the config file of logSource, and return the name of the file
+	Render   RenderFunc
+	FileName func(logSource *api.LogSource) string
+
+	// Create the log meta dir before adding the config file, for the agents which keep their offsets there
+	CreateMetaDir bool
+
+	// Reload the agent serving the api on addr after its config files change, nil if the agents watch their conf dirs
+	Reload func(addr string) error
+
+	// The url posted by the conf-sync container of daemonset to reload the agent, "" if the agents watch their conf dirs
+	SyncReloadURL string
+
+	// Return the address of the api of one agent, "" if the agent is not running
+	AgentAddr func(agentName string) (string, error)
+
+	// The agents whose last reload failed, they are reloaded again even if no config file changes
+	reloadLock    sync.Mutex
+	pendingReload map[string]bool
+}
+
+// Create the FileAgentManager of agentType, whose agents mount the conf pvc at confDir and serve the api on apiPort
+func NewFileAgentManager(agentType AgentType, cfg *AgentManagerConfig, confDir string, apiPort int) *FileAgentManager {
+	f := &FileAgentManager{
+		Cli:             cfg.Cli,
+		Name:            cfg.Name,
+		Namespace:       cfg.Namespace,
+		LogConfigs:      cfg.LogConfigs,
+		Secrets:         cfg.Secrets,
+		ConfFileOptions: cfg.ConfFileOptions,
+		DeployConfig:    cfg.DeployConfig,
+		Type:            agentType,
+		DeployName:      fmt.Sprintf("%s-%s", agentType, cfg.Name),
+		ConfDir:         confDir,
+		pendingReload:   make(map[string]bool),
+	}
+	f.AgentAddr = func(agentName string) (string, error) {
+		return GetPodAddr(f.Cli, f.Namespace, agentName, apiPort)
+	}
+	return f
+}
+
+// Return the conf dir of the agent of pod podName
+func (f *FileAgentManager) GetAgentConfDir(podName string) string {
+	return fmt.Sprintf("%s/%s", f.ConfDir, podName)
+}
+
+func (f *FileAgentManager) List() ([]Agent, error) {
+	agents := make([]Agent, 0)
+
+	pods, err := ListAgentPods(f.Cli, f.Namespace, f.DeployName)
+	if err != nil {
+		return agents, err
+	}
+	for i := range pods {
+		agents = append(agents, Agent{
+			Name:     pods[i].Name,
+			ConfPath: f.GetAgentConfDir(pods[i].Name),
+			IP:       pods[i].Status.PodIP,
+			Node:     GetAgentNode(&pods[i]),
+			Ordinal:  i,
+		})
+	}
+
+	return agents, nil
+}
+
+func (f *FileAgentManager) GetReplicas() (int32, error) {
+	return GetDeploymentReplicas(f.Cli, f.Namespace, f.DeployName)
+}
+
+func (f *FileAgentManager) Scale(replicas int32, victims []string) error {
+	return ScaleDeployment(f.Cli, f.Namespace, f.DeployName, replicas, victims)
+}
+
+// Add the config file of one logSource to agentName, and reload the agent
+func (f *FileAgentManager) AddConfig(logSource *api.LogSource, agentName string) (string, error) {
+	secrets, err := ResolveSecrets(f.Secrets, logSource)
+	if err != nil {
+		return "", err
+	}
+	config, err := f.Render(logSource, secrets)
+	if err != nil {
+		return "", err
+	}
+
+	// The offsets kept in the log meta dir follow the logSource across agents
+	if f.CreateMetaDir {
+		err = os.MkdirAll(logSource.GetLogMetaDir(), 0755)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// Identical content is not written again, and the agent is not reloaded, otherwise it restarts the collection
+	filePath := fmt.Sprintf("%s/%s", f.GetAgentConfDir(agentName), f.FileName(logSource))
+	changed, err := WriteConfigFile(filePath, []byte(config), f.ConfFileOptions)
+	if err != nil {
+		return "", err
+	}
+	err = f.reload(agentName, changed)
+	if err != nil {
+		return "", err
+	}
+
+	logSource.Status.ConfigStatus.Path = filePath
+
+	return filePath, nil
+}
+
+// Delete the config file of one logSource from agentName, and reload the agent
+func (f *FileAgentManager) DelConfig(logSource *api.LogSource, agentName string) error {
+	filePath := fmt.Sprintf("%s/%s", f.GetAgentConfDir(agentName), f.FileName(logSource))
+
+	// The config file may be removed already by a previous try
+	err := os.Remove(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return f.reload(agentName, err == nil)
+}
+
+func (f *FileAgentManager) CheckLag(logSource *api.LogSource, agentName string) bool {
+	return true
+}
+
+// The conf path is "<conf dir>/<agent name>/<file>"
+func (f *FileAgentManager) GetAgentNameFromConf(confpath string) string {
+	return strings.Split(strings.TrimPrefix(confpath, f.ConfDir+"/"), "/")[0]
+}
+
+// Reload agentName if its config files are changed, or its last reload failed. The agent which is not running
+// reads the config files when it starts.
+func (f *FileAgentManager) reload(agentName string, changed bool) error {
+	if f.Reload == nil {
+		return nil
+	}
+
+	f.reloadLock.Lock()
+	defer f.reloadLock.Unlock()
+	if !changed && !f.pendingReload[agentName] {
+		return nil
+	}
+	addr, err := f.AgentAddr(agentName)
+	if err == nil && addr != "" {
+		err = f.Reload(addr)
+	}
+	if err != nil {
+		f.pendingReload[agentName] = true
+		return err
+	}
+	delete(f.pendingReload, agentName)
+	return nil
+}
+
+// Return the image of agents, which is the default one of the backend if not set
+func (f *FileAgentManager) GetImage(defaultImage string) string {
+	if f.DeployConfig.Image != "" {
+		return f.DeployConfig.Image
+	}
+	return defaultImage
+}
+
+func (f *FileAgentManager) GetConfClaimName() string {
+	if f.DeployConfig.ConfClaimName != "" {
+		return f.DeployConfig.ConfClaimName
+	}
+	return fmt.Sprintf("%s-conf", f.DeployName)
+}
+
+func (f *FileAgentManager) GetLabels() map[string]string {
+	return map[string]string{
+		"app":        f.DeployName,
+		ManagerLabel: f.Name,
+	}
+}
+
+// Return the metadata of the components of agents, such as the configmap of the main config
+func (f *FileAgentManager) NewObjectMeta(ownerReferences []metav1.OwnerReference) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:            f.DeployName,
+		Namespace:       f.Namespace,
+		Labels:          f.GetLabels(),
+		OwnerReferences: ownerReferences,
+	}
+}
+
+// Return the deployment of agents running container with the volumes. The log volumes and the conf dir are mounted
+// into the container, and the pod name is set to POD_NAME, by which the agent finds its conf dir.
+func (f *FileAgentManager) NewDeployment(ownerReferences []metav1.OwnerReference, container v1.Container, volumes []v1.Volume) *v1beta1.Deployment {
+	replicas := f.DeployConfig.Replicas
+	labels := f.GetLabels()
+
+	confVolume, confVolumeMount, confContainers := GetConfVolume(fmt.Sprintf("%s-conf", f.Type), f.GetConfClaimName(), f.ConfDir, f.DeployConfig, f.SyncReloadURL)
+	logVolumes, logVolumeMounts := GetLogVolumes(f.LogConfigs)
+	volumes = append(append(logVolumes, volumes...), confVolume)
+	container.VolumeMounts = append(append(logVolumeMounts, container.VolumeMounts...), confVolumeMount)
+	container.Env = append([]v1.EnvVar{
+		{
+			Name: "POD_NAME",
+			ValueFrom: &v1.EnvVarSource{
+				FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.name"},
+			},
+		},
+	}, container.Env...)
+	container.Resources = f.DeployConfig.Resources
+
+	return &v1beta1.Deployment{
+		ObjectMeta: f.NewObjectMeta(ownerReferences),
+		Spec: v1beta1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: v1.PodSpec{
+					Containers: append([]v1.Container{container}, confContainers...),
+					Volumes:    volumes,
+				},
+			},
+		},
+	}
+}
+
+// Resolve the secrets referenced by the config of logSource, nothing is resolved without the store
+func ResolveSecrets(store *secret.Store, logSource *api.LogSource) (map[string]string, error) {
+	if store == nil {
+		return make(map[string]string), nil
+	}
+	return store.Resolve(logSource)
+}
@@ -0,0 +1,90 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+func TestFileAgentManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kirklog-agent")
+	if err != nil {
+		t.Fatalf("create temp dir failed, err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	reloaded := make([]string, 0)
+	var reloadErr error
+	f := NewFileAgentManager("test", &AgentManagerConfig{Name: "kirklog", ConfFileOptions: DefaultFileOptions()}, dir, 8080)
+	f.Render = func(logSource *api.LogSource, secrets map[string]string) (string, error) {
+		return fmt.Sprintf("name = %s", logSource.Meta.Name), nil
+	}
+	f.FileName = func(logSource *api.LogSource) string {
+		return logSource.Meta.Name + ".conf"
+	}
+	f.Reload = func(addr string) error {
+		reloaded = append(reloaded, addr)
+		return reloadErr
+	}
+	f.AgentAddr = func(agentName string) (string, error) {
+		if agentName == "stopped" {
+			return "", nil
+		}
+		return agentName + ":8080", nil
+	}
+	logSource := &api.LogSource{Meta: api.Meta{Name: "deployment_test_applog_test-xxx-yyy"}}
+
+	for _, agentName := range []string{"test-0", "stopped"} {
+		os.MkdirAll(f.GetAgentConfDir(agentName), 0755)
+		confPath, err := f.AddConfig(logSource, agentName)
+		if err != nil {
+			t.Fatalf("add config to %s failed, err: %v", agentName, err)
+		}
+		if data, _ := ioutil.ReadFile(confPath); string(data) != "name = deployment_test_applog_test-xxx-yyy" {
+			t.Errorf("config file %s is wrong, is %s", confPath, data)
+		}
+		if f.GetAgentNameFromConf(confPath) != agentName {
+			t.Errorf("agent of conf path %s should be %s, is %s", confPath, agentName, f.GetAgentNameFromConf(confPath))
+		}
+	}
+	if len(reloaded) != 1 || reloaded[0] != "test-0:8080" {
+		t.Errorf("only the running agent should be reloaded, reloaded %v", reloaded)
+	}
+
+	// The same config does not reload the agent
+	_, err = f.AddConfig(logSource, "test-0")
+	if err != nil || len(reloaded) != 1 {
+		t.Errorf("agent should not be reloaded for the same config, reloaded %v, err: %v", reloaded, err)
+	}
+
+	// The failed reload is retried even if the config is not changed again
+	logSource.Meta.Name = "deployment_test_auditlog_test-xxx-yyy"
+	reloadErr = fmt.Errorf("agent is busy")
+	if _, err = f.AddConfig(logSource, "test-0"); err == nil {
+		t.Errorf("expect adding config failed with the reload")
+	}
+	reloadErr = nil
+	if _, err = f.AddConfig(logSource, "test-0"); err != nil || len(reloaded) != 3 {
+		t.Errorf("agent should be reloaded again after the failed reload, reloaded %v, err: %v", reloaded, err)
+	}
+	if _, err = f.AddConfig(logSource, "test-0"); err != nil || len(reloaded) != 3 {
+		t.Errorf("agent should not be reloaded after the reload succeeds, reloaded %v, err: %v", reloaded, err)
+	}
+
+	// Deleting twice succeeds, and the agent is reloaded only when the file is removed
+	for i := 0; i < 2; i++ {
+		err = f.DelConfig(logSource, "test-0")
+		if err != nil {
+			t.Errorf("delete config failed, err: %v", err)
+		}
+	}
+	if _, err := os.Stat(f.GetAgentConfDir("test-0") + "/deployment_test_auditlog_test-xxx-yyy.conf"); !os.IsNotExist(err) {
+		t.Errorf("config file should be removed, err: %v", err)
+	}
+	if len(reloaded) != 4 {
+		t.Errorf("agent should be reloaded once after deleting, reloaded %v", reloaded)
+	}
+}
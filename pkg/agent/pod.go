@@ -0,0 +1,36 @@
+package agent
+
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Return the address of the http api of the log agent pod, "" if the pod is removed or not running
+func GetPodAddr(cli *kubernetes.Clientset, namespace, name string, port int) (string, error) {
+	pod, err := cli.CoreV1().Pods(namespace).Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if pod.Status.Phase != v1.PodRunning || pod.Status.PodIP == "" {
+		return "", nil
+	}
+	return fmt.Sprintf("%s:%d", pod.Status.PodIP, port), nil
+}
+
+// Return the node whose node-local logs the log agent pod collects, which is the node of the pod if it belongs
+// to a daemonset, otherwise ""
+func GetAgentNode(pod *v1.Pod) string {
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "DaemonSet" {
+			return pod.Spec.NodeName
+		}
+	}
+	return ""
+}
@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Create the AgentManager of one backend
+type Factory func(cfg *AgentManagerConfig) AgentManager
+
+// The schema of the config of log stream which a backend understands
+type ConfigSchema struct {
+	// The format of the config of log stream, such as json, yaml or toml
+	Format string
+
+	// How the backend delivers the rendered config to its log agents, shown in the help of --agent-type
+	Description string
+}
+
+// Backend is an agent type registered by its package
+type Backend struct {
+	Type   AgentType
+	New    Factory
+	Schema ConfigSchema
+
+	// Compile the agent-neutral collect spec into the config of log stream, nil if the backend does not support it
+	Compile Compiler
+
+	// Build the sidecar injected into the pods by the webhook, nil if the log agents of the backend are not sidecars
+	Sidecar SidecarFunc
+}
+
+var (
+	backendsLock sync.RWMutex
+	backends     = make(map[AgentType]Backend)
+)
+
+// Register a backend, usually in the init function of its package. The backends outside this repo are linked
+// in by importing their packages in main, registering a type twice panics.
+func Register(backend Backend) {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+
+	if backend.Type == "" || backend.New == nil {
+		panic("agent: register backend without type or factory")
+	}
+	if _, exist := backends[backend.Type]; exist {
+		panic(fmt.Sprintf("agent: register backend %s twice", backend.Type))
+	}
+	backends[backend.Type] = backend
+}
+
+// GetBackend returns the backend of agentType, the error lists the registered types if it is unknown
+func GetBackend(agentType AgentType) (Backend, error) {
+	backendsLock.RLock()
+	backend, exist := backends[agentType]
+	backendsLock.RUnlock()
+
+	if !exist {
+		types := make([]string, 0)
+		for _, t := range RegisteredTypes() {
+			types = append(types, string(t))
+		}
+		return Backend{}, fmt.Errorf("unknown agent type %s, should be one of [%s]", agentType, strings.Join(types, ", "))
+	}
+	return backend, nil
+}
+
+// NewAgentManager creates the AgentManager of agentType by its registered factory
+func NewAgentManager(agentType AgentType, cfg *AgentManagerConfig) (AgentManager, error) {
+	backend, err := GetBackend(agentType)
+	if err != nil {
+		return nil, err
+	}
+	return backend.New(cfg), nil
+}
+
+// RegisteredTypes returns the registered agent types in order
+func RegisteredTypes() []AgentType {
+	backendsLock.RLock()
+	defer backendsLock.RUnlock()
+
+	types := make([]AgentType, 0, len(backends))
+	for t := range backends {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i] < types[j]
+	})
+	return types
+}
+
+// Describe the registered backends for the help of flags
+func DescribeBackends() string {
+	descriptions := make([]string, 0)
+	for _, t := range RegisteredTypes() {
+		backend, _ := GetBackend(t)
+		description := fmt.Sprintf("[%s] %s, config in %s", t, backend.Schema.Description, backend.Schema.Format)
+		if backend.Compile != nil {
+			description += " or collect spec"
+		}
+		descriptions = append(descriptions, description)
+	}
+	return strings.Join(descriptions, "; ")
+}
@@ -0,0 +1,38 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	Register(Backend{
+		Type:   "test-backend",
+		New:    func(cfg *AgentManagerConfig) AgentManager { return nil },
+		Schema: ConfigSchema{Format: "json", Description: "delivers nothing"},
+	})
+
+	if _, err := GetBackend("test-backend"); err != nil {
+		t.Errorf("get registered backend failed, err: %v", err)
+	}
+
+	// The unknown type fails with the registered ones listed
+	_, err := NewAgentManager("unknown", &AgentManagerConfig{})
+	if err == nil || !strings.Contains(err.Error(), "test-backend") {
+		t.Errorf("expect unknown agent type failed with the registered types, got %v", err)
+	}
+
+	if !strings.Contains(DescribeBackends(), "[test-backend] delivers nothing, config in json") {
+		t.Errorf("description of backends is wrong, is %s", DescribeBackends())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expect registering a type twice panics")
+		}
+	}()
+	Register(Backend{
+		Type: "test-backend",
+		New:  func(cfg *AgentManagerConfig) AgentManager { return nil },
+	})
+}
@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+// The label of the pods injected with the sidecar log agent, whose value is the logmanager they belong to
+const SidecarLabel = "kirklog.io/sidecar"
+
+// SidecarFunc returns the log agent container and the volumes it needs, which are injected into pod by the webhook
+// to collect the logs of logConfigs on the volumes of pod. It is used for the volumes which only the pod can mount,
+// such as the pvc of the volumeClaimTemplates of statefulset.
+type SidecarFunc func(pod *v1.Pod, logConfigs []api.LogConfig, config DeployConfig) (v1.Container, []v1.Volume)
+
+// Return the volumeMounts of the log streams on the volumes of pod, every volume is mounted at "/<controller>_<volume>",
+// which is the same as the mountPath of the log agents of deployment, so that the rendered configs are the same
+func GetPodLogVolumeMounts(pod *v1.Pod, logConfigs []api.LogConfig) []v1.VolumeMount {
+	podVolumes := make(map[string]bool)
+	for _, volume := range pod.Spec.Volumes {
+		podVolumes[volume.Name] = true
+	}
+
+	volumeMounts := make([]v1.VolumeMount, 0)
+	visited := make(map[string]bool)
+	for i := range logConfigs {
+		for _, stream := range logConfigs[i].GetStreams() {
+			if stream.IsNodeLocal() || !podVolumes[stream.VolumeMount] {
+				continue
+			}
+			mountPath := api.GetVolumeMountPath(logConfigs[i].GetControllerName(), stream.VolumeMount)
+			if visited[mountPath] {
+				continue
+			}
+			visited[mountPath] = true
+
+			volumeMounts = append(volumeMounts, v1.VolumeMount{
+				Name:      stream.VolumeMount,
+				MountPath: mountPath,
+			})
+		}
+	}
+	return volumeMounts
+}
+
+// Return the key of pod used by Agent.Pod
+func GetPodKey(namespace, name string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
@@ -0,0 +1,151 @@
+package logmanager
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+// The agent manager which keeps the configs in memory
+type fakeAgentManager struct {
+	// The config paths on every agent
+	confs map[string]map[string]bool
+
+	// The agents whose runners are still running, and the agents which fail to add configs
+	running map[string]bool
+	broken  map[string]bool
+}
+
+func newFakeAgentManager() *fakeAgentManager {
+	return &fakeAgentManager{
+		confs:   make(map[string]map[string]bool),
+		running: make(map[string]bool),
+		broken:  make(map[string]bool),
+	}
+}
+
+func (f *fakeAgentManager) Deploy() error                { return nil }
+func (f *fakeAgentManager) List() ([]agent.Agent, error) { return nil, nil }
+func (f *fakeAgentManager) CheckLag(logSource *api.LogSource, agent string) bool {
+	return true
+}
+
+func (f *fakeAgentManager) AddConfig(logSource *api.LogSource, agent string) (string, error) {
+	if f.broken[agent] {
+		return "", fmt.Errorf("agent %s is broken", agent)
+	}
+	if f.confs[agent] == nil {
+		f.confs[agent] = make(map[string]bool)
+	}
+	f.confs[agent][logSource.Meta.Name] = true
+	return fmt.Sprintf("/logkit/%s/%s.conf", agent, logSource.Meta.Name), nil
+}
+
+func (f *fakeAgentManager) DelConfig(logSource *api.LogSource, agent string) error {
+	delete(f.confs[agent], logSource.Meta.Name)
+	return nil
+}
+
+func (f *fakeAgentManager) GetAgentNameFromConf(confpath string) string {
+	return strings.Split(confpath, "/")[2]
+}
+
+func (f *fakeAgentManager) CollectStatus(agent string, logSources []*api.LogSource) (map[string]api.RunnerStatus, error) {
+	status := make(map[string]api.RunnerStatus)
+	for _, logSource := range logSources {
+		if f.confs[agent][logSource.Meta.Name] {
+			status[logSource.Meta.Name] = api.RunnerStatus{Agent: agent}
+		}
+	}
+	return status, nil
+}
+
+func (f *fakeAgentManager) RunnerStopped(logSource *api.LogSource, agent string) (bool, error) {
+	return !f.running[agent], nil
+}
+
+func newTestMoveManager(f *fakeAgentManager, timeout time.Duration) (*LogManager, string) {
+	logSource := newTestLogSource("deploy_app", "pod-0")
+	key := logSource.Meta.Name
+	f.AddConfig(logSource, "agent-0")
+
+	lm := &LogManager{
+		LogSources:       map[string]*api.LogSource{key: logSource},
+		LogAgentManagers: map[agent.AgentType]agent.AgentManager{"": f},
+		Match: map[string]*Match{
+			key: {
+				PodName:   "pod-0",
+				AgentName: "agent-1",
+				ConfPath:  fmt.Sprintf("/logkit/agent-0/%s.conf", key),
+			},
+		},
+		MoveStopTimeout: timeout,
+	}
+	return lm, key
+}
+
+func TestLogSourceMov(t *testing.T) {
+	f := newFakeAgentManager()
+	f.running["agent-0"] = true
+	lm, key := newTestMoveManager(f, time.Hour)
+
+	// The new agent does not get the config until the old runner stops
+	done, err := lm.logSourceMovFunc(key)
+	if done || err != nil {
+		t.Errorf("expect the move waiting, got done %v, err %v", done, err)
+	}
+	if f.confs["agent-0"][key] || f.confs["agent-1"][key] {
+		t.Errorf("expect the config only removed from agent-0, got %v", f.confs)
+	}
+	if lm.Match[key].MoveStep != MoveStepWaiting || judgeAction(lm.Match[key], lm.confAgentOf(key)) != LogSourceMov {
+		t.Errorf("expect the move step %s, got %s", MoveStepWaiting, lm.Match[key].MoveStep)
+	}
+
+	f.running["agent-0"] = false
+	done, err = lm.logSourceMovFunc(key)
+	if !done || err != nil {
+		t.Errorf("expect the move done, got done %v, err %v", done, err)
+	}
+	if !f.confs["agent-1"][key] || lm.Match[key].MoveStep != "" || judgeAction(lm.Match[key], lm.confAgentOf(key)) != LogSourceNop {
+		t.Errorf("expect the config moved to agent-1, got %v, match %+v", f.confs, lm.Match[key])
+	}
+}
+
+func TestLogSourceMovRollback(t *testing.T) {
+	f := newFakeAgentManager()
+	f.broken["agent-1"] = true
+	lm, key := newTestMoveManager(f, time.Hour)
+
+	done, err := lm.logSourceMovFunc(key)
+	if !done || err != nil {
+		t.Errorf("expect the move rolled back, got done %v, err %v", done, err)
+	}
+	m := lm.Match[key]
+	if !f.confs["agent-0"][key] || m.AgentName != "agent-0" || m.MoveStep != MoveStepRolledBack {
+		t.Errorf("expect the config rolled back to agent-0, got %v, match %+v", f.confs, m)
+	}
+	if lm.LogSources[key].Status.ConfigStatus.MoveStep != MoveStepRolledBack {
+		t.Errorf("expect the move step recorded in status, got %s", lm.LogSources[key].Status.ConfigStatus.MoveStep)
+	}
+}
+
+func TestJudgeActionMove(t *testing.T) {
+	f := newFakeAgentManager()
+	lm, key := newTestMoveManager(f, time.Minute)
+
+	// The agent name agent-1 is a part of the name of the agent holding the config
+	lm.Match[key].AgentName = "agent-1"
+	lm.Match[key].ConfPath = fmt.Sprintf("/logkit/agent-10/%s.conf", key)
+	if action := judgeAction(lm.Match[key], lm.confAgentOf(key)); action != LogSourceMov {
+		t.Errorf("expect the logSource moved from agent-10 to agent-1, got %s", action)
+	}
+
+	lm.Match[key].ConfPath = fmt.Sprintf("/logkit/agent-1/%s.conf", key)
+	if action := judgeAction(lm.Match[key], lm.confAgentOf(key)); action != LogSourceNop {
+		t.Errorf("expect nothing to do for the logSource on agent-1, got %s", action)
+	}
+}
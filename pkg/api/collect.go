@@ -0,0 +1,159 @@
+package api
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	ReadFromOldest = "oldest"
+	ReadFromNewest = "newest"
+
+	ParserRaw   = "raw"
+	ParserJSON  = "json"
+	ParserRegex = "regex"
+
+	DestinationHTTP          = "http"
+	DestinationElasticsearch = "elasticsearch"
+)
+
+// CollectSpec is the agent-neutral way to describe how one log stream is collected. It is compiled into the
+// native config of the agent type of the LogConfig, so the LogConfig is kept when the agent type is switched.
+// The raw Config of LogStream is still the escape hatch for the features which are not covered here.
+//
+//	{
+//	  "paths": ["*.log"],
+//	  "multiline": {"start": "^\\d{4}-\\d{2}-\\d{2}"},
+//	  "parser": {"type": "json"},
+//	  "fields": {"team": "{{ .Pod.Labels.team }}"},
+//	  "destination": {"type": "elasticsearch", "hosts": ["http://es:9200"], "index": "applog"}
+//	}
+type CollectSpec struct {
+	// The glob patterns of the log files in the log dir, default is all the files
+	Paths []string `json:"paths,omitempty"`
+
+	// Where to start for the files collected for the first time, "oldest" or "newest", default is decided by the agent
+	ReadFrom string `json:"read_from,omitempty"`
+
+	// How the lines are joined into one event, nil means every line is an event
+	Multiline *MultilineSpec `json:"multiline,omitempty"`
+
+	// How the events are parsed, nil means the raw line is kept
+	Parser *ParserSpec `json:"parser,omitempty"`
+
+	// The fields added to every event, the values can reference the template data like the raw config
+	Fields map[string]string `json:"fields,omitempty"`
+
+	// Where the events are shipped, the strings can reference the template data like the raw config
+	Destination DestinationSpec `json:"destination"`
+}
+
+type MultilineSpec struct {
+	// The regexp matching the first line of an event, the lines not matching it are appended to the previous one
+	Start string `json:"start"`
+}
+
+type ParserSpec struct {
+	// The type of parser, "raw", "json" or "regex"
+	Type string `json:"type"`
+
+	// The regexp of the regex parser, its named groups become the fields of the event
+	Pattern string `json:"pattern,omitempty"`
+}
+
+type DestinationSpec struct {
+	// The type of destination, "http" or "elasticsearch"
+	Type string `json:"type"`
+
+	// The url which the http destination posts the events to in json
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// The urls of the elasticsearch nodes and the index written to
+	Hosts []string `json:"hosts,omitempty"`
+	Index string   `json:"index,omitempty"`
+
+	// The basic auth of the destination
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// Check whether the collect spec is valid, the features not supported by an agent type are checked when it is compiled
+func (s *CollectSpec) Validate() error {
+	for _, path := range s.Paths {
+		if path == "" || strings.Contains(path, "/") {
+			return fmt.Errorf("path %q should be a pattern of the files in the log dir", path)
+		}
+		if _, err := filepath.Match(path, ""); err != nil {
+			return fmt.Errorf("path %q is invalid, err: %v", path, err)
+		}
+	}
+
+	switch s.ReadFrom {
+	case "", ReadFromOldest, ReadFromNewest:
+	default:
+		return fmt.Errorf("read_from should be %s or %s, is %s", ReadFromOldest, ReadFromNewest, s.ReadFrom)
+	}
+
+	if s.Multiline != nil {
+		if s.Multiline.Start == "" {
+			return fmt.Errorf("multiline has no start pattern")
+		}
+		if _, err := regexp.Compile(s.Multiline.Start); err != nil {
+			return fmt.Errorf("multiline start pattern is invalid, err: %v", err)
+		}
+	}
+
+	if s.Parser != nil {
+		switch s.Parser.Type {
+		case ParserRaw, ParserJSON:
+		case ParserRegex:
+			re, err := regexp.Compile(s.Parser.Pattern)
+			if err != nil {
+				return fmt.Errorf("regex parser pattern is invalid, err: %v", err)
+			}
+			if len(re.SubexpNames()) <= 1 {
+				return fmt.Errorf("regex parser pattern has no named group")
+			}
+		default:
+			return fmt.Errorf("parser type should be %s, %s or %s, is %s", ParserRaw, ParserJSON, ParserRegex, s.Parser.Type)
+		}
+	}
+
+	switch s.Destination.Type {
+	case DestinationHTTP:
+		if s.Destination.URL == "" {
+			return fmt.Errorf("http destination has no url")
+		}
+	case DestinationElasticsearch:
+		if len(s.Destination.Hosts) == 0 || s.Destination.Index == "" {
+			return fmt.Errorf("elasticsearch destination should have hosts and index")
+		}
+	default:
+		return fmt.Errorf("destination type should be %s or %s, is %s", DestinationHTTP, DestinationElasticsearch, s.Destination.Type)
+	}
+	return nil
+}
+
+// Return the patterns of the log files in the log dir, "*" if the collect spec does not choose them.
+// The container log is in "<restart count>.log", the rotated files are left out by default.
+func (l *LogSource) GetFilePatterns() []string {
+	if l.Spec.Collect == nil || len(l.Spec.Collect.Paths) == 0 {
+		if l.IsContainer() {
+			return []string{"*.log"}
+		}
+		return []string{"*"}
+	}
+	return l.Spec.Collect.Paths
+}
+
+// Return the paths of the log files to collect, which are the file patterns in the log dir
+func (l *LogSource) GetLogPaths() []string {
+	paths := make([]string, 0)
+	for _, pattern := range l.GetFilePatterns() {
+		paths = append(paths, fmt.Sprintf("%s/%s", l.GetLogDir(), pattern))
+	}
+	return paths
+}
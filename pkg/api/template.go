@@ -0,0 +1,261 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// The formats of the configs of log streams, the values of template expressions are escaped by the format
+const (
+	FormatJSON      = "json"
+	FormatTOML      = "toml"
+	FormatYAML      = "yaml"
+	FormatFluentbit = "fluent bit classic"
+)
+
+// The template funcs escaping the values for every format, the values of json, toml and yaml are escaped
+// for their double-quoted strings. The raw func keeps the value as it is.
+var escapeFuncs = map[string]string{
+	FormatJSON:      "json",
+	FormatTOML:      "toml",
+	FormatYAML:      "yaml",
+	FormatFluentbit: "fluentbit",
+}
+
+var templateFuncs = template.FuncMap{
+	"json":      escapeString,
+	"toml":      escapeString,
+	"yaml":      escapeString,
+	"fluentbit": escapeFluentbit,
+	"raw":       func(v interface{}) string { return toString(v) },
+}
+
+func toString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// Escape the value in the double-quoted string, whose escapes of json are valid in toml and yaml too
+func escapeString(v interface{}) (string, error) {
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(toString(v))
+	if err != nil {
+		return "", err
+	}
+	escaped := strings.TrimSuffix(buf.String(), "\n")
+	return escaped[1 : len(escaped)-1], nil
+}
+
+// The entries of fluent bit are lines, which can not be escaped, so the value of multiple lines is rejected
+func escapeFluentbit(v interface{}) (string, error) {
+	s := toString(v)
+	if strings.ContainsAny(s, "\r\n") {
+		return "", fmt.Errorf("value of multiple lines can not be rendered in the config of fluent bit")
+	}
+	return s, nil
+}
+
+// Escape the output of every action by the escape func of format, unless it is escaped already or kept raw
+func escapeTemplate(tmpl *template.Template, format string) {
+	escapeFunc, exist := escapeFuncs[format]
+	if !exist {
+		return
+	}
+	var escape func(node parse.Node)
+	escape = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				escape(child)
+			}
+		case *parse.IfNode:
+			escape(n.List)
+			escape(n.ElseList)
+		case *parse.RangeNode:
+			escape(n.List)
+			escape(n.ElseList)
+		case *parse.WithNode:
+			escape(n.List)
+			escape(n.ElseList)
+		case *parse.ActionNode:
+			// The variable declaration outputs nothing
+			if len(n.Pipe.Decl) != 0 {
+				return
+			}
+			last := n.Pipe.Cmds[len(n.Pipe.Cmds)-1]
+			if ident, ok := last.Args[0].(*parse.IdentifierNode); ok && (ident.Ident == "raw" || isEscapeFunc(ident.Ident)) {
+				return
+			}
+			n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+				NodeType: parse.NodeCommand,
+				Pos:      n.Pos,
+				Args:     []parse.Node{parse.NewIdentifier(escapeFunc).SetPos(n.Pos)},
+			})
+		}
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			escape(t.Tree.Root)
+		}
+	}
+}
+
+func isEscapeFunc(name string) bool {
+	for _, escapeFunc := range escapeFuncs {
+		if escapeFunc == name {
+			return true
+		}
+	}
+	return false
+}
+
+// The data which can be referenced in the config of LogConfig by go template expressions
+// For example, {{ .Pod.Labels.app }}, {{ .Namespace }}, {{ .NodeName }}, {{ .Controller }} or {{ .Secrets.pandora_ak }}
+// The Container is the name of the container for the stdout/stderr log, "" for the log on the volume
+type TemplateData struct {
+	Pod        TemplatePod
+	Namespace  string
+	NodeName   string
+	Controller string
+	Stream     string
+	Container  string
+	Secrets    map[string]string
+}
+
+type TemplatePod struct {
+	Name        string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+func NewTemplateData(l *LogSource, secrets map[string]string) *TemplateData {
+	return &TemplateData{
+		Pod: TemplatePod{
+			Name:        l.Spec.PodName,
+			Labels:      l.Spec.PodLabels,
+			Annotations: l.Spec.PodAnnotations,
+		},
+		Namespace:  l.Spec.Namespace,
+		NodeName:   l.Spec.NodeName,
+		Controller: l.Spec.ControllerName,
+		Stream:     l.Spec.Stream,
+		Container:  l.Spec.Container,
+		Secrets:    secrets,
+	}
+}
+
+// Render the go template expressions in the raw config of this log source
+// The missing labels or annotations are rendered as empty string
+// The values are escaped by format, such as {{ .Secrets.password | json }}, so the values of json, toml and yaml
+// should be placed in double-quoted strings. {{ .Secrets.config | raw }} keeps the value as it is.
+// The secrets are the resolved values of Spec.Secrets, the rendered config should never be logged
+func (l *LogSource) RenderConfigTemplate(secrets map[string]string, format string) (string, error) {
+	tmpl, err := template.New(l.Meta.Name).Option("missingkey=zero").Funcs(templateFuncs).Parse(l.Spec.Config)
+	if err != nil {
+		return "", err
+	}
+	escapeTemplate(tmpl, format)
+
+	buf := &bytes.Buffer{}
+	err = tmpl.Execute(buf, NewTemplateData(l, secrets))
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Render the go template expressions in the collect spec of this log source, only the fields and the destination
+// are rendered, the patterns are kept as they are since regexps may have braces
+func (l *LogSource) RenderCollectSpec(secrets map[string]string) (*CollectSpec, error) {
+	data := NewTemplateData(l, secrets)
+	var err error
+	render := func(text string) string {
+		if err != nil || !strings.Contains(text, "{{") {
+			return text
+		}
+		var tmpl *template.Template
+		tmpl, err = template.New(l.Meta.Name).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return text
+		}
+		buf := &bytes.Buffer{}
+		err = tmpl.Execute(buf, data)
+		return buf.String()
+	}
+
+	spec := *l.Spec.Collect
+	if len(spec.Fields) != 0 {
+		spec.Fields = make(map[string]string)
+		for k, v := range l.Spec.Collect.Fields {
+			spec.Fields[k] = render(v)
+		}
+	}
+
+	dest := &spec.Destination
+	dest.URL = render(dest.URL)
+	if len(dest.Headers) != 0 {
+		dest.Headers = make(map[string]string)
+		for k, v := range l.Spec.Collect.Destination.Headers {
+			dest.Headers[k] = render(v)
+		}
+	}
+	if len(dest.Hosts) != 0 {
+		dest.Hosts = make([]string, 0, len(l.Spec.Collect.Destination.Hosts))
+		for _, host := range l.Spec.Collect.Destination.Hosts {
+			dest.Hosts = append(dest.Hosts, render(host))
+		}
+	}
+	dest.Index = render(dest.Index)
+	dest.Username = render(dest.Username)
+	dest.Password = render(dest.Password)
+
+	if err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// The pod of the placeholder log source, see WithPlaceholderPod
+const PodPlaceholder = "kirklog-pod-placeholder"
+
+// The labels and annotations referenced by the templates, such as {{ .Pod.Labels.app }} or
+// {{ index .Pod.Annotations "kirklog.io/topic" }}, the quotes may be escaped in the json of the collect spec
+var podKeyPattern = regexp.MustCompile(`\.Pod\.(Labels|Annotations)(?:\.(\w+)|\s+\\?"([^"\\]+)\\?")`)
+
+// Return a copy of this log source whose pod name, node name and the labels and annotations referenced by the
+// templates are all PodPlaceholder. The configs shared by all the pods of LogConfig are rendered without pod,
+// so they should be rendered the same with the placeholder pod, otherwise they reference the pod.
+func (l *LogSource) WithPlaceholderPod() *LogSource {
+	placeholder := *l
+	placeholder.Spec.PodName = PodPlaceholder
+	placeholder.Spec.NodeName = PodPlaceholder
+	placeholder.Spec.PodLabels = make(map[string]string)
+	placeholder.Spec.PodAnnotations = make(map[string]string)
+
+	text := l.Spec.Config
+	if l.Spec.Collect != nil {
+		data, _ := json.Marshal(l.Spec.Collect)
+		text += string(data)
+	}
+	for _, match := range podKeyPattern.FindAllStringSubmatch(text, -1) {
+		key := match[2] + match[3]
+		if match[1] == "Labels" {
+			placeholder.Spec.PodLabels[key] = PodPlaceholder
+		} else {
+			placeholder.Spec.PodAnnotations[key] = PodPlaceholder
+		}
+	}
+	return &placeholder
+}
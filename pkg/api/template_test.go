@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRenderConfigTemplateEscape(t *testing.T) {
+	value := "a\"b\\c\nd"
+	tests := []struct {
+		format   string
+		config   string
+		expected string
+	}{
+		{FormatJSON, `{"v": "{{ .Secrets.v }}"}`, `{"v": "a\"b\\c\nd"}`},
+		{FormatTOML, `v = "{{ .Secrets.v }}"`, `v = "a\"b\\c\nd"`},
+		{FormatYAML, `v: "{{ .Secrets.v }}"`, `v: "a\"b\\c\nd"`},
+		// The value escaped explicitly is not escaped again, and the raw value is kept
+		{FormatJSON, `{"v": "{{ .Secrets.v | json }}"}`, `{"v": "a\"b\\c\nd"}`},
+		{FormatJSON, `{{ .Secrets.v | raw }}`, value},
+		{FormatJSON, `{{ $v := .Secrets.v }}{{ if $v }}"{{ $v }}"{{ end }}`, `"a\"b\\c\nd"`},
+		// The value is not escaped for the unknown format
+		{"", `{{ .Secrets.v }}`, value},
+	}
+
+	for _, test := range tests {
+		logSource := &LogSource{Spec: LogSourceSpec{Config: test.config}}
+		config, err := logSource.RenderConfigTemplate(map[string]string{"v": value}, test.format)
+		if err != nil {
+			t.Errorf("render %q in format %s failed, err: %v", test.config, test.format, err)
+			continue
+		}
+		if config != test.expected {
+			t.Errorf("render %q in format %s should be %q, is %q", test.config, test.format, test.expected, config)
+		}
+	}
+
+	// The escaped value is decoded as it is
+	logSource := &LogSource{Spec: LogSourceSpec{Config: `{"v": "{{ .Secrets.v }}"}`}}
+	config, _ := logSource.RenderConfigTemplate(map[string]string{"v": value}, FormatJSON)
+	decoded := make(map[string]string)
+	if err := json.Unmarshal([]byte(config), &decoded); err != nil || decoded["v"] != value {
+		t.Errorf("escaped value should be decoded as %q, is %q, err: %v", value, decoded["v"], err)
+	}
+
+	// The value of multiple lines would inject the entries of fluent bit
+	logSource = &LogSource{Spec: LogSourceSpec{Config: "[OUTPUT]\n    Passwd {{ .Secrets.v }}\n"}}
+	_, err := logSource.RenderConfigTemplate(map[string]string{"v": "x\n[OUTPUT]"}, FormatFluentbit)
+	if err == nil || !strings.Contains(err.Error(), "multiple lines") {
+		t.Errorf("expect value of multiple lines rejected, got %v", err)
+	}
+}
+
+func TestWithPlaceholderPod(t *testing.T) {
+	logSource := &LogSource{
+		Spec: LogSourceSpec{
+			Config: `index: "{{ .Pod.Labels.app }}-{{ index .Pod.Annotations "kirklog.io/topic" }}"`,
+			Collect: &CollectSpec{
+				Destination: DestinationSpec{Index: `{{ index $.Pod.Labels "app.kubernetes.io/name" }}`},
+			},
+		},
+	}
+
+	placeholder := logSource.WithPlaceholderPod()
+	if placeholder.Spec.PodName != PodPlaceholder || placeholder.Spec.PodLabels["app"] != PodPlaceholder ||
+		placeholder.Spec.PodLabels["app.kubernetes.io/name"] != PodPlaceholder || placeholder.Spec.PodAnnotations["kirklog.io/topic"] != PodPlaceholder {
+		t.Errorf("pod referenced should be the placeholder, is %+v", placeholder.Spec)
+	}
+	if logSource.Spec.PodName != "" || len(logSource.Spec.PodLabels) != 0 {
+		t.Errorf("log source should not be changed, is %+v", logSource.Spec)
+	}
+}
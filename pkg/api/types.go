@@ -2,6 +2,8 @@ package api
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"k8s.io/api/core/v1"
 )
@@ -18,13 +20,210 @@ type LogConfig struct {
 	Kind string `json:"kind"`
 
 	// The volumeMount of this object(deployment, statefulset) which holds the log
-	VolumeMount string `json:"volume_mount"`
+	// Deprecated: use Streams, kept for the configs which only have one log stream
+	VolumeMount string `json:"volume_mount,omitempty"`
 
 	// The labelselector used to list pods of this deploy
 	LabelSelector string `json:"-"`
 
 	// The config of the log of this kind
-	Config string `json:"config"`
+	// Deprecated: use Streams, kept for the configs which only have one log stream
+	Config string `json:"config,omitempty"`
+
+	// The log streams of this object, each of them is collected from its own volume with its own config
+	Streams []LogStream `json:"streams,omitempty"`
+
+	// The secret keys referenced by the config, the key of the map is the name used in the config template
+	// For example, {"pandora_ak": {"name": "pandora", "key": "ak"}} can be referenced as {{ .Secrets.pandora_ak }}
+	Secrets map[string]SecretKeySelector `json:"secrets,omitempty"`
+
+	// The type of log agents which collect the logs of this object, default is the agent type of logmanager
+	AgentType string `json:"agent_type,omitempty"`
+}
+
+// SecretKeySelector selects a key of a secret in the namespace of the LogConfig
+type SecretKeySelector struct {
+	// The name of the secret
+	Name string `json:"name"`
+
+	// The key of the secret
+	Key string `json:"key"`
+}
+
+// LogStream is used to represent one kind of log of one deployment/statefulset
+// For example, the deployment "boots-gate" may have two streams, "applog" and "auditlog"
+type LogStream struct {
+	// The name of the stream, which should be unique in one LogConfig, default is the VolumeMount
+	Name string `json:"name,omitempty"`
+
+	// The volumeMount of the object which holds the log of this stream
+	VolumeMount string `json:"volume_mount"`
+
+	// The config of the log of this stream
+	Config string `json:"config,omitempty"`
+
+	// The agent-neutral collect spec of this stream, which is compiled into the config of the agent type.
+	// It can not be set together with Config.
+	Collect *CollectSpec `json:"collect,omitempty"`
+
+	// The pvc which backs the volume, default is "<name>-<volume_mount>" of the LogConfig
+	ClaimName string `json:"claim_name,omitempty"`
+
+	// The type of the volume, "pvc" by default. The logs on "hostpath" or "emptydir" volumes are node-local,
+	// they are only collected by the log agents of DaemonSet on the node of the pod
+	VolumeType string `json:"volume_type,omitempty"`
+
+	// The path on the node of the hostpath volume, the log of one pod is in "<host_path>/<namespace>_<pod>"
+	HostPath string `json:"host_path,omitempty"`
+
+	// The source of the log of this stream, "file" by default which is the log files on the volume.
+	// "container" is the stdout/stderr of the containers, every container of the pod is a log source
+	// collected from its log dir under /var/log/pods by the log agents of DaemonSet on the node of the pod
+	Source string `json:"source,omitempty"`
+
+	// The containers whose stdout/stderr is collected by the container stream, default is all the containers
+	Containers []string `json:"containers,omitempty"`
+}
+
+const (
+	VolumePVC      = "pvc"
+	VolumeHostPath = "hostpath"
+	VolumeEmptyDir = "emptydir"
+
+	// The dir of kubelet which holds the emptyDir volumes of pods
+	KubeletPodsDir = "/var/lib/kubelet/pods"
+
+	SourceFile      = "file"
+	SourceContainer = "container"
+
+	// The dir of kubelet which holds the stdout/stderr of containers, the log of one container is in
+	// "<namespace>_<pod>_<uid>/<container>" of it
+	PodLogsDir = "/var/log/pods"
+
+	// The dir of docker which holds the json log files, the container logs under PodLogsDir link to them
+	DockerContainersDir = "/var/lib/docker/containers"
+
+	// The format of the lines of container log, which is decided by the container runtime.
+	// "cri" is "<time> <stdout|stderr> <P|F> <message>", the message split into "P" lines ends with a "F" line.
+	// "docker" is {"log": "<message>", "stream": "<stdout|stderr>", "time": "<time>"}, the log of a
+	// split message has no newline until the last line.
+	ContainerLogCRI    = "cri"
+	ContainerLogDocker = "docker"
+)
+
+// Check whether the stream collects the stdout/stderr of containers
+func (s *LogStream) IsContainer() bool {
+	return s.Source == SourceContainer
+}
+
+// Check whether the log of the stream is on the node of the pod
+func (s *LogStream) IsNodeLocal() bool {
+	return s.IsContainer() || s.VolumeType == VolumeHostPath || s.VolumeType == VolumeEmptyDir
+}
+
+// Return the dir on the node which holds the log of the stream of pod, "" if the log is not node-local
+func (s *LogStream) GetNodeLogDir(pod *v1.Pod) string {
+	switch s.VolumeType {
+	case VolumeHostPath:
+		return fmt.Sprintf("%s/%s_%s", strings.TrimSuffix(s.HostPath, "/"), pod.Namespace, pod.Name)
+	case VolumeEmptyDir:
+		return fmt.Sprintf("%s/%s/volumes/kubernetes.io~empty-dir/%s", KubeletPodsDir, pod.UID, s.VolumeMount)
+	}
+	return ""
+}
+
+// Return the log streams of this LogConfig, the deprecated VolumeMount and Config are treated as one stream
+func (c *LogConfig) GetStreams() []LogStream {
+	streams := make([]LogStream, 0, len(c.Streams)+1)
+	if c.VolumeMount != "" {
+		streams = append(streams, LogStream{
+			Name:        c.VolumeMount,
+			VolumeMount: c.VolumeMount,
+			Config:      c.Config,
+		})
+	}
+	for _, stream := range c.Streams {
+		if stream.Name == "" {
+			stream.Name = stream.VolumeMount
+		}
+		streams = append(streams, stream)
+	}
+	return streams
+}
+
+// Return the controller name of the pods of this LogConfig, for example "deployment_boots-gate"
+func (c *LogConfig) GetControllerName() string {
+	return fmt.Sprintf("%s_%s", c.Kind, c.Name)
+}
+
+// Return the pvc which backs the volume of the stream
+func (c *LogConfig) GetClaimName(stream *LogStream) string {
+	if stream.ClaimName != "" {
+		return stream.ClaimName
+	}
+	return fmt.Sprintf("%s-%s", c.Name, stream.VolumeMount)
+}
+
+// Check whether the LogConfig is valid
+func (c *LogConfig) Validate() error {
+	streams := c.GetStreams()
+	if len(streams) == 0 {
+		return fmt.Errorf("log config %s_%s has no log stream", c.Kind, c.Name)
+	}
+
+	names := make(map[string]bool)
+	for _, stream := range streams {
+		switch stream.Source {
+		case "", SourceFile:
+			if stream.VolumeMount == "" {
+				return fmt.Errorf("log stream %s of log config %s_%s has no volume mount", stream.Name, c.Kind, c.Name)
+			}
+			if len(stream.Containers) != 0 {
+				return fmt.Errorf("log stream %s of log config %s_%s has containers but it is not a container stream", stream.Name, c.Kind, c.Name)
+			}
+		case SourceContainer:
+			if stream.Name == "" {
+				return fmt.Errorf("container stream of log config %s_%s has no name", c.Kind, c.Name)
+			}
+			if stream.VolumeMount != "" || stream.VolumeType != "" || stream.HostPath != "" || stream.ClaimName != "" {
+				return fmt.Errorf("container stream %s of log config %s_%s should have no volume", stream.Name, c.Kind, c.Name)
+			}
+			for _, container := range stream.Containers {
+				if container == "" {
+					return fmt.Errorf("container stream %s of log config %s_%s has an empty container name", stream.Name, c.Kind, c.Name)
+				}
+			}
+		default:
+			return fmt.Errorf("source %s of log stream %s of log config %s_%s should be %s or %s",
+				stream.Source, stream.Name, c.Kind, c.Name, SourceFile, SourceContainer)
+		}
+		if names[stream.Name] {
+			return fmt.Errorf("log stream %s of log config %s_%s is duplicated", stream.Name, c.Kind, c.Name)
+		}
+		if stream.Collect != nil {
+			if stream.Config != "" {
+				return fmt.Errorf("log stream %s of log config %s_%s has both config and collect", stream.Name, c.Kind, c.Name)
+			}
+			if err := stream.Collect.Validate(); err != nil {
+				return fmt.Errorf("collect of log stream %s of log config %s_%s is invalid, err: %v", stream.Name, c.Kind, c.Name, err)
+			}
+		}
+		names[stream.Name] = true
+		switch stream.VolumeType {
+		case "", VolumePVC, VolumeEmptyDir:
+			if stream.HostPath != "" {
+				return fmt.Errorf("log stream %s of log config %s_%s has host path but it is not a hostpath volume", stream.Name, c.Kind, c.Name)
+			}
+		case VolumeHostPath:
+			if !strings.HasPrefix(stream.HostPath, "/") {
+				return fmt.Errorf("log stream %s of log config %s_%s should have an absolute host path", stream.Name, c.Kind, c.Name)
+			}
+		default:
+			return fmt.Errorf("volume type %s of log stream %s of log config %s_%s should be %s, %s or %s",
+				stream.VolumeType, stream.Name, c.Kind, c.Name, VolumePVC, VolumeHostPath, VolumeEmptyDir)
+		}
+	}
+	return nil
 }
 
 // This object is used to repesent one log config from one pod of one deployment/statefulset
@@ -50,57 +249,187 @@ type LogSourceSpec struct {
 	// The namespace of this pod
 	Namespace string `json:"namespace"`
 
+	// The node where this pod is running
+	NodeName string `json:"node_name"`
+
+	// The labels of this pod
+	PodLabels map[string]string `json:"pod_labels,omitempty"`
+
+	// The annotations of this pod
+	PodAnnotations map[string]string `json:"pod_annotations,omitempty"`
+
+	// The name of the log stream of this log source, which is unique in one LogConfig.
+	Stream string `json:"stream"`
+
 	// The volume mount of this pod which generates this log source.
 	VolumeMount string `json:"volume_mount"`
 
 	// The raw config file for this log source
 	Config string `json:"config"`
+
+	// The agent-neutral collect spec for this log source, which replaces the raw config if it is set
+	Collect *CollectSpec `json:"collect,omitempty"`
+
+	// The secret keys referenced by the raw config, only the references are kept here, never the values
+	Secrets map[string]SecretKeySelector `json:"secrets,omitempty"`
+
+	// The type of log agents which collect this log source, it is only scheduled to the agents of this type
+	AgentType string `json:"agent_type,omitempty"`
+
+	// The dir on the node which holds the node-local log, it is only scheduled to the agent on NodeName then
+	NodeLogDir string `json:"node_log_dir,omitempty"`
+
+	// The container whose stdout/stderr is this log source, "" for the log on the volume
+	Container string `json:"container,omitempty"`
+
+	// The format of the lines of the container log, "cri" or "docker"
+	ContainerLogFormat string `json:"container_log_format,omitempty"`
 }
 
 type LogSourceStatus struct {
 	ConfigStatus ConfigStatus `json:"config_status"`
 
 	LogStatus LogStatus `json:"log_status"`
+
+	RunnerStatus RunnerStatus `json:"runner_status"`
 }
 
 // The status of the config file path
 type ConfigStatus struct {
 	// The path of config file for this log source
 	Path string `json:"path"`
+
+	// The step of moving the config to another agent, "" means it is not moving
+	MoveStep string `json:"move_step,omitempty"`
 }
 
 // The status of the log file collection
 type LogStatus struct {
 	// The flag indicates that the log is done collecting
 	Done bool `json:"done"`
+
+	// The moving average of the rate of bytes written to the log dir, 0 means unknown
+	BytesRate float64 `json:"bytes_rate"`
+
+	// The size of the log dir and the time it is sampled
+	Bytes     int64     `json:"bytes"`
+	SampledAt time.Time `json:"sampled_at"`
 }
 
-func NewLogSource(pod *v1.Pod, config *LogConfig) *LogSource {
+// The status of the runner which collects this log source, reported by the log agent
+type RunnerStatus struct {
+	// The agent which reports the status
+	Agent string `json:"agent"`
+
+	// The lines and bytes read from the log files
+	ReadLines int64 `json:"read_lines"`
+	ReadBytes int64 `json:"read_bytes"`
+
+	// The lines sent successfully
+	SentLines int64 `json:"sent_lines"`
+
+	// The count of errors of parsing and sending
+	ParseErrors int64 `json:"parse_errors"`
+	SendErrors  int64 `json:"send_errors"`
+
+	// The last error reported by the runner
+	LastError string `json:"last_error,omitempty"`
+
+	// The last time the runner reads new lines
+	LastActivity time.Time `json:"last_activity"`
+
+	// The time the status is collected
+	CollectedAt time.Time `json:"collected_at"`
+
+	// The reason why the logs fail to ship, "" means the runner works well
+	FailingReason string `json:"failing_reason,omitempty"`
+}
+
+func NewLogSource(pod *v1.Pod, config *LogConfig, stream *LogStream) *LogSource {
 	return &LogSource{
 		Meta: Meta{
-			Name: fmt.Sprintf("%s_%s_%s_%s", config.Kind, config.Name, config.VolumeMount, pod.Name),
+			Name: fmt.Sprintf("%s_%s_%s_%s", config.Kind, config.Name, stream.Name, pod.Name),
 		},
 		Spec: LogSourceSpec{
 			Namespace:      pod.ObjectMeta.Namespace,
 			PodName:        pod.ObjectMeta.Name,
-			VolumeMount:    config.VolumeMount,
-			Config:         config.Config,
-			ControllerName: fmt.Sprintf("%s_%s", config.Kind, config.Name),
+			NodeName:       pod.Spec.NodeName,
+			PodLabels:      pod.ObjectMeta.Labels,
+			PodAnnotations: pod.ObjectMeta.Annotations,
+			Stream:         stream.Name,
+			VolumeMount:    stream.VolumeMount,
+			Config:         stream.Config,
+			Collect:        stream.Collect,
+			Secrets:        config.Secrets,
+			ControllerName: config.GetControllerName(),
+			AgentType:      config.AgentType,
+			NodeLogDir:     stream.GetNodeLogDir(pod),
 		},
 	}
 }
 
+// Create the log sources of the containers of pod chosen by the container stream. The containers not started yet
+// are skipped, their log dirs are created and their runtimes are known only when they start.
+func NewContainerLogSources(pod *v1.Pod, config *LogConfig, stream *LogStream) []LogSource {
+	chosen := make(map[string]bool)
+	for _, container := range stream.Containers {
+		chosen[container] = true
+	}
+
+	logSources := make([]LogSource, 0)
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.ContainerID == "" || (len(chosen) != 0 && !chosen[status.Name]) {
+			continue
+		}
+		logSource := NewLogSource(pod, config, stream)
+		logSource.Meta.Name = fmt.Sprintf("%s_%s", logSource.Meta.Name, status.Name)
+		logSource.Spec.Container = status.Name
+		logSource.Spec.ContainerLogFormat = getContainerLogFormat(status.ContainerID)
+		logSource.Spec.NodeLogDir = fmt.Sprintf("%s/%s_%s_%s/%s", PodLogsDir, pod.Namespace, pod.Name, pod.UID, status.Name)
+		logSources = append(logSources, *logSource)
+	}
+	return logSources
+}
+
+// The container id is "<runtime>://<id>", only docker writes the json log, the others of CRI write the cri log
+func getContainerLogFormat(containerID string) string {
+	if strings.HasPrefix(containerID, "docker://") {
+		return ContainerLogDocker
+	}
+	return ContainerLogCRI
+}
+
+// Check whether the log source is the stdout/stderr of a container
+func (l *LogSource) IsContainer() bool {
+	return l.Spec.Container != ""
+}
+
+// Check whether the log is on the node of the pod, which is collected only by the agent on the node
+func (l *LogSource) IsNodeLocal() bool {
+	return l.Spec.NodeLogDir != ""
+}
+
+// Return the dir which holds the log, it is the same path in logmanager and log agents for the log on pvc,
+// and the path on the node for the node-local log
 func (l *LogSource) GetLogDir() string {
+	if l.IsNodeLocal() {
+		return l.Spec.NodeLogDir
+	}
 	return fmt.Sprintf("%s/%s_%s", l.getVolumeMountPath(), l.Spec.Namespace, l.Spec.PodName)
 }
 
 func (l *LogSource) GetLogMetaDir() string {
-	return fmt.Sprintf("%s/%s_%s/.meta", l.getVolumeMountPath(), l.Spec.Namespace, l.Spec.PodName)
+	return fmt.Sprintf("%s/.meta", l.GetLogDir())
 }
 
 // The mountPath of the log source into logmanager
 // For example if we want logmanager to collect the file log of deployment whose name is "boots-gate", the file log of boots-gate is its volumeMounts "applog" which is backed by a pvc name boots-gate-applog
 // Then this pvc should also be mounted to logmanager, and mountPath should be "/deployment_boots-gate_applog"
 func (l *LogSource) getVolumeMountPath() string {
-	return fmt.Sprintf("/%s_%s", l.Spec.ControllerName, l.Spec.VolumeMount)
+	return GetVolumeMountPath(l.Spec.ControllerName, l.Spec.VolumeMount)
+}
+
+// The mountPath of the volume of the controller into logmanager and log agents
+func GetVolumeMountPath(controllerName, volumeMount string) string {
+	return fmt.Sprintf("/%s_%s", controllerName, volumeMount)
 }
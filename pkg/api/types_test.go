@@ -0,0 +1,205 @@
+package api
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetStreams(t *testing.T) {
+	cfg := &LogConfig{
+		Name:        "boots-gate",
+		Kind:        "deployment",
+		VolumeMount: "applog",
+		Config:      "{}",
+		Streams: []LogStream{
+			{VolumeMount: "auditlog", Config: "{}"},
+			{Name: "accesslog", VolumeMount: "applog", Config: "{}"},
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("log config should be valid, err: %v", err)
+	}
+
+	streams := cfg.GetStreams()
+	if len(streams) != 3 {
+		t.Fatalf("log config should have 3 streams, has %d", len(streams))
+	}
+	if streams[0].Name != "applog" || streams[1].Name != "auditlog" || streams[2].Name != "accesslog" {
+		t.Errorf("stream names are wrong, are %s, %s, %s", streams[0].Name, streams[1].Name, streams[2].Name)
+	}
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "boots-gate-xxx-yyy",
+			Namespace: "test-ns",
+		},
+	}
+	names := make(map[string]bool)
+	for i := range streams {
+		logSource := NewLogSource(pod, cfg, &streams[i])
+		if names[logSource.Meta.Name] {
+			t.Errorf("log source name %s is duplicated", logSource.Meta.Name)
+		}
+		names[logSource.Meta.Name] = true
+	}
+
+	logSource := NewLogSource(pod, cfg, &streams[1])
+	if logSource.GetLogDir() != "/deployment_boots-gate_auditlog/test-ns_boots-gate-xxx-yyy" {
+		t.Errorf("log dir is wrong, is %s", logSource.GetLogDir())
+	}
+}
+
+func TestValidateDuplicatedStream(t *testing.T) {
+	cfg := &LogConfig{
+		Name: "boots-gate",
+		Kind: "deployment",
+		Streams: []LogStream{
+			{VolumeMount: "applog", Config: "{}"},
+			{VolumeMount: "applog", Config: "{}"},
+		},
+	}
+
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("log config with duplicated streams should be invalid")
+	}
+
+	if err := (&LogConfig{Name: "boots-gate", Kind: "deployment"}).Validate(); err == nil {
+		t.Errorf("log config without streams should be invalid")
+	}
+}
+
+func TestValidateCollect(t *testing.T) {
+	collect := &CollectSpec{
+		Paths:  []string{"*.log"},
+		Parser: &ParserSpec{Type: ParserRegex, Pattern: `^(?P<level>\w+)`},
+		Destination: DestinationSpec{
+			Type: DestinationHTTP,
+			URL:  "http://collector:8080",
+		},
+	}
+	cfg := &LogConfig{
+		Name:    "boots-gate",
+		Kind:    "deployment",
+		Streams: []LogStream{{VolumeMount: "applog", Collect: collect}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("log config with collect spec should be valid, err: %v", err)
+	}
+
+	cfg.Streams[0].Config = "{}"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("log stream with both config and collect should be invalid")
+	}
+
+	for _, invalid := range []CollectSpec{
+		{Paths: []string{"sub/*.log"}, Destination: collect.Destination},
+		{Parser: &ParserSpec{Type: ParserRegex, Pattern: `^\w+`}, Destination: collect.Destination},
+		{Destination: DestinationSpec{Type: DestinationElasticsearch, Hosts: []string{"http://es:9200"}}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("collect spec %+v should be invalid", invalid)
+		}
+	}
+}
+
+func TestNodeLocalStream(t *testing.T) {
+	cfg := &LogConfig{
+		Name: "boots-gate",
+		Kind: "deployment",
+		Streams: []LogStream{
+			{VolumeMount: "applog", VolumeType: VolumeHostPath, HostPath: "/data/logs/"},
+			{VolumeMount: "cache", VolumeType: VolumeEmptyDir},
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("log config with node-local streams should be valid, err: %v", err)
+	}
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "boots-gate-xxx-yyy",
+			Namespace: "test-ns",
+			UID:       "1234",
+		},
+		Spec: v1.PodSpec{NodeName: "node-1"},
+	}
+	streams := cfg.GetStreams()
+	for i, expected := range []string{
+		"/data/logs/test-ns_boots-gate-xxx-yyy",
+		"/var/lib/kubelet/pods/1234/volumes/kubernetes.io~empty-dir/cache",
+	} {
+		logSource := NewLogSource(pod, cfg, &streams[i])
+		if !logSource.IsNodeLocal() || logSource.GetLogDir() != expected {
+			t.Errorf("log dir of node-local stream %s is wrong, is %s", streams[i].Name, logSource.GetLogDir())
+		}
+		if logSource.GetLogMetaDir() != expected+"/.meta" {
+			t.Errorf("log meta dir of node-local stream %s is wrong, is %s", streams[i].Name, logSource.GetLogMetaDir())
+		}
+	}
+
+	cfg.Streams[0].HostPath = "data/logs"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("hostpath stream with relative host path should be invalid")
+	}
+}
+
+func TestContainerStream(t *testing.T) {
+	cfg := &LogConfig{
+		Name: "boots-gate",
+		Kind: "deployment",
+		Streams: []LogStream{
+			{Name: "stdout", Source: SourceContainer, Containers: []string{"app", "sidecar"}},
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("log config with container stream should be valid, err: %v", err)
+	}
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "boots-gate-xxx-yyy",
+			Namespace: "test-ns",
+			UID:       "1234",
+		},
+		Spec: v1.PodSpec{NodeName: "node-1"},
+		Status: v1.PodStatus{
+			ContainerStatuses: []v1.ContainerStatus{
+				{Name: "app", ContainerID: "containerd://abc"},
+				{Name: "sidecar", ContainerID: "docker://def"},
+				{Name: "proxy", ContainerID: "containerd://ghi"},
+			},
+		},
+	}
+	streams := cfg.GetStreams()
+	logSources := NewContainerLogSources(pod, cfg, &streams[0])
+	if len(logSources) != 2 {
+		t.Fatalf("only the chosen containers should be log sources, are %d", len(logSources))
+	}
+	app := logSources[0]
+	if app.Meta.Name != "deployment_boots-gate_stdout_boots-gate-xxx-yyy_app" || app.Spec.Container != "app" {
+		t.Errorf("log source of container app is wrong, is %+v", app)
+	}
+	if app.GetLogDir() != "/var/log/pods/test-ns_boots-gate-xxx-yyy_1234/app" || !app.IsNodeLocal() {
+		t.Errorf("log dir of container app is wrong, is %s", app.GetLogDir())
+	}
+	if app.Spec.ContainerLogFormat != ContainerLogCRI || logSources[1].Spec.ContainerLogFormat != ContainerLogDocker {
+		t.Errorf("container log formats are wrong, are %s and %s", app.Spec.ContainerLogFormat, logSources[1].Spec.ContainerLogFormat)
+	}
+	if patterns := app.GetFilePatterns(); len(patterns) != 1 || patterns[0] != "*.log" {
+		t.Errorf("file patterns of container log are wrong, are %v", patterns)
+	}
+
+	// The container not started yet has no log source
+	pod.Status.ContainerStatuses[1].ContainerID = ""
+	if logSources = NewContainerLogSources(pod, cfg, &streams[0]); len(logSources) != 1 {
+		t.Errorf("the container not started should be skipped, log sources are %d", len(logSources))
+	}
+
+	cfg.Streams[0].VolumeMount = "applog"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("container stream with volume mount should be invalid")
+	}
+}
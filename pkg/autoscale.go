@@ -0,0 +1,197 @@
+package logmanager
+
+import (
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+)
+
+// The config used to scale log agents according to the count and lag of logSources
+type AutoscaleConfig struct {
+	// The range of replicas of log agents, MaxReplicas 0 means autoscale is disabled
+	MinReplicas int32
+	MaxReplicas int32
+
+	// The target count of logSources per agent
+	SourcesPerAgent int
+
+	// Scale up by one agent when the ratio of lagging logSources exceeds it, 0 means lag is not considered
+	LagRatio float64
+}
+
+// Scale the log agents of every agent type between MinReplicas and MaxReplicas
+func (lm *LogManager) autoscale() {
+	// The draining agents which are already removed are not needed anymore
+	for name := range lm.Draining {
+		if _, exist := lm.LogAgents[name]; !exist {
+			delete(lm.Draining, name)
+		}
+	}
+
+	for _, agentType := range lm.agentTypes() {
+		lm.autoscaleType(agentType)
+	}
+}
+
+// Scale the log agents of one agent type. Before scaling down, the agents with the highest ordinals are
+// drained first, their logSources are moved to other agents through the normal Move path.
+func (lm *LogManager) autoscaleType(agentType agent.AgentType) {
+	logger := log.WithFields(log.Fields{
+		"func":      "autoscale",
+		"agentType": agentType,
+	})
+
+	scaler, ok := lm.LogAgentManagers[agentType].(agent.Scaler)
+	if !ok {
+		logger.Debugf("The agent manager does not support scaling")
+		return
+	}
+
+	logAgents := agentsOfType(lm.LogAgents, agentType)
+	draining := 0
+	for name, a := range logAgents {
+		if a.Node != "" {
+			logger.Debugf("The log agents of daemonset are not scaled")
+			return
+		}
+		if lm.Draining[name] {
+			draining++
+		}
+	}
+
+	current, err := scaler.GetReplicas()
+	if err != nil {
+		logger.Errorf("Get replicas of log agents failed, err: %v", err)
+		return
+	}
+
+	sources, lagging := 0, 0
+	for k, m := range lm.Match {
+		logSource, exist := lm.LogSources[k]
+		if m.PodName == "" || !exist || agent.AgentType(logSource.Spec.AgentType) != agentType {
+			continue
+		}
+		sources++
+		if m.AgentName != "" && m.ConfPath != "" && !lm.agentManagerOf(logSource).CheckLag(logSource, m.AgentName) {
+			lagging++
+		}
+	}
+
+	desired := computeReplicas(sources, lagging, current, &lm.Autoscale)
+	logger.Debugf("Log agents: current replicas %d, desired replicas %d, logSources %d, lagging %d", current, desired, sources, lagging)
+
+	if desired >= current {
+		// The draining agents are kept until they are removed by scaling down, unless the load goes up again
+		// or another agent is removed instead of them
+		if draining != 0 && (desired > current || len(logAgents) <= int(desired)) {
+			logger.Infof("Stop draining log agents of type %s", agentType)
+			for name := range logAgents {
+				delete(lm.Draining, name)
+			}
+		}
+		if desired > current {
+			logger.Infof("Scale up log agents from %d to %d", current, desired)
+			err = scaler.Scale(desired, nil)
+			if err != nil {
+				logger.Errorf("Scale up log agents failed, err: %v", err)
+			}
+		}
+		return
+	}
+
+	victims := pickVictims(logAgents, int(current-desired))
+	for name := range logAgents {
+		delete(lm.Draining, name)
+	}
+	for _, victim := range victims {
+		lm.Draining[victim] = true
+	}
+
+	// Unassign the logSources of draining agents, so that they are re-scheduled and moved to other agents
+	drained := true
+	for k, m := range lm.Match {
+		if m.AgentName != "" && lm.Draining[m.AgentName] {
+			logger.Infof("LogSource %s is moved out of draining agent %s", k, m.AgentName)
+			m.AgentName = ""
+		}
+		confAgent := lm.confAgentOf(k)
+		for _, victim := range victims {
+			if confAgent == victim {
+				drained = false
+			}
+		}
+	}
+	if !drained {
+		logger.Infof("Wait for draining log agents %v before scaling down", victims)
+		return
+	}
+
+	logger.Infof("Scale down log agents from %d to %d, remove %v", current, desired, victims)
+	err = scaler.Scale(desired, victims)
+	if err != nil {
+		logger.Errorf("Scale down log agents failed, err: %v", err)
+	}
+}
+
+// Return the replicas needed by the logSources
+func computeReplicas(sources, lagging int, current int32, cfg *AutoscaleConfig) int32 {
+	desired := current
+	if cfg.SourcesPerAgent > 0 {
+		desired = int32((sources + cfg.SourcesPerAgent - 1) / cfg.SourcesPerAgent)
+	}
+	if cfg.LagRatio > 0 && sources > 0 && float64(lagging)/float64(sources) > cfg.LagRatio && desired <= current {
+		desired = current + 1
+	}
+
+	if desired < cfg.MinReplicas {
+		desired = cfg.MinReplicas
+	}
+	if desired > cfg.MaxReplicas {
+		desired = cfg.MaxReplicas
+	}
+	return desired
+}
+
+// Return the count agents with the highest ordinals
+func pickVictims(logAgentsMap map[string]*agent.Agent, count int) []string {
+	agents := make([]*agent.Agent, 0, len(logAgentsMap))
+	for _, a := range logAgentsMap {
+		agents = append(agents, a)
+	}
+	sort.Slice(agents, func(i, j int) bool {
+		if agents[i].Ordinal == agents[j].Ordinal {
+			return agents[i].Name > agents[j].Name
+		}
+		return agents[i].Ordinal > agents[j].Ordinal
+	})
+
+	victims := make([]string, 0, count)
+	for i := 0; i < count && i < len(agents); i++ {
+		victims = append(victims, agents[i].Name)
+	}
+	return victims
+}
+
+// Return the agents which logSources can be scheduled to, the draining agents are excluded
+func schedulableAgents(logAgentsMap map[string]*agent.Agent, draining map[string]bool) map[string]*agent.Agent {
+	agents := make(map[string]*agent.Agent)
+	for k, a := range logAgentsMap {
+		if !draining[k] {
+			agents[k] = a
+		}
+	}
+	return agents
+}
+
+// Return the agents of the agent type
+func agentsOfType(logAgentsMap map[string]*agent.Agent, agentType agent.AgentType) map[string]*agent.Agent {
+	agents := make(map[string]*agent.Agent)
+	for k, a := range logAgentsMap {
+		if a.Type == agentType {
+			agents[k] = a
+		}
+	}
+	return agents
+}
@@ -0,0 +1,54 @@
+package logmanager
+
+import (
+	"testing"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+)
+
+func TestComputeReplicas(t *testing.T) {
+	cfg := &AutoscaleConfig{
+		MinReplicas:     1,
+		MaxReplicas:     5,
+		SourcesPerAgent: 10,
+		LagRatio:        0.5,
+	}
+
+	cases := []struct {
+		sources  int
+		lagging  int
+		current  int32
+		expected int32
+	}{
+		{0, 0, 3, 1},
+		{25, 0, 1, 3},
+		{100, 0, 3, 5},
+		{20, 0, 3, 2},
+		{20, 15, 2, 3},
+		{20, 15, 5, 5},
+	}
+
+	for i, c := range cases {
+		if desired := computeReplicas(c.sources, c.lagging, c.current, cfg); desired != c.expected {
+			t.Errorf("case %d: desired replicas should be %d, is %d", i, c.expected, desired)
+		}
+	}
+}
+
+func TestPickVictims(t *testing.T) {
+	agents := map[string]*agent.Agent{
+		"logkit-a": {Name: "logkit-a", Ordinal: 0},
+		"logkit-b": {Name: "logkit-b", Ordinal: 2},
+		"logkit-c": {Name: "logkit-c", Ordinal: 1},
+	}
+
+	victims := pickVictims(agents, 2)
+	if len(victims) != 2 || victims[0] != "logkit-b" || victims[1] != "logkit-c" {
+		t.Errorf("victims should be the agents with highest ordinals, are %v", victims)
+	}
+
+	schedulable := schedulableAgents(agents, map[string]bool{"logkit-b": true})
+	if _, exist := schedulable["logkit-b"]; exist || len(schedulable) != 2 {
+		t.Errorf("draining agents should not be schedulable, are %v", schedulable)
+	}
+}
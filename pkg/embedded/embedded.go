@@ -0,0 +1,196 @@
+package embedded
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+	"github.com/fatsheep9146/kirklog/pkg/logexporter"
+	"github.com/fatsheep9146/kirklog/pkg/secret"
+)
+
+const (
+	// The logSource lags when its unshipped bytes are more than this
+	MaxLagBytes = logexporter.MaxLagBytes
+
+	// The interval to sync the runners with the config files, and to scan the log dirs
+	DefaultScanInterval = logexporter.DefaultScanInterval
+)
+
+var confFileOptions = agent.FileOptions{Mode: 0600, UID: -1, GID: -1}
+
+// EmbeddedAgentManagerImpl ships the logs in the kirklog process itself, which mounts the log volumes already.
+// There is only one log agent, the kirklog process, whose runner configs are the config files of logexporter
+// in a local conf dir. They are run by an exporter of logexporter, so the offsets kept in the log meta dir are
+// shared with the logexporter agents.
+type EmbeddedAgentManagerImpl struct {
+	Cli        *kubernetes.Clientset
+	Name       string
+	Namespace  string
+	LogConfigs []api.LogConfig
+	Secrets    *secret.Store
+
+	// The name of the only log agent
+	AgentName string
+
+	// The dir which holds the runner configs, the configs of the agent are in the dir named by the agent
+	ConfDir string
+
+	// The exporter which runs the runner configs of the agent
+	Exporter *logexporter.Exporter
+}
+
+func init() {
+	agent.Register(agent.Backend{
+		Type: agent.Embedded,
+		New:  NewEmbeddedAgentManager,
+		Schema: agent.ConfigSchema{
+			Format:      api.FormatJSON,
+			Description: "ships logs in the kirklog process by the runners of logexporter, without agent pods",
+		},
+		Compile: logexporter.CompileSpec,
+	})
+}
+
+func NewEmbeddedAgentManager(cfg *agent.AgentManagerConfig) agent.AgentManager {
+	logger := log.WithFields(log.Fields{
+		"func": "NewEmbeddedAgentManager",
+	})
+
+	e := &EmbeddedAgentManagerImpl{
+		Cli:        cfg.Cli,
+		Name:       cfg.Name,
+		Namespace:  cfg.Namespace,
+		LogConfigs: cfg.LogConfigs,
+		Secrets:    cfg.Secrets,
+		AgentName:  fmt.Sprintf("%s-embedded", cfg.Name),
+		ConfDir:    filepath.Join(os.TempDir(), "kirklog-embedded"),
+	}
+
+	// The runner configs are not kept across restarts, logmanager adds them again after it starts
+	if err := os.RemoveAll(e.ConfDir); err != nil {
+		logger.Warnf("Clean the conf dir %s failed, err: %v", e.ConfDir, err)
+	}
+	e.Exporter = logexporter.NewExporter(e.getAgentConfDir(), DefaultScanInterval)
+
+	// The exporter runs as long as the kirklog process
+	go e.Exporter.Run(make(chan struct{}))
+	return e
+}
+
+// Nothing is deployed, the only log agent is the kirklog process
+func (e *EmbeddedAgentManagerImpl) Deploy() error {
+	return nil
+}
+
+func (e *EmbeddedAgentManagerImpl) List() ([]agent.Agent, error) {
+	return []agent.Agent{{
+		Name:     e.AgentName,
+		ConfPath: e.getAgentConfDir(),
+	}}, nil
+}
+
+// Add the runner config of one logSource, the runner is started at once
+func (e *EmbeddedAgentManagerImpl) AddConfig(logSource *api.LogSource, agentName string) (string, error) {
+	secrets := make(map[string]string)
+	if e.Secrets != nil {
+		var err error
+		secrets, err = e.Secrets.Resolve(logSource)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	config, err := logexporter.RenderConfig(logSource, secrets)
+	if err != nil {
+		return "", err
+	}
+
+	filePath := filepath.Join(e.getAgentConfDir(), getConfigFileName(logSource))
+	err = os.MkdirAll(e.getAgentConfDir(), 0755)
+	if err != nil {
+		return "", err
+	}
+	// The config files are read only by this process, and they have the resolved secrets
+	_, err = agent.WriteConfigFile(filePath, []byte(config), confFileOptions)
+	if err != nil {
+		return "", err
+	}
+	err = e.Exporter.Sync()
+	if err != nil {
+		return "", err
+	}
+
+	logSource.Status.ConfigStatus.Path = filePath
+
+	return filePath, nil
+}
+
+// Delete the runner config of one logSource, the runner is stopped at once
+func (e *EmbeddedAgentManagerImpl) DelConfig(logSource *api.LogSource, agentName string) error {
+	filePath := filepath.Join(e.getAgentConfDir(), getConfigFileName(logSource))
+
+	// The config file may be removed already by a previous try
+	err := os.Remove(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return e.Exporter.Sync()
+}
+
+// Check whether the logs of logSource are shipped, by the offsets saved in the log meta dir
+func (e *EmbeddedAgentManagerImpl) CheckLag(logSource *api.LogSource, agentName string) bool {
+	logger := log.WithFields(log.Fields{
+		"func":      "CheckLag",
+		"logSource": logSource.Meta.Name,
+	})
+
+	lag, err := logexporter.Lag(logSource.GetLogDir(), logSource.GetLogMetaDir(), logSource.GetFilePatterns())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warnf("Get the lag of logSource failed, err: %v", err)
+		}
+		return true
+	}
+	return lag <= MaxLagBytes
+}
+
+func (e *EmbeddedAgentManagerImpl) GetAgentNameFromConf(confpath string) string {
+	return filepath.Base(filepath.Dir(confpath))
+}
+
+// Check whether the runner of logSource is stopped
+func (e *EmbeddedAgentManagerImpl) RunnerStopped(logSource *api.LogSource, agentName string) (bool, error) {
+	_, exist := e.Exporter.Status()[logSource.Meta.Name]
+	return !exist, nil
+}
+
+// Collect the status of logSources from the runners of the exporter
+func (e *EmbeddedAgentManagerImpl) CollectStatus(agentName string, logSources []*api.LogSource) (map[string]api.RunnerStatus, error) {
+	runners := e.Exporter.Status()
+
+	now := time.Now()
+	result := make(map[string]api.RunnerStatus)
+	for _, logSource := range logSources {
+		runner, exist := runners[logSource.Meta.Name]
+		if !exist {
+			continue
+		}
+		result[logSource.Meta.Name] = runner.APIStatus(agentName, now)
+	}
+	return result, nil
+}
+
+func (e *EmbeddedAgentManagerImpl) getAgentConfDir() string {
+	return filepath.Join(e.ConfDir, e.AgentName)
+}
+
+func getConfigFileName(logSource *api.LogSource) string {
+	return fmt.Sprintf("%s.json", logSource.Meta.Name)
+}
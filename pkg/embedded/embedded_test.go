@@ -0,0 +1,62 @@
+package embedded
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fatsheep9146/kirklog/pkg/api"
+	"github.com/fatsheep9146/kirklog/pkg/logexporter"
+)
+
+func TestRunnerLifecycle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "embedded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := &EmbeddedAgentManagerImpl{
+		Name:      "kirklog",
+		AgentName: "kirklog-embedded",
+		ConfDir:   dir,
+	}
+	e.Exporter = logexporter.NewExporter(e.getAgentConfDir(), DefaultScanInterval)
+
+	logSource := &api.LogSource{
+		Meta: api.Meta{Name: "deployment_test_applog_test-xxx-yyy"},
+		Spec: api.LogSourceSpec{
+			PodName:        "test-xxx-yyy",
+			Namespace:      "test-ns",
+			ControllerName: "deployment_test",
+			Stream:         "applog",
+			VolumeMount:    "applog",
+			Config:         `{"sink": {"path": "` + filepath.Join(dir, "out.json") + `"}}`,
+		},
+	}
+
+	agents, _ := e.List()
+	if len(agents) != 1 || agents[0].Name != e.AgentName {
+		t.Fatalf("embedded manager should have only its own agent, has %v", agents)
+	}
+
+	confPath, err := e.AddConfig(logSource, e.AgentName)
+	if err != nil {
+		t.Fatalf("add config failed, err: %v", err)
+	}
+	if e.GetAgentNameFromConf(confPath) != e.AgentName {
+		t.Errorf("agent name of %s is wrong, is %s", confPath, e.GetAgentNameFromConf(confPath))
+	}
+	if stopped, _ := e.RunnerStopped(logSource, e.AgentName); stopped {
+		t.Errorf("runner should be started once the config is added")
+	}
+
+	err = e.DelConfig(logSource, e.AgentName)
+	if err != nil {
+		t.Fatalf("delete config failed, err: %v", err)
+	}
+	if stopped, _ := e.RunnerStopped(logSource, e.AgentName); !stopped {
+		t.Errorf("runner should be stopped once the config is deleted")
+	}
+}
@@ -0,0 +1,76 @@
+package filebeat
+
+import (
+	"fmt"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+// Compile the collect spec into the config of log stream of filebeat, the paths are taken by renderInput
+func compileSpec(spec *api.CollectSpec) (string, error) {
+	config := StreamConfig{Input: make(map[string]interface{})}
+
+	// The filestream input starts from the beginning of new files, and can not start from the end
+	if spec.ReadFrom == api.ReadFromNewest {
+		return "", fmt.Errorf("read_from %s is not supported by filebeat", spec.ReadFrom)
+	}
+
+	parsers := make([]interface{}, 0)
+	if spec.Multiline != nil {
+		parsers = append(parsers, map[string]interface{}{
+			"multiline": map[string]interface{}{
+				"type":    "pattern",
+				"pattern": spec.Multiline.Start,
+				"negate":  true,
+				"match":   "after",
+			},
+		})
+	}
+	if spec.Parser != nil {
+		switch spec.Parser.Type {
+		case api.ParserRaw:
+		case api.ParserJSON:
+			parsers = append(parsers, map[string]interface{}{
+				"ndjson": map[string]interface{}{
+					"target":        "",
+					"add_error_key": true,
+				},
+			})
+		default:
+			return "", fmt.Errorf("parser %s is not supported by filebeat", spec.Parser.Type)
+		}
+	}
+	if len(parsers) != 0 {
+		config.Input["parsers"] = parsers
+	}
+
+	if len(spec.Fields) != 0 {
+		fields := make(map[string]interface{})
+		for k, v := range spec.Fields {
+			fields[k] = v
+		}
+		config.Input["fields"] = fields
+	}
+
+	dest := spec.Destination
+	if dest.Type != api.DestinationElasticsearch {
+		return "", fmt.Errorf("destination %s is not supported by filebeat", dest.Type)
+	}
+	elasticsearch := map[string]interface{}{
+		"hosts": dest.Hosts,
+		"index": dest.Index,
+	}
+	if dest.Username != "" {
+		elasticsearch["username"] = dest.Username
+		elasticsearch["password"] = dest.Password
+	}
+	config.Output = map[string]interface{}{"elasticsearch": elasticsearch}
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
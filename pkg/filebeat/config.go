@@ -0,0 +1,274 @@
+package filebeat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+const (
+	// The type of the input of logSource, which can be overridden by the config of log stream
+	DefaultInputType = "filestream"
+
+	// The period to reload the input files
+	InputReloadPeriod = "10s"
+)
+
+// The keys of the input which are decided by kirklog, and can not be overridden by the config of log stream
+var fixedInputKeys = []string{"id", "paths"}
+
+// StreamConfig is the config of one log stream for filebeat, which is written in YAML. For example:
+//
+//	input:
+//	  parsers:
+//	    - multiline: {type: pattern, pattern: '^\[', negate: true, match: after}
+//	processors:
+//	  - drop_fields: {fields: [agent]}
+//	output:
+//	  elasticsearch: {hosts: ["es:9200"]}
+type StreamConfig struct {
+	// The settings merged into the input of logSource, for example parsers, exclude_lines or index
+	Input map[string]interface{} `json:"input,omitempty"`
+
+	// The processors of the input of logSource
+	Processors []interface{} `json:"processors,omitempty"`
+
+	// The output of the agent, filebeat has only one output, so it should be the same in all the log streams
+	Output map[string]interface{} `json:"output,omitempty"`
+}
+
+func parseStreamConfig(raw string) (*StreamConfig, error) {
+	data, err := yaml.YAMLToJSON([]byte(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	config := &StreamConfig{}
+	if string(data) == "null" {
+		return config, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filebeat config of log stream, err: %v", err)
+	}
+	return config, nil
+}
+
+// Render the input file of logSource, which is a list of one input reading the log dir of logSource.
+// The kubernetes metadata is added as fields like the k8sdir transform of logkit.
+func renderInput(logSource *api.LogSource, secrets map[string]string) (string, error) {
+	config, err := renderStreamConfig(logSource, secrets)
+	if err != nil {
+		return "", err
+	}
+
+	input := map[string]interface{}{
+		"type":              DefaultInputType,
+		"fields_under_root": true,
+	}
+	for k, v := range config.Input {
+		if !isFixedInputKey(k) {
+			input[k] = v
+		}
+	}
+	input["id"] = getInputID(logSource)
+	input["paths"] = logSource.GetLogPaths()
+
+	// The lines of container log are parsed in the format of its runtime before the parsers of the config,
+	// which also joins the split messages
+	if logSource.IsContainer() {
+		if input["type"] != DefaultInputType {
+			return "", fmt.Errorf("input %v is not supported for the container log", input["type"])
+		}
+		parsers := []interface{}{
+			map[string]interface{}{
+				"container": map[string]interface{}{
+					"stream": "all",
+					"format": logSource.Spec.ContainerLogFormat,
+				},
+			},
+		}
+		if userParsers, exist := input["parsers"]; exist {
+			list, ok := userParsers.([]interface{})
+			if !ok {
+				return "", fmt.Errorf("parsers of input should be a list")
+			}
+			parsers = append(parsers, list...)
+		}
+		input["parsers"] = parsers
+	}
+
+	fields := make(map[string]interface{})
+	if userFields, ok := input["fields"].(map[string]interface{}); ok {
+		for k, v := range userFields {
+			fields[k] = v
+		}
+	}
+	for k, v := range map[string]string{
+		"k8s_namespace":  logSource.Spec.Namespace,
+		"k8s_pod_name":   logSource.Spec.PodName,
+		"k8s_node_name":  logSource.Spec.NodeName,
+		"k8s_controller": logSource.Spec.ControllerName,
+		"k8s_stream":     logSource.Spec.Stream,
+		"k8s_container":  logSource.Spec.Container,
+	} {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+	input["fields"] = fields
+
+	if len(config.Processors) != 0 {
+		input["processors"] = config.Processors
+	}
+
+	data, err := yaml.Marshal([]interface{}{input})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Return the output shared by all the log streams. The config of every log stream is rendered without pod,
+// and the secrets of LogConfig are fetched by getSecrets. The LogConfig whose output differs from the output of
+// the LogConfigs before it, or can not be rendered, is rejected alone. The rejected log streams are returned
+// keyed by getStreamKey, so that their logs are never shipped to the output of the others.
+func renderOutput(logConfigs []api.LogConfig, getSecrets func(namespace string, refs map[string]api.SecretKeySelector) (map[string]string, error)) (map[string]interface{}, map[string]error, error) {
+	var output map[string]interface{}
+	var outputStream string
+	rejected := make(map[string]error)
+
+	for i := range logConfigs {
+		logConfig := &logConfigs[i]
+		configOutput, configStream, err := renderLogConfigOutput(logConfig, getSecrets)
+		if err == nil && configOutput != nil {
+			if output == nil {
+				output, outputStream = configOutput, configStream
+			} else if !reflect.DeepEqual(output, configOutput) {
+				err = fmt.Errorf("filebeat supports only one output, log streams %s and %s have different outputs", outputStream, configStream)
+			}
+		}
+		if err != nil {
+			for _, stream := range logConfig.GetStreams() {
+				rejected[getStreamKey(logConfig.Namespace, logConfig.GetControllerName(), stream.Name)] = err
+			}
+		}
+	}
+
+	if output == nil {
+		return nil, rejected, fmt.Errorf("no output in the config of log streams")
+	}
+	return output, rejected, nil
+}
+
+// Return the output of the log streams of logConfig, and the name of the log stream declaring it. The output is
+// shared by all the pods, so it should never reference the pod.
+func renderLogConfigOutput(logConfig *api.LogConfig, getSecrets func(namespace string, refs map[string]api.SecretKeySelector) (map[string]string, error)) (map[string]interface{}, string, error) {
+	var secrets map[string]string
+	if len(logConfig.Secrets) != 0 {
+		var err error
+		secrets, err = getSecrets(logConfig.Namespace, logConfig.Secrets)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	var output map[string]interface{}
+	var outputStream string
+	for _, stream := range logConfig.GetStreams() {
+		name := fmt.Sprintf("%s_%s", logConfig.GetControllerName(), stream.Name)
+		logSource := &api.LogSource{
+			Meta: api.Meta{Name: name},
+			Spec: api.LogSourceSpec{
+				Namespace:      logConfig.Namespace,
+				ControllerName: logConfig.GetControllerName(),
+				Stream:         stream.Name,
+				Config:         stream.Config,
+				Collect:        stream.Collect,
+			},
+		}
+		config, err := renderStreamConfig(logSource, secrets)
+		if err != nil {
+			return nil, "", fmt.Errorf("log stream %s: %v", name, err)
+		}
+		if config.Output == nil {
+			continue
+		}
+		placeholderConfig, err := renderStreamConfig(logSource.WithPlaceholderPod(), secrets)
+		if err != nil {
+			return nil, "", fmt.Errorf("log stream %s: %v", name, err)
+		}
+		if !reflect.DeepEqual(config.Output, placeholderConfig.Output) {
+			return nil, "", fmt.Errorf("output of log stream %s references the pod, which is unknown to the output shared by all the pods", name)
+		}
+
+		if output == nil {
+			output, outputStream = config.Output, name
+		} else if !reflect.DeepEqual(output, config.Output) {
+			return nil, "", fmt.Errorf("filebeat supports only one output, log streams %s and %s have different outputs", outputStream, name)
+		}
+	}
+	return output, outputStream, nil
+}
+
+// Render the config of the log stream of logSource
+func renderStreamConfig(logSource *api.LogSource, secrets map[string]string) (*StreamConfig, error) {
+	configRaw, err := agent.RenderConfig(logSource, secrets, api.FormatYAML, compileSpec)
+	if err != nil {
+		return nil, err
+	}
+	return parseStreamConfig(configRaw)
+}
+
+// Return the key of the log stream of the controller in namespace
+func getStreamKey(namespace, controllerName, stream string) string {
+	return fmt.Sprintf("%s/%s_%s", namespace, controllerName, stream)
+}
+
+// Render the main config of filebeat, which reloads the input files in the conf dir of the agent
+func renderMainConfig(output map[string]interface{}) (string, error) {
+	config := map[string]interface{}{
+		"filebeat.config.inputs": map[string]interface{}{
+			"enabled":        true,
+			"path":           fmt.Sprintf("%s/${POD_NAME}/*.yml", FilebeatConfVolumeMountPath),
+			"reload.enabled": true,
+			"reload.period":  InputReloadPeriod,
+		},
+		"http.enabled": true,
+		"http.host":    "0.0.0.0",
+		"http.port":    FilebeatAPIPort,
+		"output":       output,
+	}
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func isFixedInputKey(key string) bool {
+	for _, fixed := range fixedInputKeys {
+		if key == fixed {
+			return true
+		}
+	}
+	return false
+}
+
+// The id of the input of logSource, which is used to query the metrics of the input
+func getInputID(logSource *api.LogSource) string {
+	return logSource.Meta.Name
+}
+
+func getConfigFileName(logSource *api.LogSource) string {
+	return fmt.Sprintf("%s.yml", logSource.Meta.Name)
+}
@@ -0,0 +1,139 @@
+package filebeat
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent/agenttest"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+const testStreamConfig = `
+input:
+  id: ignored
+  exclude_lines: ['^DEBUG']
+  fields:
+    team: {{ .Pod.Labels.team }}
+processors:
+  - drop_fields:
+      fields: [agent]
+output:
+  elasticsearch:
+    hosts: ["es.{{ .Namespace }}:9200"]
+    password: {{ .Secrets.es_password }}
+`
+
+func TestRenderInput(t *testing.T) {
+	logSource := agenttest.NewLogSource(testStreamConfig)
+
+	config, err := renderInput(logSource, nil)
+	if err != nil {
+		t.Fatalf("render input failed, err: %v", err)
+	}
+
+	expected := `- exclude_lines:
+  - ^DEBUG
+  fields:
+    k8s_controller: deployment_test
+    k8s_namespace: test-ns
+    k8s_node_name: node-1
+    k8s_pod_name: test-xxx-yyy
+    k8s_stream: applog
+    team: infra
+  fields_under_root: true
+  id: deployment_test_applog_test-xxx-yyy
+  paths:
+  - /deployment_test_applog/test-ns_test-xxx-yyy/*
+  processors:
+  - drop_fields:
+      fields:
+      - agent
+  type: filestream
+`
+	if config != expected {
+		t.Errorf("rendered input is wrong, is\n%s", config)
+	}
+}
+
+func TestRenderOutput(t *testing.T) {
+	logConfigs := []api.LogConfig{
+		{
+			Name:      "test",
+			Namespace: "test-ns",
+			Kind:      "deployment",
+			Streams: []api.LogStream{
+				{Name: "applog", VolumeMount: "applog", Config: testStreamConfig},
+				{Name: "auditlog", VolumeMount: "auditlog", Config: "processors: []"},
+			},
+			Secrets: map[string]api.SecretKeySelector{
+				"es_password": {Name: "es", Key: "password"},
+			},
+		},
+	}
+	getSecrets := func(namespace string, refs map[string]api.SecretKeySelector) (map[string]string, error) {
+		return map[string]string{"es_password": "secret"}, nil
+	}
+
+	output, rejected, err := renderOutput(logConfigs, getSecrets)
+	if err != nil || len(rejected) != 0 {
+		t.Fatalf("render output failed, rejected: %v, err: %v", rejected, err)
+	}
+	mainConfig, err := renderMainConfig(output)
+	if err != nil {
+		t.Fatalf("render main config failed, err: %v", err)
+	}
+	config := make(map[string]interface{})
+	err = yaml.Unmarshal([]byte(mainConfig), &config)
+	if err != nil {
+		t.Fatalf("main config is invalid, err: %v", err)
+	}
+	es := config["output"].(map[string]interface{})["elasticsearch"].(map[string]interface{})
+	if es["hosts"].([]interface{})[0] != "es.test-ns:9200" || es["password"] != "secret" {
+		t.Errorf("output of main config is wrong, is\n%s", mainConfig)
+	}
+
+	// The LogConfig with a different output is rejected alone, the others are still deployed
+	logConfigs = append(logConfigs,
+		api.LogConfig{
+			Name:      "console",
+			Namespace: "test-ns",
+			Kind:      "deployment",
+			Streams:   []api.LogStream{{Name: "applog", VolumeMount: "applog", Config: "output:\n  console: {}\n"}},
+		},
+		api.LogConfig{
+			Name:      "by-pod",
+			Namespace: "test-ns",
+			Kind:      "deployment",
+			Streams:   []api.LogStream{{Name: "applog", VolumeMount: "applog", Config: "output:\n  elasticsearch:\n    index: \"{{ .Pod.Labels.app }}\"\n"}},
+		},
+	)
+	output, rejected, err = renderOutput(logConfigs, getSecrets)
+	if err != nil || !reflect.DeepEqual(output["elasticsearch"], config["output"].(map[string]interface{})["elasticsearch"]) {
+		t.Errorf("output of the first LogConfig should be kept, output: %v, err: %v", output, err)
+	}
+	if err := rejected["test-ns/deployment_console_applog"]; err == nil || !strings.Contains(err.Error(), "different outputs") {
+		t.Errorf("expect different outputs rejected, got %v", err)
+	}
+	if err := rejected["test-ns/deployment_by-pod_applog"]; err == nil || !strings.Contains(err.Error(), "references the pod") {
+		t.Errorf("expect output referencing the pod rejected, got %v", err)
+	}
+	if len(rejected) != 2 {
+		t.Errorf("only 2 log streams should be rejected, rejected: %v", rejected)
+	}
+
+	f := &FilebeatAgentManagerImpl{Rejected: rejected}
+	logSource := &api.LogSource{Spec: api.LogSourceSpec{Namespace: "test-ns", ControllerName: "deployment_console", Stream: "applog"}}
+	if _, err := f.renderInput(logSource, nil); err == nil {
+		t.Errorf("expect input of the rejected log stream not rendered")
+	}
+}
+
+func TestParseStreamConfigUnknownKey(t *testing.T) {
+	_, err := parseStreamConfig("inputs: {}")
+	if err == nil {
+		t.Errorf("expect unknown key rejected")
+	}
+}
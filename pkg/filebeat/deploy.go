@@ -0,0 +1,100 @@
+package filebeat
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+const (
+	// The dir where the conf pvc is mounted, every agent reloads the input files in the dir named by its pod name
+	FilebeatConfVolumeMountPath = "/filebeat"
+
+	// The dir where the main config of filebeat is mounted from configmap
+	FilebeatMainConfDir  = "/etc/filebeat"
+	FilebeatMainConfFile = "filebeat.yml"
+
+	// The port of the http endpoint of filebeat
+	FilebeatAPIPort = 5066
+
+	DefaultFilebeatImage = "docker.elastic.co/beats/filebeat:8.11.3"
+)
+
+// Create the conf dir of this agent before starting filebeat, the input files are written by logmanager
+// with its own owner, so the permission check of config files is disabled
+const filebeatCommand = `mkdir -p %s/$POD_NAME && exec filebeat -e --strict.perms=false -c %s/%s`
+
+// Create or update the configmap and deployment of filebeat agents
+func (f *FilebeatAgentManagerImpl) Deploy() error {
+	logger := log.WithFields(log.Fields{
+		"func": "FilebeatAgentManagerImpl.Deploy",
+	})
+
+	ownerReferences, err := agent.GetOwnerReferences(f.Cli, f.Namespace, f.Name)
+	if err != nil {
+		return err
+	}
+
+	output, rejected, err := renderOutput(f.LogConfigs, func(namespace string, refs map[string]api.SecretKeySelector) (map[string]string, error) {
+		if f.Secrets == nil {
+			return nil, fmt.Errorf("no secret store to resolve the secrets of namespace %s", namespace)
+		}
+		return f.Secrets.Get(namespace, refs)
+	})
+	for key, err := range rejected {
+		logger.Errorf("Log stream %s is rejected by filebeat, err: %v", key, err)
+	}
+	f.Rejected = rejected
+	if err != nil {
+		return err
+	}
+	mainConfig, err := renderMainConfig(output)
+	if err != nil {
+		return err
+	}
+
+	// The output may hold secrets, so the main config is kept in a secret instead of a configmap
+	mainConfigSecret := &v1.Secret{
+		ObjectMeta: f.NewObjectMeta(ownerReferences),
+		StringData: map[string]string{
+			FilebeatMainConfFile: mainConfig,
+		},
+	}
+	err = agent.ApplySecret(f.Cli, mainConfigSecret)
+	if err != nil {
+		return err
+	}
+
+	container := v1.Container{
+		Name:    "filebeat",
+		Image:   f.GetImage(DefaultFilebeatImage),
+		Command: []string{"/bin/sh", "-c", fmt.Sprintf(filebeatCommand, FilebeatConfVolumeMountPath, FilebeatMainConfDir, FilebeatMainConfFile)},
+		Ports: []v1.ContainerPort{
+			{
+				Name:          "http",
+				ContainerPort: FilebeatAPIPort,
+			},
+		},
+		VolumeMounts: []v1.VolumeMount{
+			{
+				Name:      "filebeat-main-conf",
+				MountPath: FilebeatMainConfDir,
+			},
+		},
+	}
+	volumes := []v1.Volume{
+		{
+			Name: "filebeat-main-conf",
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{
+					SecretName: f.DeployName,
+				},
+			},
+		},
+	}
+	return agent.ApplyWorkload(f.Cli, f.NewDeployment(ownerReferences, container, volumes), f.DeployConfig, f.LogConfigs)
+}
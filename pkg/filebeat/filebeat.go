@@ -0,0 +1,99 @@
+package filebeat
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+const (
+	// The api of filebeat which returns the metrics of the running inputs
+	FilebeatInputsPath = "/inputs/"
+
+	// The default timeout of the requests to filebeat
+	DefaultFilebeatAPITimeout = 5 * time.Second
+)
+
+// FilebeatAgentManagerImpl manages filebeat agents, the input of every logSource is a file in the conf dir
+// of its agent, which is reloaded by filebeat periodically.
+type FilebeatAgentManagerImpl struct {
+	*agent.FileAgentManager
+
+	// The client of the http endpoint of filebeat
+	Client *http.Client
+
+	// The log streams whose LogConfig is rejected by Deploy, keyed by getStreamKey, their inputs are never rendered
+	Rejected map[string]error
+}
+
+func init() {
+	agent.Register(agent.Backend{
+		Type: agent.Filebeat,
+		New:  NewFilebeatAgentManager,
+		Schema: agent.ConfigSchema{
+			Format:      api.FormatYAML,
+			Description: "delivers configs by input files of filebeat",
+		},
+		Compile: compileSpec,
+	})
+}
+
+func NewFilebeatAgentManager(cfg *agent.AgentManagerConfig) agent.AgentManager {
+	timeout := cfg.APIConfig.Timeout
+	if timeout <= 0 {
+		timeout = DefaultFilebeatAPITimeout
+	}
+	f := &FilebeatAgentManagerImpl{
+		FileAgentManager: agent.NewFileAgentManager(agent.Filebeat, cfg, FilebeatConfVolumeMountPath, FilebeatAPIPort),
+		Client:           &http.Client{Timeout: timeout},
+	}
+	f.Render = f.renderInput
+	f.FileName = getConfigFileName
+	return f
+}
+
+// Check whether filebeat agentName stops the input of logSource, which happens after the next reload
+func (f *FilebeatAgentManagerImpl) RunnerStopped(logSource *api.LogSource, agentName string) (bool, error) {
+	addr, err := f.AgentAddr(agentName)
+	if err != nil {
+		return false, err
+	}
+	if addr == "" {
+		return true, nil
+	}
+
+	resp, err := f.Client.Get(fmt.Sprintf("http://%s%s", addr, FilebeatInputsPath))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("get inputs from filebeat %s failed, status code: %d", agentName, resp.StatusCode)
+	}
+
+	inputs := make([]struct {
+		ID string `json:"id"`
+	}, 0)
+	err = json.NewDecoder(resp.Body).Decode(&inputs)
+	if err != nil {
+		return false, err
+	}
+	for _, input := range inputs {
+		if input.ID == getInputID(logSource) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Render the input file of logSource, unless the output of its LogConfig is rejected
+func (f *FilebeatAgentManagerImpl) renderInput(logSource *api.LogSource, secrets map[string]string) (string, error) {
+	if err, exist := f.Rejected[getStreamKey(logSource.Spec.Namespace, logSource.Spec.ControllerName, logSource.Spec.Stream)]; exist {
+		return "", fmt.Errorf("the output of log stream is rejected, err: %v", err)
+	}
+	return renderInput(logSource, secrets)
+}
@@ -0,0 +1,105 @@
+package fluentbit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+)
+
+const (
+	// The apis of the http server of fluent bit
+	FluentbitReloadPath  = "/api/v2/reload"
+	FluentbitMetricsPath = "/api/v1/metrics"
+
+	// The default timeout of the requests to fluent bit
+	DefaultFluentbitAPITimeout = 5 * time.Second
+)
+
+// The metrics of the inputs and outputs of fluent bit, keyed by alias
+type Metrics struct {
+	Input  map[string]InputMetrics  `json:"input"`
+	Output map[string]OutputMetrics `json:"output"`
+}
+
+type InputMetrics struct {
+	Records int64 `json:"records"`
+	Bytes   int64 `json:"bytes"`
+}
+
+type OutputMetrics struct {
+	ProcRecords    int64 `json:"proc_records"`
+	ProcBytes      int64 `json:"proc_bytes"`
+	Errors         int64 `json:"errors"`
+	Retries        int64 `json:"retries"`
+	RetriesFailed  int64 `json:"retries_failed"`
+	DroppedRecords int64 `json:"dropped_records"`
+}
+
+// Client calls the http server of fluent bit agents, the address of agent is given in every call
+type Client struct {
+	http     *http.Client
+	username string
+	password string
+}
+
+func NewClient(cfg agent.APIConfig) *Client {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultFluentbitAPITimeout
+	}
+	return &Client{
+		http:     &http.Client{Timeout: timeout},
+		username: cfg.Username,
+		password: cfg.Password,
+	}
+}
+
+// Reload the config files of fluent bit, which requires Hot_Reload to be enabled
+func (c *Client) Reload(addr string) error {
+	_, err := c.do(http.MethodPost, addr, FluentbitReloadPath)
+	return err
+}
+
+// Get the metrics of all the inputs and outputs
+func (c *Client) Metrics(addr string) (*Metrics, error) {
+	data, err := c.do(http.MethodGet, addr, FluentbitMetricsPath)
+	if err != nil {
+		return nil, err
+	}
+	metrics := &Metrics{}
+	err = json.Unmarshal(data, metrics)
+	if err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+func (c *Client) do(method, addr, path string) ([]byte, error) {
+	req, err := http.NewRequest(method, fmt.Sprintf("http://%s%s", addr, path), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("fluent bit api %s returns status code %d, message: %s", path, resp.StatusCode, bytes.TrimSpace(data))
+	}
+	return data, nil
+}
@@ -0,0 +1,129 @@
+package fluentbit
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+// The parsers file shipped in the image of fluent bit, which defines the json parser
+const FluentbitParsersFile = "/fluent-bit/etc/parsers.conf"
+
+// Compile the collect spec into the sections of the config of logSource. The paths are taken by renderConfig,
+// the multiline and regex parsers of fluent bit are defined only in parsers files, so they are not supported.
+func compileSpec(spec *api.CollectSpec) (string, error) {
+	if spec.Multiline != nil {
+		return "", fmt.Errorf("multiline is not supported by fluentbit")
+	}
+
+	sections := make([]Section, 0)
+
+	input := Section{Name: "INPUT"}
+	switch spec.ReadFrom {
+	case api.ReadFromOldest:
+		input.Set("Read_from_Head", "On")
+	case api.ReadFromNewest:
+		input.Set("Read_from_Head", "Off")
+	}
+	if len(input.Entries) != 0 {
+		sections = append(sections, input)
+	}
+
+	if spec.Parser != nil {
+		switch spec.Parser.Type {
+		case api.ParserRaw:
+		case api.ParserJSON:
+			parser := Section{Name: "FILTER"}
+			parser.Set("Name", "parser")
+			parser.Set("Key_Name", "log")
+			parser.Set("Parser", "json")
+			parser.Set("Reserve_Data", "On")
+			sections = append(sections, parser)
+		default:
+			return "", fmt.Errorf("parser %s is not supported by fluentbit", spec.Parser.Type)
+		}
+	}
+
+	if len(spec.Fields) != 0 {
+		keys := make([]string, 0, len(spec.Fields))
+		for k := range spec.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		fields := Section{Name: "FILTER"}
+		fields.Set("Name", "record_modifier")
+		for _, k := range keys {
+			if strings.ContainsAny(k, " \t") {
+				return "", fmt.Errorf("field %s of fluentbit should have no spaces", k)
+			}
+			fields.Entries = append(fields.Entries, Entry{Key: "Record", Value: fmt.Sprintf("%s %s", k, spec.Fields[k])})
+		}
+		sections = append(sections, fields)
+	}
+
+	dest := spec.Destination
+	output := Section{Name: "OUTPUT"}
+	var target string
+	switch dest.Type {
+	case api.DestinationHTTP:
+		output.Set("Name", "http")
+		output.Set("Format", "json")
+		target = dest.URL
+	case api.DestinationElasticsearch:
+		if len(dest.Hosts) != 1 {
+			return "", fmt.Errorf("elasticsearch destination of fluentbit supports only one host, has %d", len(dest.Hosts))
+		}
+		output.Set("Name", "es")
+		output.Set("Index", dest.Index)
+		output.Set("Suppress_Type_Name", "On")
+		target = dest.Hosts[0]
+	default:
+		return "", fmt.Errorf("destination %s is not supported by fluentbit", dest.Type)
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("invalid url of destination, err: %v", err)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("url %s of destination has no host", target)
+	}
+	output.Set("Host", u.Hostname())
+	port := u.Port()
+	switch {
+	case port != "":
+	case u.Scheme == "https":
+		port = "443"
+	case dest.Type == api.DestinationElasticsearch:
+		port = "9200"
+	default:
+		port = "80"
+	}
+	output.Set("Port", port)
+	if u.Scheme == "https" {
+		output.Set("tls", "On")
+	}
+	if dest.Type == api.DestinationHTTP && u.RequestURI() != "/" {
+		output.Set("URI", u.RequestURI())
+	}
+	if dest.Username != "" {
+		output.Set("HTTP_User", dest.Username)
+		output.Set("HTTP_Passwd", dest.Password)
+	}
+
+	headers := make([]string, 0, len(dest.Headers))
+	for k := range dest.Headers {
+		headers = append(headers, k)
+	}
+	sort.Strings(headers)
+	for _, k := range headers {
+		output.Entries = append(output.Entries, Entry{Key: "Header", Value: fmt.Sprintf("%s %s", k, dest.Headers[k])})
+	}
+	sections = append(sections, output)
+
+	return renderSections(sections), nil
+}
@@ -0,0 +1,230 @@
+package fluentbit
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+const (
+	// The prefix of the tags of the records of logSources
+	TagPrefix = "kirklog"
+
+	// The key of the record which holds the path of the log file, the same as the datasource_tag of logkit
+	LogSourceKey = "log_source"
+
+	// The file in the log meta dir which holds the offsets of the tail input
+	DBFileName = "fluentbit.db"
+)
+
+// The keys of the tail input which are decided by kirklog, and can not be overridden by the config of logSource
+var fixedInputKeys = []string{"Name", "Alias", "Tag", "Path", "Path_Key", "DB"}
+
+// Entry is one "key value" line of a section
+type Entry struct {
+	Key   string
+	Value string
+}
+
+// Section is one section of the classic config format of fluent bit, the entries keep their order
+type Section struct {
+	Name    string
+	Entries []Entry
+}
+
+// Return the value of key, the keys of fluent bit are case insensitive
+func (s *Section) Get(key string) string {
+	for _, entry := range s.Entries {
+		if strings.EqualFold(entry.Key, key) {
+			return entry.Value
+		}
+	}
+	return ""
+}
+
+// Set the value of key, the existing entries of key are replaced
+func (s *Section) Set(key, value string) {
+	s.Del(key)
+	s.Entries = append(s.Entries, Entry{Key: key, Value: value})
+}
+
+// Delete all the entries of key
+func (s *Section) Del(key string) {
+	entries := make([]Entry, 0, len(s.Entries))
+	for _, entry := range s.Entries {
+		if !strings.EqualFold(entry.Key, key) {
+			entries = append(entries, entry)
+		}
+	}
+	s.Entries = entries
+}
+
+// Parse the sections of the classic config format, the directives like @INCLUDE are not supported
+func parseSections(text string) ([]Section, error) {
+	sections := make([]Section, 0)
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "@") {
+			return nil, fmt.Errorf("line %d: directive %s is not supported", n, line)
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: invalid section %s", n, line)
+			}
+			sections = append(sections, Section{
+				Name:    strings.ToUpper(strings.TrimSpace(line[1 : len(line)-1])),
+				Entries: make([]Entry, 0),
+			})
+			continue
+		}
+		if len(sections) == 0 {
+			return nil, fmt.Errorf("line %d: entry %s is not in any section", n, line)
+		}
+
+		fields := strings.Fields(line)
+		value := ""
+		if len(fields) > 1 {
+			value = strings.TrimSpace(line[len(fields[0]):])
+		}
+		section := &sections[len(sections)-1]
+		section.Entries = append(section.Entries, Entry{Key: fields[0], Value: value})
+	}
+
+	return sections, scanner.Err()
+}
+
+// Render the sections in the classic config format
+func renderSections(sections []Section) string {
+	buf := &bytes.Buffer{}
+	for i, section := range sections {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(buf, "[%s]\n", section.Name)
+
+		width := 0
+		for _, entry := range section.Entries {
+			if len(entry.Key) > width {
+				width = len(entry.Key)
+			}
+		}
+		for _, entry := range section.Entries {
+			fmt.Fprintf(buf, "    %-*s %s\n", width, entry.Key, entry.Value)
+		}
+	}
+	return buf.String()
+}
+
+// Render the include file of logSource. The config of logSource holds the FILTER and OUTPUT sections, and optionally
+// an INPUT section whose entries are merged into the tail input of the log dir. All the sections are bound to
+// the tag of logSource, and the kubernetes metadata is added like the k8sdir transform of logkit.
+func renderConfig(logSource *api.LogSource, secrets map[string]string) (string, error) {
+	configRaw, err := agent.RenderConfig(logSource, secrets, api.FormatFluentbit, compileSpec)
+	if err != nil {
+		return "", err
+	}
+	sections, err := parseSections(configRaw)
+	if err != nil {
+		return "", err
+	}
+
+	alias := getAlias(logSource)
+	tag := getTag(logSource)
+
+	input := Section{Name: "INPUT"}
+	input.Set("Name", "tail")
+	input.Set("Alias", alias)
+	input.Set("Tag", tag)
+	input.Set("Path", strings.Join(logSource.GetLogPaths(), ","))
+	input.Set("Path_Key", LogSourceKey)
+	input.Set("DB", fmt.Sprintf("%s/%s", logSource.GetLogMetaDir(), DBFileName))
+
+	metadata := Section{Name: "FILTER"}
+	metadata.Set("Name", "record_modifier")
+	metadata.Set("Alias", fmt.Sprintf("%s.k8s", alias))
+	metadata.Set("Match", tag)
+	for _, record := range []Entry{
+		{"k8s_namespace", logSource.Spec.Namespace},
+		{"k8s_pod_name", logSource.Spec.PodName},
+		{"k8s_node_name", logSource.Spec.NodeName},
+		{"k8s_controller", logSource.Spec.ControllerName},
+		{"k8s_stream", logSource.Spec.Stream},
+		{"k8s_container", logSource.Spec.Container},
+	} {
+		if record.Value != "" {
+			metadata.Entries = append(metadata.Entries, Entry{Key: "Record", Value: fmt.Sprintf("%s %s", record.Key, record.Value)})
+		}
+	}
+
+	filters := []Section{metadata}
+	outputs := make([]Section, 0)
+	for _, section := range sections {
+		switch section.Name {
+		case "INPUT":
+			for _, entry := range section.Entries {
+				if !isFixedInputKey(entry.Key) {
+					input.Set(entry.Key, entry.Value)
+				}
+			}
+		case "FILTER":
+			section.Del("Match_Regex")
+			section.Set("Match", tag)
+			filters = append(filters, section)
+		case "OUTPUT":
+			section.Del("Match_Regex")
+			section.Set("Match", tag)
+			section.Set("Alias", fmt.Sprintf("%s.%d", alias, len(outputs)))
+			outputs = append(outputs, section)
+		default:
+			return "", fmt.Errorf("section %s is not supported in the config of log source", section.Name)
+		}
+	}
+	if len(outputs) == 0 {
+		return "", fmt.Errorf("no OUTPUT section in the config of log source")
+	}
+
+	// The lines of container log are parsed by the builtin multiline parser of its runtime, which also
+	// joins the split messages, the message is in the "log" key like the lines of log files
+	if logSource.IsContainer() {
+		if input.Get("Parser") != "" || input.Get("multiline.parser") != "" {
+			return "", fmt.Errorf("the parsers of tail input are not supported for the container log")
+		}
+		input.Set("multiline.parser", logSource.Spec.ContainerLogFormat)
+	}
+
+	result := append([]Section{input}, filters...)
+	result = append(result, outputs...)
+	return renderSections(result), nil
+}
+
+func isFixedInputKey(key string) bool {
+	for _, fixed := range fixedInputKeys {
+		if strings.EqualFold(key, fixed) {
+			return true
+		}
+	}
+	return false
+}
+
+// The alias of the input of logSource, which is also the prefix of the aliases of its filters and outputs
+func getAlias(logSource *api.LogSource) string {
+	return logSource.Meta.Name
+}
+
+func getTag(logSource *api.LogSource) string {
+	return fmt.Sprintf("%s.%s", TagPrefix, logSource.Meta.Name)
+}
+
+func getConfigFileName(logSource *api.LogSource) string {
+	return fmt.Sprintf("%s.conf", logSource.Meta.Name)
+}
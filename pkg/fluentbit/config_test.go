@@ -0,0 +1,149 @@
+package fluentbit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent/agenttest"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+func TestRenderConfig(t *testing.T) {
+	logSource := agenttest.NewLogSource(`
+# The read position is kept from the config of log source
+[INPUT]
+    Read_from_Head true
+    Path           /var/log/*.log
+
+[FILTER]
+    Name  grep
+    Match *
+    Regex level error
+
+[OUTPUT]
+    Name  es
+    Match_Regex .*
+    Host  {{ .Namespace }}.es.svc
+    Port  9200
+`)
+
+	config, err := renderConfig(logSource, nil)
+	if err != nil {
+		t.Fatalf("render config failed, err: %v", err)
+	}
+
+	expected := `[INPUT]
+    Name           tail
+    Alias          deployment_test_applog_test-xxx-yyy
+    Tag            kirklog.deployment_test_applog_test-xxx-yyy
+    Path           /deployment_test_applog/test-ns_test-xxx-yyy/*
+    Path_Key       log_source
+    DB             /deployment_test_applog/test-ns_test-xxx-yyy/.meta/fluentbit.db
+    Read_from_Head true
+
+[FILTER]
+    Name   record_modifier
+    Alias  deployment_test_applog_test-xxx-yyy.k8s
+    Match  kirklog.deployment_test_applog_test-xxx-yyy
+    Record k8s_namespace test-ns
+    Record k8s_pod_name test-xxx-yyy
+    Record k8s_node_name node-1
+    Record k8s_controller deployment_test
+    Record k8s_stream applog
+
+[FILTER]
+    Name  grep
+    Regex level error
+    Match kirklog.deployment_test_applog_test-xxx-yyy
+
+[OUTPUT]
+    Name  es
+    Host  test-ns.es.svc
+    Port  9200
+    Match kirklog.deployment_test_applog_test-xxx-yyy
+    Alias deployment_test_applog_test-xxx-yyy.0
+`
+	if config != expected {
+		t.Errorf("rendered config is wrong, is\n%s", config)
+	}
+}
+
+func TestRenderConfigInvalid(t *testing.T) {
+	agenttest.CheckRenderInvalid(t, renderConfig, map[string]string{
+		"[FILTER]\n    Name grep\n":                           "no OUTPUT section",
+		"[SERVICE]\n    Flush 1\n[OUTPUT]\n    Name stdout\n": "SERVICE is not supported",
+		"@INCLUDE other.conf\n[OUTPUT]\n    Name stdout\n":    "@INCLUDE other.conf is not supported",
+		"Name stdout\n":              "is not in any section",
+		"[OUTPUT\n    Name stdout\n": "invalid section",
+	})
+}
+
+func TestRenderCollectSpec(t *testing.T) {
+	logSource := agenttest.NewLogSource("")
+	logSource.Spec.Collect = &api.CollectSpec{
+		Paths:  []string{"*.log", "*.txt"},
+		Parser: &api.ParserSpec{Type: api.ParserJSON},
+		Destination: api.DestinationSpec{
+			Type:    api.DestinationHTTP,
+			URL:     "https://collector.{{ .Namespace }}/logs?app=test",
+			Headers: map[string]string{"X-Token": "{{ .Secrets.token }}"},
+		},
+	}
+
+	config, err := renderConfig(logSource, map[string]string{"token": "abc"})
+	if err != nil {
+		t.Fatalf("render collect spec failed, err: %v", err)
+	}
+	// The entries are aligned by the widest key of the section, so the whitespace is not compared
+	normalized := strings.Join(strings.Fields(config), " ")
+	for _, entry := range []string{
+		"Path /deployment_test_applog/test-ns_test-xxx-yyy/*.log,/deployment_test_applog/test-ns_test-xxx-yyy/*.txt",
+		"Parser json",
+		"Host collector.test-ns",
+		"Port 443",
+		"URI /logs?app=test",
+		"Header X-Token abc",
+	} {
+		if !strings.Contains(normalized, entry) {
+			t.Errorf("rendered collect spec should have %q, is\n%s", entry, config)
+		}
+	}
+
+	// The multiline is defined only in parsers files of fluent bit
+	logSource.Spec.Collect.Multiline = &api.MultilineSpec{Start: "^\\["}
+	if _, err := renderConfig(logSource, nil); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("expect multiline rejected, got %v", err)
+	}
+}
+
+func TestRenderContainerLog(t *testing.T) {
+	logSource := agenttest.NewLogSource("[OUTPUT]\n    Name stdout\n")
+	logSource.Meta.Name = "deployment_test_stdout_test-xxx-yyy_app"
+	logSource.Spec.Stream = "stdout"
+	logSource.Spec.VolumeMount = ""
+	logSource.Spec.Container = "app"
+	logSource.Spec.ContainerLogFormat = api.ContainerLogCRI
+	logSource.Spec.NodeLogDir = "/var/log/pods/test-ns_test-xxx-yyy_1234/app"
+
+	config, err := renderConfig(logSource, nil)
+	if err != nil {
+		t.Fatalf("render container log failed, err: %v", err)
+	}
+	normalized := strings.Join(strings.Fields(config), " ")
+	for _, entry := range []string{
+		"Path /var/log/pods/test-ns_test-xxx-yyy_1234/app/*.log",
+		"DB /var/log/pods/test-ns_test-xxx-yyy_1234/app/.meta/fluentbit.db",
+		"multiline.parser cri",
+		"Record k8s_container app",
+	} {
+		if !strings.Contains(normalized, entry) {
+			t.Errorf("rendered container log should have %q, is\n%s", entry, config)
+		}
+	}
+
+	// The lines are parsed by the parser of runtime only
+	logSource.Spec.Config = "[INPUT]\n    Parser json\n[OUTPUT]\n    Name stdout\n"
+	if _, err := renderConfig(logSource, nil); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("expect parser of input rejected, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package fluentbit
+
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+)
+
+const (
+	// The dir where the conf pvc is mounted, every agent includes the files in the dir named by its pod name
+	FluentbitConfVolumeMountPath = "/fluentbit"
+
+	// The dir where the main config of fluent bit is mounted from configmap
+	FluentbitMainConfDir  = "/etc/fluentbit"
+	FluentbitMainConfFile = "fluent-bit.conf"
+
+	// The port of the http server of fluent bit
+	FluentbitAPIPort = 2020
+
+	// The debug image has a shell, which is needed to render the main config
+	DefaultFluentbitImage = "fluent/fluent-bit:2.2.2-debug"
+)
+
+// The main config of fluent bit, the http server serves the metrics and the hot reload api
+const fluentbitMainConf = `[SERVICE]
+    Flush        1
+    Log_Level    info
+    HTTP_Server  On
+    HTTP_Listen  0.0.0.0
+    HTTP_Port    %d
+    Hot_Reload   On
+    Parsers_File %s
+
+@INCLUDE %s/${POD_NAME}/*.conf
+`
+
+// Replace the pod name in the main config and create the conf dir of this agent before starting fluent bit
+const fluentbitCommand = `mkdir -p %s/$POD_NAME && sed "s|\${POD_NAME}|$POD_NAME|g" %s/%s > /tmp/fluent-bit.conf && exec /fluent-bit/bin/fluent-bit -c /tmp/fluent-bit.conf`
+
+// Create or update the configmap and deployment of fluent bit agents
+func (f *FluentbitAgentManagerImpl) Deploy() error {
+	ownerReferences, err := agent.GetOwnerReferences(f.Cli, f.Namespace, f.Name)
+	if err != nil {
+		return err
+	}
+
+	configMap := &v1.ConfigMap{
+		ObjectMeta: f.NewObjectMeta(ownerReferences),
+		Data: map[string]string{
+			FluentbitMainConfFile: fmt.Sprintf(fluentbitMainConf, FluentbitAPIPort, FluentbitParsersFile, FluentbitConfVolumeMountPath),
+		},
+	}
+	err = agent.ApplyConfigMap(f.Cli, configMap)
+	if err != nil {
+		return err
+	}
+
+	container := v1.Container{
+		Name:    "fluentbit",
+		Image:   f.GetImage(DefaultFluentbitImage),
+		Command: []string{"/bin/sh", "-c", fmt.Sprintf(fluentbitCommand, FluentbitConfVolumeMountPath, FluentbitMainConfDir, FluentbitMainConfFile)},
+		Ports: []v1.ContainerPort{
+			{
+				Name:          "http",
+				ContainerPort: FluentbitAPIPort,
+			},
+		},
+		VolumeMounts: []v1.VolumeMount{
+			{
+				Name:      "fluentbit-main-conf",
+				MountPath: FluentbitMainConfDir,
+			},
+		},
+	}
+	volumes := []v1.Volume{
+		{
+			Name: "fluentbit-main-conf",
+			VolumeSource: v1.VolumeSource{
+				ConfigMap: &v1.ConfigMapVolumeSource{
+					LocalObjectReference: v1.LocalObjectReference{Name: f.DeployName},
+				},
+			},
+		},
+	}
+	return agent.ApplyWorkload(f.Cli, f.NewDeployment(ownerReferences, container, volumes), f.DeployConfig, f.LogConfigs)
+}
@@ -0,0 +1,111 @@
+package fluentbit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+// FluentbitAgentManagerImpl manages fluent bit agents, the config of every logSource is an include file
+// in the conf dir of its agent, and the agent is hot reloaded after the include files change.
+type FluentbitAgentManagerImpl struct {
+	*agent.FileAgentManager
+
+	// The client of the http server of fluent bit
+	Client *Client
+}
+
+func init() {
+	agent.Register(agent.Backend{
+		Type: agent.Fluentbit,
+		New:  NewFluentbitAgentManager,
+		Schema: agent.ConfigSchema{
+			Format:      api.FormatFluentbit,
+			Description: "delivers configs by include files of fluent bit",
+		},
+		Compile: compileSpec,
+	})
+}
+
+func NewFluentbitAgentManager(cfg *agent.AgentManagerConfig) agent.AgentManager {
+	f := &FluentbitAgentManagerImpl{
+		FileAgentManager: agent.NewFileAgentManager(agent.Fluentbit, cfg, FluentbitConfVolumeMountPath, FluentbitAPIPort),
+		Client:           NewClient(cfg.APIConfig),
+	}
+	f.Render = renderConfig
+	f.FileName = getConfigFileName
+	// The offsets of tail input are kept in the log meta dir
+	f.CreateMetaDir = true
+	f.Reload = f.Client.Reload
+	f.SyncReloadURL = fmt.Sprintf("http://127.0.0.1:%d%s", FluentbitAPIPort, FluentbitReloadPath)
+	return f
+}
+
+// Check whether fluent bit agentName stops the tail input of logSource
+func (f *FluentbitAgentManagerImpl) RunnerStopped(logSource *api.LogSource, agentName string) (bool, error) {
+	addr, err := f.AgentAddr(agentName)
+	if err != nil {
+		return false, err
+	}
+	if addr == "" {
+		return true, nil
+	}
+
+	metrics, err := f.Client.Metrics(addr)
+	if err != nil {
+		return false, err
+	}
+	_, exist := metrics.Input[getAlias(logSource)]
+	return !exist, nil
+}
+
+// Collect the status of logSources from the metrics of their inputs and outputs in fluent bit agentName
+func (f *FluentbitAgentManagerImpl) CollectStatus(agentName string, logSources []*api.LogSource) (map[string]api.RunnerStatus, error) {
+	addr, err := f.AgentAddr(agentName)
+	if err != nil {
+		return nil, err
+	}
+	if addr == "" {
+		return nil, fmt.Errorf("log agent %s is not running", agentName)
+	}
+
+	metrics, err := f.Client.Metrics(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := make(map[string]api.RunnerStatus)
+	for _, logSource := range logSources {
+		alias := getAlias(logSource)
+		input, exist := metrics.Input[alias]
+		if !exist {
+			continue
+		}
+		status := api.RunnerStatus{
+			Agent:       agentName,
+			ReadLines:   input.Records,
+			ReadBytes:   input.Bytes,
+			CollectedAt: now,
+		}
+
+		// A record is sent when all the outputs succeed, so the least processed records of outputs is counted
+		for i := 0; ; i++ {
+			output, exist := metrics.Output[fmt.Sprintf("%s.%d", alias, i)]
+			if !exist {
+				break
+			}
+			if i == 0 || output.ProcRecords < status.SentLines {
+				status.SentLines = output.ProcRecords
+			}
+			status.SendErrors += output.Errors + output.RetriesFailed
+			if output.DroppedRecords > 0 {
+				status.LastError = fmt.Sprintf("output %d dropped %d records", i, output.DroppedRecords)
+			}
+		}
+		result[logSource.Meta.Name] = status
+	}
+	return result, nil
+}
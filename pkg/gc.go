@@ -0,0 +1,130 @@
+package logmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+)
+
+type GCMode string
+
+const (
+	// Remove the orphaned config files
+	GCRemove GCMode = "remove"
+	// Move the orphaned config files into the quarantine dir under the conf dir of the agent
+	GCQuarantine GCMode = "quarantine"
+
+	GCQuarantineDir = ".quarantine"
+)
+
+// The garbage found in the conf dirs of log agents
+type garbage struct {
+	// The config files which are not owned by any logSource
+	Orphans []string
+
+	// The config files which are configured on more than one agent, the key is the file name
+	Duplicates map[string][]string
+}
+
+// Remove or quarantine the config files which are not owned by any logSource, this happens when logmanager
+// crashes between deleting and adding the config of a moving logSource, or the conf dir is edited by hand.
+func (lm *LogManager) collectGarbage() {
+	logger := log.WithFields(log.Fields{
+		"func": "collectGarbage",
+	})
+
+	g, err := findGarbage(lm.LogAgents, lm.Match, lm.GCGracePeriod, time.Now())
+	if err != nil {
+		logger.Errorf("Find garbage config files failed, err: %v", err)
+		return
+	}
+
+	for name, paths := range g.Duplicates {
+		logger.Warnf("Config %s is configured on %d agents: %s", name, len(paths), strings.Join(paths, ", "))
+	}
+
+	for _, path := range g.Orphans {
+		switch lm.GCMode {
+		case GCRemove:
+			err = os.Remove(path)
+		default:
+			err = quarantineConfigFile(path)
+		}
+		if err != nil {
+			logger.Errorf("Collect orphaned config file %s failed, mode: %s, err: %v", path, lm.GCMode, err)
+			continue
+		}
+		logger.Infof("Collect orphaned config file %s succeeded, mode: %s", path, lm.GCMode)
+	}
+}
+
+// Compare the config files under the conf dir of every agent with the match relation.
+// The files modified within the grace period are skipped, since they may be written by a running sync.
+func findGarbage(logAgentsMap map[string]*agent.Agent, match map[string]*Match, grace time.Duration, now time.Time) (*garbage, error) {
+	owned := make(map[string]bool)
+	for _, m := range match {
+		if m.ConfPath != "" {
+			owned[filepath.Clean(m.ConfPath)] = true
+		}
+	}
+
+	g := &garbage{
+		Orphans:    make([]string, 0),
+		Duplicates: make(map[string][]string),
+	}
+	located := make(map[string][]string)
+
+	for _, a := range logAgentsMap {
+		if a.ConfPath == "" {
+			continue
+		}
+		files, err := ioutil.ReadDir(a.ConfPath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			// The hidden files are the temp files and the quarantine dir
+			if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+				continue
+			}
+			path := filepath.Join(a.ConfPath, file.Name())
+			located[file.Name()] = append(located[file.Name()], path)
+
+			if !owned[path] && now.Sub(file.ModTime()) > grace {
+				g.Orphans = append(g.Orphans, path)
+			}
+		}
+	}
+
+	for name, paths := range located {
+		if len(paths) > 1 {
+			sort.Strings(paths)
+			g.Duplicates[name] = paths
+		}
+	}
+	sort.Strings(g.Orphans)
+
+	return g, nil
+}
+
+func quarantineConfigFile(path string) error {
+	dir, name := filepath.Split(path)
+	quarantineDir := filepath.Join(dir, GCQuarantineDir)
+
+	err := os.MkdirAll(quarantineDir, 0755)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path, filepath.Join(quarantineDir, name))
+}
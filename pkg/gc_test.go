@@ -0,0 +1,75 @@
+package logmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+)
+
+func TestFindGarbage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kirklog-gc")
+	if err != nil {
+		t.Fatalf("create temp dir failed, err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	agents := map[string]*agent.Agent{
+		"logkit-a": {Name: "logkit-a", ConfPath: filepath.Join(dir, "logkit-a")},
+		"logkit-b": {Name: "logkit-b", ConfPath: filepath.Join(dir, "logkit-b")},
+	}
+	files := []string{
+		"logkit-a/applog_pod-1.conf",
+		"logkit-a/applog_pod-2.conf",
+		"logkit-a/.applog_pod-3.conf.123.tmp",
+		"logkit-b/applog_pod-1.conf",
+		"logkit-b/applog_pod-4.conf",
+	}
+	old := time.Now().Add(-time.Hour)
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte("{}"), 0644)
+		os.Chtimes(path, old, old)
+	}
+	// A newly written file should be skipped by the grace period
+	ioutil.WriteFile(filepath.Join(dir, "logkit-b/applog_pod-5.conf"), []byte("{}"), 0644)
+
+	match := map[string]*Match{
+		"deployment_test_applog_pod-1": {PodName: "pod-1", AgentName: "logkit-a", ConfPath: filepath.Join(dir, "logkit-a/applog_pod-1.conf")},
+		"deployment_test_applog_pod-2": {PodName: "pod-2", AgentName: "logkit-a", ConfPath: filepath.Join(dir, "logkit-a/applog_pod-2.conf")},
+	}
+
+	g, err := findGarbage(agents, match, time.Minute, time.Now())
+	if err != nil {
+		t.Fatalf("find garbage failed, err: %v", err)
+	}
+
+	expected := []string{
+		filepath.Join(dir, "logkit-b/applog_pod-1.conf"),
+		filepath.Join(dir, "logkit-b/applog_pod-4.conf"),
+	}
+	if len(g.Orphans) != len(expected) {
+		t.Fatalf("orphans are wrong, are %v", g.Orphans)
+	}
+	for i := range expected {
+		if g.Orphans[i] != expected[i] {
+			t.Errorf("orphan %d is wrong, is %s", i, g.Orphans[i])
+		}
+	}
+
+	if len(g.Duplicates) != 1 || len(g.Duplicates["applog_pod-1.conf"]) != 2 {
+		t.Errorf("duplicates are wrong, are %v", g.Duplicates)
+	}
+
+	err = quarantineConfigFile(g.Orphans[1])
+	if err != nil {
+		t.Fatalf("quarantine config file failed, err: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "logkit-b", GCQuarantineDir, "applog_pod-4.conf")); err != nil {
+		t.Errorf("config file should be moved into quarantine dir, err: %v", err)
+	}
+}
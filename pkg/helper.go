@@ -2,13 +2,18 @@ package logmanager
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/fatsheep9146/kirklog/pkg/agent"
 	"github.com/fatsheep9146/kirklog/pkg/api"
 )
 
 func logConfigKeyFunc(cfg *api.LogConfig) string {
-	return fmt.Sprintf("%s_%s_%s", cfg.Kind, cfg.Name, cfg.VolumeMount)
+	streams := make([]string, 0)
+	for _, stream := range cfg.GetStreams() {
+		streams = append(streams, stream.Name)
+	}
+	return fmt.Sprintf("%s_%s_%s", cfg.Kind, cfg.Name, strings.Join(streams, "_"))
 }
 
 func logConfigConvertFromSliceToMap(cfgs []api.LogConfig) map[string]*api.LogConfig {
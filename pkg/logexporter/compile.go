@@ -0,0 +1,50 @@
+package logexporter
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+// Compile the collect spec into the config of log stream of logexporter, the paths are taken by RenderConfig.
+// logexporter ships every line as it is, so only the raw parser is supported.
+func CompileSpec(spec *api.CollectSpec) (string, error) {
+	if spec.Multiline != nil {
+		return "", fmt.Errorf("multiline is not supported by logexporter")
+	}
+	if spec.Parser != nil && spec.Parser.Type != api.ParserRaw {
+		return "", fmt.Errorf("parser %s is not supported by logexporter", spec.Parser.Type)
+	}
+
+	dest := spec.Destination
+	if dest.Type != api.DestinationHTTP {
+		return "", fmt.Errorf("destination %s is not supported by logexporter", dest.Type)
+	}
+	headers := make(map[string]string)
+	for k, v := range dest.Headers {
+		headers[k] = v
+	}
+	if dest.Username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(dest.Username + ":" + dest.Password))
+		headers["Authorization"] = "Basic " + auth
+	}
+
+	config := StreamConfig{
+		ReadFrom: spec.ReadFrom,
+		Fields:   spec.Fields,
+		Sink: SinkConfig{
+			URL: dest.URL,
+		},
+	}
+	if len(headers) != 0 {
+		config.Sink.Headers = headers
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
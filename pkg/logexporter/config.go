@@ -0,0 +1,211 @@
+package logexporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+const (
+	// The key of the record which holds the path of the log file, the same as the datasource_tag of logkit
+	LogSourceKey = "log_source"
+
+	// The key of the record which holds the log line
+	MessageKey = "message"
+
+	ReadFromOldest = "oldest"
+	ReadFromNewest = "newest"
+
+	DefaultBatchLines    = 500
+	DefaultBatchBytes    = 2 * 1024 * 1024
+	DefaultFlushInterval = 5 * time.Second
+	DefaultSinkTimeout   = 10 * time.Second
+)
+
+// The config of log stream for logexporter, which is written in json. For example:
+//
+//	{
+//	  "read_from": "oldest",
+//	  "fields": {"team": "{{ .Pod.Labels.team }}"},
+//	  "sink": {
+//	    "url": "http://collector.{{ .Namespace }}:8080/logs",
+//	    "headers": {"Authorization": "Bearer {{ .Secrets.token }}"},
+//	    "batch_lines": 1000
+//	  }
+//	}
+type StreamConfig struct {
+	// Where to start for the files seen for the first time, "oldest" or "newest"
+	ReadFrom string `json:"read_from,omitempty"`
+
+	// The fields added to every record
+	Fields map[string]string `json:"fields,omitempty"`
+
+	Sink SinkConfig `json:"sink"`
+}
+
+// The sink which receives the records, either an http endpoint which receives them in a json array,
+// or a local file which they are appended to as json lines
+type SinkConfig struct {
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// The file appended to, which is used instead of the url
+	Path string `json:"path,omitempty"`
+
+	// A batch is sent when it has so many lines or bytes, or it is older than the flush interval
+	BatchLines    int    `json:"batch_lines,omitempty"`
+	BatchBytes    int    `json:"batch_bytes,omitempty"`
+	FlushInterval string `json:"flush_interval,omitempty"`
+
+	// The timeout of every request
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// The config of one runner of logexporter, which collects the log dir of one logSource
+type RunnerConfig struct {
+	Name    string `json:"name"`
+	LogDir  string `json:"log_dir"`
+	MetaDir string `json:"meta_dir"`
+
+	// The glob patterns of the files in the log dir which are collected, default is all the files
+	Patterns []string `json:"patterns,omitempty"`
+
+	// The format of the lines of container log, "cri" or "docker", "" means the lines are the messages
+	ContainerLogFormat string `json:"container_log_format,omitempty"`
+
+	StreamConfig
+}
+
+// Render the runner config of logSource, with the pod metadata added to the fields
+func RenderConfig(logSource *api.LogSource, secrets map[string]string) (string, error) {
+	configRaw, err := agent.RenderConfig(logSource, secrets, api.FormatJSON, CompileSpec)
+	if err != nil {
+		return "", err
+	}
+
+	stream := StreamConfig{}
+	decoder := json.NewDecoder(strings.NewReader(configRaw))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&stream)
+	if err != nil {
+		return "", fmt.Errorf("parse the config of log stream failed, err: %v", err)
+	}
+
+	fields := make(map[string]string)
+	for k, v := range stream.Fields {
+		fields[k] = v
+	}
+	for k, v := range map[string]string{
+		"k8s_namespace":  logSource.Spec.Namespace,
+		"k8s_pod_name":   logSource.Spec.PodName,
+		"k8s_node_name":  logSource.Spec.NodeName,
+		"k8s_controller": logSource.Spec.ControllerName,
+		"k8s_stream":     logSource.Spec.Stream,
+		"k8s_container":  logSource.Spec.Container,
+	} {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+	stream.Fields = fields
+
+	config := &RunnerConfig{
+		Name:         logSource.Meta.Name,
+		LogDir:       logSource.GetLogDir(),
+		MetaDir:      logSource.GetLogMetaDir(),
+		Patterns:     logSource.GetFilePatterns(),
+		StreamConfig: stream,
+
+		ContainerLogFormat: logSource.Spec.ContainerLogFormat,
+	}
+	err = config.Validate()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Parse the runner config written by the manager
+func ParseRunnerConfig(data []byte) (*RunnerConfig, error) {
+	config := &RunnerConfig{}
+	err := json.Unmarshal(data, config)
+	if err != nil {
+		return nil, err
+	}
+	return config, config.Validate()
+}
+
+func (c *RunnerConfig) Validate() error {
+	if c.Name == "" || c.LogDir == "" || c.MetaDir == "" {
+		return fmt.Errorf("name, log_dir and meta_dir of runner config are required")
+	}
+	switch c.ReadFrom {
+	case "", ReadFromOldest, ReadFromNewest:
+	default:
+		return fmt.Errorf("read_from %s is invalid, should be %s or %s", c.ReadFrom, ReadFromOldest, ReadFromNewest)
+	}
+	switch c.ContainerLogFormat {
+	case "", api.ContainerLogCRI, api.ContainerLogDocker:
+	default:
+		return fmt.Errorf("container_log_format %s is invalid, should be %s or %s", c.ContainerLogFormat, api.ContainerLogCRI, api.ContainerLogDocker)
+	}
+	for _, pattern := range c.Patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("pattern %s is invalid, err: %v", pattern, err)
+		}
+	}
+	if (c.Sink.URL == "") == (c.Sink.Path == "") {
+		return fmt.Errorf("one of url and path of sink is required")
+	}
+	for _, d := range []string{c.Sink.FlushInterval, c.Sink.Timeout} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d); err != nil {
+			return fmt.Errorf("duration %s of sink is invalid, err: %v", d, err)
+		}
+	}
+	return nil
+}
+
+func (c *SinkConfig) getBatchLines() int {
+	if c.BatchLines > 0 {
+		return c.BatchLines
+	}
+	return DefaultBatchLines
+}
+
+func (c *SinkConfig) getBatchBytes() int {
+	if c.BatchBytes > 0 {
+		return c.BatchBytes
+	}
+	return DefaultBatchBytes
+}
+
+func (c *SinkConfig) getFlushInterval() time.Duration {
+	if d, err := time.ParseDuration(c.FlushInterval); err == nil && d > 0 {
+		return d
+	}
+	return DefaultFlushInterval
+}
+
+func (c *SinkConfig) getTimeout() time.Duration {
+	if d, err := time.ParseDuration(c.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return DefaultSinkTimeout
+}
+
+func getConfigFileName(logSource *api.LogSource) string {
+	return fmt.Sprintf("%s.json", logSource.Meta.Name)
+}
@@ -0,0 +1,60 @@
+package logexporter
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+// The key of the record which holds the stream of container log, "stdout" or "stderr"
+const StreamKey = "stream"
+
+// One line of container log written by the runtime
+type containerLine struct {
+	Stream  string
+	Message []byte
+
+	// The message is split by the runtime, the rest of it is in the next lines
+	Partial bool
+}
+
+type dockerLine struct {
+	Log    string `json:"log"`
+	Stream string `json:"stream"`
+}
+
+// Parse one line of container log in the format, the line which can not be parsed is kept as the message
+func parseContainerLine(format string, line []byte) containerLine {
+	line = bytes.TrimRight(line, "\r\n")
+
+	switch format {
+	case api.ContainerLogDocker:
+		entry := dockerLine{}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			break
+		}
+		message := []byte(entry.Log)
+		partial := len(message) != 0 && !bytes.HasSuffix(message, []byte("\n"))
+		return containerLine{
+			Stream:  entry.Stream,
+			Message: bytes.TrimRight(message, "\r\n"),
+			Partial: partial,
+		}
+	case api.ContainerLogCRI:
+		// "<time> <stream> <tags> <message>", the first tag is "P" for the partial line and "F" for the full one
+		fields := bytes.SplitN(line, []byte(" "), 4)
+		if len(fields) < 3 {
+			break
+		}
+		entry := containerLine{
+			Stream:  string(fields[1]),
+			Partial: bytes.HasPrefix(fields[2], []byte("P")),
+		}
+		if len(fields) == 4 {
+			entry.Message = fields[3]
+		}
+		return entry
+	}
+	return containerLine{Message: line}
+}
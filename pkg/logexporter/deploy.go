@@ -0,0 +1,44 @@
+package logexporter
+
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+)
+
+const (
+	// The dir where the conf pvc is mounted, every agent watches the config files in the dir named by its pod name
+	LogExporterConfVolumeMountPath = "/logexporter"
+
+	// The port of the api of logexporter
+	LogExporterPort = 8090
+
+	DefaultLogExporterImage = "fatsheep9146/logexporter:latest"
+)
+
+// Create or update the deployment of logexporter agents
+func (l *LogExporterAgentManagerImpl) Deploy() error {
+	ownerReferences, err := agent.GetOwnerReferences(l.Cli, l.Namespace, l.Name)
+	if err != nil {
+		return err
+	}
+
+	container := v1.Container{
+		Name:    "logexporter",
+		Image:   l.GetImage(DefaultLogExporterImage),
+		Command: []string{"logexporter"},
+		Args: []string{
+			fmt.Sprintf("--conf-dir=%s/$(POD_NAME)", LogExporterConfVolumeMountPath),
+			fmt.Sprintf("--listen=:%d", LogExporterPort),
+		},
+		Ports: []v1.ContainerPort{
+			{
+				Name:          "api",
+				ContainerPort: LogExporterPort,
+			},
+		},
+	}
+	return agent.ApplyWorkload(l.Cli, l.NewDeployment(ownerReferences, container, nil), l.DeployConfig, l.LogConfigs)
+}
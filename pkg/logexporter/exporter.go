@@ -0,0 +1,173 @@
+package logexporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// The api of logexporter which serves the status of runners
+	RunnersPath = "/api/v1/runners"
+
+	DefaultScanInterval = 5 * time.Second
+)
+
+type exporterRunner struct {
+	runner *Runner
+	data   []byte
+}
+
+// Exporter runs one runner for every config file in the conf dir, the runners are started, restarted and stopped
+// as the config files are added, changed and removed.
+type Exporter struct {
+	ConfDir      string
+	ScanInterval time.Duration
+
+	// Create the sink of one runner
+	NewSink func(cfg SinkConfig) Sink
+
+	// The syncs are serialized, otherwise a sync which reads the conf dir earlier may stop the runners just started
+	syncLock sync.Mutex
+
+	lock    sync.RWMutex
+	runners map[string]*exporterRunner
+}
+
+func NewExporter(confDir string, scanInterval time.Duration) *Exporter {
+	if scanInterval <= 0 {
+		scanInterval = DefaultScanInterval
+	}
+	return &Exporter{
+		ConfDir:      confDir,
+		ScanInterval: scanInterval,
+		NewSink:      NewSink,
+		runners:      make(map[string]*exporterRunner),
+	}
+}
+
+// Run syncs the runners with the config files until stop is closed, then stops all the runners
+func (e *Exporter) Run(stop <-chan struct{}) {
+	logger := log.WithFields(log.Fields{
+		"func": "Exporter.Run",
+	})
+
+	ticker := time.NewTicker(e.ScanInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.Sync(); err != nil {
+			logger.Errorf("Sync runners with the conf dir %s failed, err: %v", e.ConfDir, err)
+		}
+		select {
+		case <-stop:
+			e.stopAll()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync the runners with the config files in the conf dir
+func (e *Exporter) Sync() error {
+	logger := log.WithFields(log.Fields{
+		"func": "Exporter.Sync",
+	})
+
+	e.syncLock.Lock()
+	defer e.syncLock.Unlock()
+
+	err := os.MkdirAll(e.ConfDir, 0755)
+	if err != nil {
+		return err
+	}
+	infos, err := ioutil.ReadDir(e.ConfDir)
+	if err != nil {
+		return err
+	}
+
+	configs := make(map[string][]byte)
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || !strings.HasSuffix(info.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(e.ConfDir, info.Name()))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		configs[info.Name()] = data
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for file, r := range e.runners {
+		data, exist := configs[file]
+		if exist && bytes.Equal(data, r.data) {
+			continue
+		}
+		logger.Infof("Stop runner %s since its config file %s is changed or removed", r.runner.Config.Name, file)
+		r.runner.Stop()
+		delete(e.runners, file)
+	}
+
+	for file, data := range configs {
+		if _, exist := e.runners[file]; exist {
+			continue
+		}
+		cfg, err := ParseRunnerConfig(data)
+		if err != nil {
+			// The runner is started after the config file is fixed
+			logger.Errorf("Parse config file %s failed, err: %v", file, err)
+			continue
+		}
+		logger.Infof("Start runner %s from config file %s", cfg.Name, file)
+		runner := NewRunner(cfg, e.NewSink(cfg.Sink), e.ScanInterval)
+		runner.Start()
+		e.runners[file] = &exporterRunner{runner: runner, data: data}
+	}
+	return nil
+}
+
+func (e *Exporter) stopAll() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for file, r := range e.runners {
+		r.runner.Stop()
+		delete(e.runners, file)
+	}
+}
+
+// Status returns the status of all the running runners by name
+func (e *Exporter) Status() map[string]RunnerStatus {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	result := make(map[string]RunnerStatus)
+	for _, r := range e.runners {
+		result[r.runner.Config.Name] = r.runner.Status()
+	}
+	return result
+}
+
+// Serve the status of the runners
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e.Status())
+}
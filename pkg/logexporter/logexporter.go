@@ -0,0 +1,136 @@
+package logexporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+const (
+	// The logSource lags when its unshipped bytes are more than this
+	MaxLagBytes = 4 * 1024 * 1024
+
+	DefaultLogExporterAPITimeout = 5 * time.Second
+)
+
+// LogExporterAgentManagerImpl manages logexporter agents, the runner config of every logSource is a json file
+// in the conf dir of its agent, which is watched by logexporter.
+type LogExporterAgentManagerImpl struct {
+	*agent.FileAgentManager
+
+	// The client of the api of logexporter
+	Client *http.Client
+}
+
+func init() {
+	agent.Register(agent.Backend{
+		Type: agent.LogExporter,
+		New:  NewLogExporterAgentManager,
+		Schema: agent.ConfigSchema{
+			Format:      api.FormatJSON,
+			Description: "delivers configs by runner files of the logexporter in cmd/logexporter",
+		},
+		Compile: CompileSpec,
+	})
+}
+
+func NewLogExporterAgentManager(cfg *agent.AgentManagerConfig) agent.AgentManager {
+	timeout := cfg.APIConfig.Timeout
+	if timeout <= 0 {
+		timeout = DefaultLogExporterAPITimeout
+	}
+	l := &LogExporterAgentManagerImpl{
+		FileAgentManager: agent.NewFileAgentManager(agent.LogExporter, cfg, LogExporterConfVolumeMountPath, LogExporterPort),
+		Client:           &http.Client{Timeout: timeout},
+	}
+	l.Render = RenderConfig
+	l.FileName = getConfigFileName
+	return l
+}
+
+// Check whether the logs of logSource are shipped, by the offsets saved in the log meta dir
+func (l *LogExporterAgentManagerImpl) CheckLag(logSource *api.LogSource, agentName string) bool {
+	logger := log.WithFields(log.Fields{
+		"func":      "CheckLag",
+		"logSource": logSource.Meta.Name,
+	})
+
+	lag, err := Lag(logSource.GetLogDir(), logSource.GetLogMetaDir(), logSource.GetFilePatterns())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warnf("Get the lag of logSource failed, err: %v", err)
+		}
+		return true
+	}
+	return lag <= MaxLagBytes
+}
+
+// Check whether logexporter agentName stops the runner of logSource
+func (l *LogExporterAgentManagerImpl) RunnerStopped(logSource *api.LogSource, agentName string) (bool, error) {
+	addr, err := l.AgentAddr(agentName)
+	if err != nil {
+		return false, err
+	}
+	if addr == "" {
+		return true, nil
+	}
+
+	runners, err := l.listRunners(addr)
+	if err != nil {
+		return false, err
+	}
+	_, exist := runners[logSource.Meta.Name]
+	return !exist, nil
+}
+
+// Collect the status of logSources from the runners of logexporter agentName
+func (l *LogExporterAgentManagerImpl) CollectStatus(agentName string, logSources []*api.LogSource) (map[string]api.RunnerStatus, error) {
+	addr, err := l.AgentAddr(agentName)
+	if err != nil {
+		return nil, err
+	}
+	if addr == "" {
+		return nil, fmt.Errorf("log agent %s is not running", agentName)
+	}
+
+	runners, err := l.listRunners(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := make(map[string]api.RunnerStatus)
+	for _, logSource := range logSources {
+		runner, exist := runners[logSource.Meta.Name]
+		if !exist {
+			continue
+		}
+		result[logSource.Meta.Name] = runner.APIStatus(agentName, now)
+	}
+	return result, nil
+}
+
+func (l *LogExporterAgentManagerImpl) listRunners(addr string) (map[string]RunnerStatus, error) {
+	resp, err := l.Client.Get(fmt.Sprintf("http://%s%s", addr, RunnersPath))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("list runners from logexporter %s failed, status code: %d", addr, resp.StatusCode)
+	}
+
+	runners := make(map[string]RunnerStatus)
+	err = json.NewDecoder(resp.Body).Decode(&runners)
+	if err != nil {
+		return nil, err
+	}
+	return runners, nil
+}
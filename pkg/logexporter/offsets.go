@@ -0,0 +1,163 @@
+package logexporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const (
+	// The file in the log meta dir which keeps the offsets of the log files shipped
+	OffsetsFileName = "logexporter.offsets"
+)
+
+// The offset of one log file, keyed by the inode of the file, so that the offset follows the file renamed by
+// rotation, and the new file created with the old name is read from the beginning. The device is not a part of
+// the key, since it is assigned per mount on the network file systems, which differs between the nodes and
+// changes after remount. The name is updated when the file is renamed.
+type fileOffset struct {
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"`
+}
+
+// Return the key of the offset of the log file, which is the inode of the file
+func fileID(info os.FileInfo) string {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fmt.Sprintf("%d", uint64(stat.Ino))
+	}
+	// The file system without inode, the name is the only thing to tell the files
+	return info.Name()
+}
+
+// Check whether the offset is of the log file with the same inode. The inode of a removed file may be reused by
+// a new one, so the offset is kept only for the file with the same name, or renamed from it by rotation such as
+// app.log.1, which is never shorter than the offset.
+func (o fileOffset) matches(info os.FileInfo) bool {
+	return strings.HasPrefix(info.Name(), o.Name) && info.Size() >= o.Offset
+}
+
+// Load the offsets of the log files in logDir, the second result is false if no offsets are saved yet
+func loadOffsets(metaDir string) (map[string]fileOffset, bool, error) {
+	offsets := make(map[string]fileOffset)
+	data, err := ioutil.ReadFile(filepath.Join(metaDir, OffsetsFileName))
+	if os.IsNotExist(err) {
+		return offsets, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	err = json.Unmarshal(data, &offsets)
+	if err != nil {
+		return nil, false, fmt.Errorf("offsets file in %s is broken, err: %v", metaDir, err)
+	}
+
+	// The offsets saved by the older version are keyed by device and inode
+	for id, offset := range offsets {
+		if i := strings.LastIndex(id, ":"); i >= 0 {
+			delete(offsets, id)
+			offsets[id[i+1:]] = offset
+		}
+	}
+	return offsets, true, nil
+}
+
+// Save the offsets by renaming a temp file, so that a crash never leaves a partial offsets file
+func saveOffsets(metaDir string, offsets map[string]fileOffset) error {
+	data, err := json.Marshal(offsets)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(metaDir, OffsetsFileName)
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// List the log files in logDir, the older files come first so that the rotated files are shipped before
+// the current one. The meta dir and the hidden files are skipped. The symlinks are followed, such as the
+// container logs linked by kubelet, and the info of the target file is returned with the name of the link.
+func listLogFiles(logDir string, patterns []string) ([]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(logDir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]os.FileInfo, 0, len(infos))
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".") || !matchPatterns(info.Name(), patterns) {
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(filepath.Join(logDir, info.Name()))
+			if err != nil {
+				// The target is removed, such as the log of an old container
+				continue
+			}
+			info = linkInfo{FileInfo: target, name: info.Name()}
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, info)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].ModTime().Equal(files[j].ModTime()) {
+			return files[i].Name() < files[j].Name()
+		}
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	return files, nil
+}
+
+// The info of the target file of a symlink, named by the link
+type linkInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (l linkInfo) Name() string {
+	return l.name
+}
+
+// Check whether the file name matches one of patterns, no patterns match all the files
+func matchPatterns(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Lag returns the bytes of the log files in logDir matching patterns which are not shipped yet
+func Lag(logDir, metaDir string, patterns []string) (int64, error) {
+	offsets, _, err := loadOffsets(metaDir)
+	if err != nil {
+		return 0, err
+	}
+	files, err := listLogFiles(logDir, patterns)
+	if err != nil {
+		return 0, err
+	}
+
+	var lag int64
+	for _, file := range files {
+		offset := offsets[fileID(file)]
+		if !offset.matches(file) {
+			// The file is truncated or a new one, it is read from the beginning again
+			offset.Offset = 0
+		}
+		lag += file.Size() - offset.Offset
+	}
+	return lag, nil
+}
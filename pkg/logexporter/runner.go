@@ -0,0 +1,338 @@
+package logexporter
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+// The status of one runner, served by the http api of logexporter
+type RunnerStatus struct {
+	ReadLines    int64     `json:"read_lines"`
+	ReadBytes    int64     `json:"read_bytes"`
+	SentLines    int64     `json:"sent_lines"`
+	SendErrors   int64     `json:"send_errors"`
+	LastError    string    `json:"last_error,omitempty"`
+	LastActivity time.Time `json:"last_activity"`
+}
+
+// Convert to the runner status of logSource reported by agentName
+func (s *RunnerStatus) APIStatus(agentName string, collectedAt time.Time) api.RunnerStatus {
+	return api.RunnerStatus{
+		Agent:        agentName,
+		ReadLines:    s.ReadLines,
+		ReadBytes:    s.ReadBytes,
+		SentLines:    s.SentLines,
+		SendErrors:   s.SendErrors,
+		LastError:    s.LastError,
+		LastActivity: s.LastActivity,
+		CollectedAt:  collectedAt,
+	}
+}
+
+// Runner tails the log files in the log dir of one logSource and ships the lines to the sink in batches.
+// The offsets of the lines shipped are saved in the log meta dir, so that the runner started by another
+// exporter for the same logSource goes on from there.
+type Runner struct {
+	Config       *RunnerConfig
+	Sink         Sink
+	ScanInterval time.Duration
+
+	// The offsets shipped, and the offsets read into the pending batch, keyed by the file id
+	offsets   map[string]fileOffset
+	positions map[string]fileOffset
+
+	batch      []Record
+	batchBytes int
+	batchStart time.Time
+
+	statusLock sync.RWMutex
+	status     RunnerStatus
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewRunner(cfg *RunnerConfig, sink Sink, scanInterval time.Duration) *Runner {
+	return &Runner{
+		Config:       cfg,
+		Sink:         sink,
+		ScanInterval: scanInterval,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+// Start tailing in a new goroutine
+func (r *Runner) Start() {
+	go r.run()
+}
+
+// Stop tailing, the pending batch is shipped before it returns
+func (r *Runner) Stop() {
+	close(r.stop)
+	<-r.done
+}
+
+func (r *Runner) Status() RunnerStatus {
+	r.statusLock.RLock()
+	defer r.statusLock.RUnlock()
+	return r.status
+}
+
+func (r *Runner) run() {
+	logger := log.WithFields(log.Fields{
+		"func":   "Runner.run",
+		"runner": r.Config.Name,
+	})
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.ScanInterval)
+	defer ticker.Stop()
+
+	for {
+		// The offsets are loaded again after an error, the sink may fail after some lines are read
+		if r.offsets == nil {
+			if err := r.init(); err != nil {
+				logger.Errorf("Init runner failed, err: %v", err)
+				r.setError(err)
+			}
+		}
+		if r.offsets != nil {
+			if err := r.collect(false); err != nil {
+				logger.Errorf("Collect logs failed, err: %v", err)
+				r.setError(err)
+				r.offsets = nil
+			}
+		}
+
+		select {
+		case <-r.stop:
+			if r.offsets != nil {
+				if err := r.collect(true); err != nil {
+					logger.Errorf("Ship the pending logs failed, err: %v", err)
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Runner) init() error {
+	err := os.MkdirAll(r.Config.MetaDir, 0755)
+	if err != nil {
+		return err
+	}
+	offsets, exist, err := loadOffsets(r.Config.MetaDir)
+	if err != nil {
+		return err
+	}
+
+	// The files existing before the runner starts for the first time are skipped when reading from newest
+	if !exist && r.Config.ReadFrom == ReadFromNewest {
+		files, err := listLogFiles(r.Config.LogDir, r.Config.Patterns)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, file := range files {
+			offsets[fileID(file)] = fileOffset{Name: file.Name(), Offset: file.Size()}
+		}
+	}
+
+	r.offsets = offsets
+	r.positions = make(map[string]fileOffset)
+	for k, v := range offsets {
+		r.positions[k] = v
+	}
+	r.batch = nil
+	r.batchBytes = 0
+	return nil
+}
+
+// Read the new lines of all the log files, and ship the batch if it is full or too old, or flush is true
+func (r *Runner) collect(flush bool) error {
+	files, err := listLogFiles(r.Config.LogDir, r.Config.Patterns)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// The log dir is created by the pod, which may not write any log yet
+			return nil
+		}
+		return err
+	}
+
+	// The offsets of removed files are dropped, they never come back with the same content
+	exists := make(map[string]bool)
+	for _, file := range files {
+		exists[fileID(file)] = true
+	}
+	for id := range r.positions {
+		if !exists[id] {
+			delete(r.positions, id)
+			delete(r.offsets, id)
+		}
+	}
+
+	for _, file := range files {
+		id := fileID(file)
+		position := r.positions[id]
+		if !position.matches(file) {
+			// The file is truncated, or a new file reuses the inode, it is read from the beginning again
+			position.Offset = 0
+		}
+		// The file may be renamed by rotation
+		position.Name = file.Name()
+		r.positions[id] = position
+		if file.Size() == position.Offset {
+			continue
+		}
+		err = r.readFile(id, file.Name(), position.Offset)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(r.batch) != 0 && (flush || time.Since(r.batchStart) >= r.Config.Sink.getFlushInterval()) {
+		return r.ship()
+	}
+	return nil
+}
+
+// Read the complete lines of the file with id from position, the last line which is being written is left for later.
+// The lines of container log are parsed in its format, the message split into lines is read only when its last
+// line is written, so that the position is never in the middle of a message.
+func (r *Runner) readFile(id, name string, position int64) error {
+	path := filepath.Join(r.Config.LogDir, name)
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	// The file is renamed by rotation after it is listed, it is read with its new name in the next scan
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fileID(info) != id {
+		return nil
+	}
+
+	_, err = f.Seek(position, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	var message []byte
+	var messageBytes int
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if r.Config.ContainerLogFormat == "" {
+			r.advance(id, int64(len(line)))
+			r.add(path, "", bytes.TrimRight(line, "\r\n"), len(line))
+		} else {
+			entry := parseContainerLine(r.Config.ContainerLogFormat, line)
+			message = append(message, entry.Message...)
+			messageBytes += len(line)
+			if entry.Partial {
+				continue
+			}
+			r.advance(id, int64(messageBytes))
+			r.add(path, entry.Stream, message, messageBytes)
+			message = nil
+			messageBytes = 0
+		}
+
+		if len(r.batch) >= r.Config.Sink.getBatchLines() || r.batchBytes >= r.Config.Sink.getBatchBytes() {
+			err = r.ship()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Move the position of the file with id forward by size bytes
+func (r *Runner) advance(id string, size int64) {
+	position := r.positions[id]
+	position.Offset += size
+	r.positions[id] = position
+}
+
+// Add the message read from size bytes of the file to the batch, the stream is set for the container log
+func (r *Runner) add(path, stream string, message []byte, size int) {
+	record := make(Record, len(r.Config.Fields)+3)
+	for k, v := range r.Config.Fields {
+		record[k] = v
+	}
+	record[LogSourceKey] = path
+	record[MessageKey] = string(message)
+	if stream != "" {
+		record[StreamKey] = stream
+	}
+
+	if len(r.batch) == 0 {
+		r.batchStart = time.Now()
+	}
+	r.batch = append(r.batch, record)
+	r.batchBytes += size
+
+	r.statusLock.Lock()
+	r.status.ReadLines++
+	r.status.ReadBytes += int64(size)
+	r.status.LastActivity = time.Now()
+	r.statusLock.Unlock()
+}
+
+// Ship the pending batch, and save the offsets of the lines in it
+func (r *Runner) ship() error {
+	err := r.Sink.Send(r.batch)
+	if err != nil {
+		r.statusLock.Lock()
+		r.status.SendErrors++
+		r.statusLock.Unlock()
+		return err
+	}
+
+	for k, v := range r.positions {
+		r.offsets[k] = v
+	}
+	err = saveOffsets(r.Config.MetaDir, r.offsets)
+	if err != nil {
+		return err
+	}
+
+	r.statusLock.Lock()
+	r.status.SentLines += int64(len(r.batch))
+	r.status.LastError = ""
+	r.statusLock.Unlock()
+
+	r.batch = nil
+	r.batchBytes = 0
+	return nil
+}
+
+func (r *Runner) setError(err error) {
+	r.statusLock.Lock()
+	r.status.LastError = err.Error()
+	r.statusLock.Unlock()
+}
@@ -0,0 +1,304 @@
+package logexporter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type fakeSink struct {
+	records []Record
+	broken  bool
+}
+
+func (s *fakeSink) Send(records []Record) error {
+	if s.broken {
+		return fmt.Errorf("sink is broken")
+	}
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func newTestRunner(t *testing.T, sink Sink) (*Runner, string) {
+	dir, err := ioutil.TempDir("", "logexporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &RunnerConfig{
+		Name:    "test",
+		LogDir:  dir,
+		MetaDir: filepath.Join(dir, ".meta"),
+		StreamConfig: StreamConfig{
+			Fields: map[string]string{"k8s_pod_name": "test-xxx-yyy"},
+			Sink:   SinkConfig{URL: "http://sink", BatchLines: 2},
+		},
+	}
+	return NewRunner(cfg, sink, time.Second), dir
+}
+
+func TestRunnerCollect(t *testing.T) {
+	sink := &fakeSink{}
+	runner, dir := newTestRunner(t, sink)
+	defer os.RemoveAll(dir)
+
+	// The last line is being written, it is shipped after it is completed
+	err := ioutil.WriteFile(filepath.Join(dir, "app.log"), []byte("a\nb\r\nc\npartial"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = runner.init(); err != nil {
+		t.Fatal(err)
+	}
+	if err = runner.collect(true); err != nil {
+		t.Fatalf("collect failed, err: %v", err)
+	}
+	if len(sink.records) != 3 || sink.records[1][MessageKey] != "b" || sink.records[2]["k8s_pod_name"] != "test-xxx-yyy" {
+		t.Errorf("records shipped are wrong, are %v", sink.records)
+	}
+	lag, err := Lag(dir, runner.Config.MetaDir, nil)
+	if err != nil || lag != int64(len("partial")) {
+		t.Errorf("lag should be the partial line, is %d, err: %v", lag, err)
+	}
+
+	// The lines are not shipped again by the runner started later
+	sink.records = nil
+	runner, _ = newTestRunner(t, sink)
+	runner.Config.LogDir = dir
+	runner.Config.MetaDir = filepath.Join(dir, ".meta")
+	f, err := os.OpenFile(filepath.Join(dir, "app.log"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("\nd\n")
+	f.Close()
+	if err = runner.init(); err != nil {
+		t.Fatal(err)
+	}
+	if err = runner.collect(true); err != nil {
+		t.Fatalf("collect failed, err: %v", err)
+	}
+	if len(sink.records) != 2 || sink.records[0][MessageKey] != "partial" || sink.records[1][MessageKey] != "d" {
+		t.Errorf("records shipped after restart are wrong, are %v", sink.records)
+	}
+}
+
+func TestRunnerCollectRotated(t *testing.T) {
+	sink := &fakeSink{}
+	runner, dir := newTestRunner(t, sink)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	err := ioutil.WriteFile(path, []byte("a\nb\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = runner.init(); err != nil {
+		t.Fatal(err)
+	}
+	if err = runner.collect(true); err != nil {
+		t.Fatalf("collect failed, err: %v", err)
+	}
+
+	// The file is renamed with a line appended, and the new file grows past the offset of the old one
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("c\n")
+	f.Close()
+	if err = os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path, []byte("d\ne\nf\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink.records = nil
+	if err = runner.collect(true); err != nil {
+		t.Fatalf("collect failed, err: %v", err)
+	}
+	var messages []string
+	for _, record := range sink.records {
+		messages = append(messages, record[MessageKey].(string))
+	}
+	sort.Strings(messages)
+	if strings.Join(messages, ",") != "c,d,e,f" {
+		t.Errorf("records shipped after rotation are wrong, are %v", messages)
+	}
+
+	// The offset of the rotated file is saved with its new name
+	offsets, _, err := loadOffsets(runner.Config.MetaDir)
+	if err != nil || len(offsets) != 2 {
+		t.Fatalf("expect offsets of two files, got %v, err: %v", offsets, err)
+	}
+	for _, offset := range offsets {
+		if (offset.Name != "app.log" || offset.Offset != 6) && (offset.Name != "app.log.1" || offset.Offset != 6) {
+			t.Errorf("offset is wrong, is %+v", offset)
+		}
+	}
+	lag, err := Lag(dir, runner.Config.MetaDir, nil)
+	if err != nil || lag != 0 {
+		t.Errorf("no lag after rotation, is %d, err: %v", lag, err)
+	}
+}
+
+func TestRunnerCollectOtherDevice(t *testing.T) {
+	sink := &fakeSink{}
+	runner, dir := newTestRunner(t, sink)
+	defer os.RemoveAll(dir)
+
+	inodes := make(map[string]uint64)
+	for name, content := range map[string]string{"app.log": "a\nb\n", "new.log": "c\nd\n"} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inodes[name] = uint64(info.Sys().(*syscall.Stat_t).Ino)
+	}
+
+	// The offsets are saved on another node, where the volume is mounted with another device. The inode of new.log
+	// was used by a removed file, its offset is not of new.log.
+	if err := os.MkdirAll(runner.Config.MetaDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	offsets := fmt.Sprintf(`{"12345:%d": {"name": "app.log", "offset": 2}, "%d": {"name": "removed.log", "offset": 2}}`, inodes["app.log"], inodes["new.log"])
+	if err := ioutil.WriteFile(filepath.Join(runner.Config.MetaDir, OffsetsFileName), []byte(offsets), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runner.init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.collect(true); err != nil {
+		t.Fatalf("collect failed, err: %v", err)
+	}
+	var messages []string
+	for _, record := range sink.records {
+		messages = append(messages, record[MessageKey].(string))
+	}
+	sort.Strings(messages)
+	if strings.Join(messages, ",") != "b,c,d" {
+		t.Errorf("only the lines after the saved offset should be shipped, are %v", messages)
+	}
+}
+
+func TestRunnerCollectSymlink(t *testing.T) {
+	sink := &fakeSink{}
+	runner, dir := newTestRunner(t, sink)
+	defer os.RemoveAll(dir)
+
+	// The log file is a symlink to the file outside the log dir, like the container logs linked by kubelet
+	target, err := ioutil.TempDir("", "logexporter-target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(target)
+	err = ioutil.WriteFile(filepath.Join(target, "0.log"), []byte("a\nb\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink(filepath.Join(target, "0.log"), filepath.Join(dir, "app.log")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink(filepath.Join(target, "removed.log"), filepath.Join(dir, "removed.log")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = runner.init(); err != nil {
+		t.Fatal(err)
+	}
+	if err = runner.collect(true); err != nil {
+		t.Fatalf("collect failed, err: %v", err)
+	}
+	if len(sink.records) != 2 || sink.records[0][LogSourceKey] != filepath.Join(dir, "app.log") || sink.records[1][MessageKey] != "b" {
+		t.Errorf("records of the symlinked file are wrong, are %v", sink.records)
+	}
+	lag, err := Lag(dir, runner.Config.MetaDir, nil)
+	if err != nil || lag != 0 {
+		t.Errorf("no lag of the symlinked file, is %d, err: %v", lag, err)
+	}
+}
+
+func TestRunnerSinkFailed(t *testing.T) {
+	sink := &fakeSink{broken: true}
+	runner, dir := newTestRunner(t, sink)
+	defer os.RemoveAll(dir)
+
+	err := ioutil.WriteFile(filepath.Join(dir, "app.log"), []byte("a\nb\nc\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = runner.init(); err != nil {
+		t.Fatal(err)
+	}
+	if err = runner.collect(true); err == nil {
+		t.Fatalf("expect collect failed with broken sink")
+	}
+	lag, _ := Lag(dir, runner.Config.MetaDir, nil)
+	if lag != 6 {
+		t.Errorf("no offset should be saved with broken sink, lag is %d", lag)
+	}
+
+	// All the lines are shipped after the sink recovers
+	sink.broken = false
+	if err = runner.init(); err != nil {
+		t.Fatal(err)
+	}
+	if err = runner.collect(true); err != nil {
+		t.Fatalf("collect failed, err: %v", err)
+	}
+	if len(sink.records) != 3 {
+		t.Errorf("records shipped after the sink recovers are wrong, are %v", sink.records)
+	}
+}
+
+func TestRunnerCollectContainerLog(t *testing.T) {
+	for format, content := range map[string]string{
+		"cri": "2024-01-01T00:00:00.000000001Z stdout F a\n" +
+			"2024-01-01T00:00:00.000000002Z stderr P b1\n" +
+			"2024-01-01T00:00:00.000000003Z stderr F b2\n" +
+			"2024-01-01T00:00:00.000000004Z stdout P c1\n",
+		"docker": `{"log":"a\n","stream":"stdout","time":"2024-01-01T00:00:00.000000001Z"}` + "\n" +
+			`{"log":"b1","stream":"stderr","time":"2024-01-01T00:00:00.000000002Z"}` + "\n" +
+			`{"log":"b2\n","stream":"stderr","time":"2024-01-01T00:00:00.000000003Z"}` + "\n" +
+			`{"log":"c1","stream":"stdout","time":"2024-01-01T00:00:00.000000004Z"}` + "\n",
+	} {
+		sink := &fakeSink{}
+		runner, dir := newTestRunner(t, sink)
+		defer os.RemoveAll(dir)
+		runner.Config.ContainerLogFormat = format
+
+		err := ioutil.WriteFile(filepath.Join(dir, "0.log"), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = runner.init(); err != nil {
+			t.Fatal(err)
+		}
+		if err = runner.collect(true); err != nil {
+			t.Fatalf("collect %s log failed, err: %v", format, err)
+		}
+
+		// The split message is joined, and the one not completed is left for later
+		if len(sink.records) != 2 || sink.records[0][MessageKey] != "a" || sink.records[0][StreamKey] != "stdout" ||
+			sink.records[1][MessageKey] != "b1b2" || sink.records[1][StreamKey] != "stderr" {
+			t.Errorf("records of %s log are wrong, are %v", format, sink.records)
+		}
+		lines := strings.SplitAfter(content, "\n")
+		lag, err := Lag(dir, runner.Config.MetaDir, nil)
+		if err != nil || lag != int64(len(lines[3])) {
+			t.Errorf("lag of %s log should be the partial message, is %d, err: %v", format, lag, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package logexporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// One log line with its fields
+type Record map[string]interface{}
+
+// Sink ships the batches of records
+type Sink interface {
+	Send(records []Record) error
+}
+
+// Create the sink of cfg, which is a file sink if the path is set
+func NewSink(cfg SinkConfig) Sink {
+	if cfg.Path != "" {
+		return NewFileSink(cfg)
+	}
+	return NewHTTPSink(cfg)
+}
+
+// HTTPSink posts every batch as a json array
+type HTTPSink struct {
+	Config SinkConfig
+	Client *http.Client
+}
+
+func NewHTTPSink(cfg SinkConfig) *HTTPSink {
+	return &HTTPSink{
+		Config: cfg,
+		Client: &http.Client{Timeout: cfg.getTimeout()},
+	}
+}
+
+func (s *HTTPSink) Send(records []Record) error {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.Config.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.Config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("send %d records to %s failed, status code: %d, body: %s", len(records), s.Config.URL, resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// FileSink appends every record to a local file as one json line, the file is opened for every batch
+// so that it can be rotated by others
+type FileSink struct {
+	Config SinkConfig
+}
+
+func NewFileSink(cfg SinkConfig) *FileSink {
+	return &FileSink{Config: cfg}
+}
+
+func (s *FileSink) Send(records []Record) error {
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	err := os.MkdirAll(filepath.Dir(s.Config.Path), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.Config.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write %d records to %s failed, err: %v", len(records), s.Config.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package logkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+// LogkitAPIAgentManagerImpl manages the runners of logkit agents through the http api of logkit on the pod IP,
+// so that logmanager and agents do not need to share the conf dir. The agents are deployed and listed
+// in the same way as LogkitAgentManagerImpl, but in their own workload, so that both types run side by side.
+type LogkitAPIAgentManagerImpl struct {
+	*LogkitAgentManagerImpl
+}
+
+func init() {
+	agent.Register(agent.Backend{
+		Type: agent.LogkitAPI,
+		New:  NewLogkitAPIAgentManager,
+		Schema: agent.ConfigSchema{
+			Format:      api.FormatJSON,
+			Description: "delivers configs by the http api of logkit",
+		},
+		Compile: compileSpec,
+	})
+}
+
+func NewLogkitAPIAgentManager(cfg *agent.AgentManagerConfig) agent.AgentManager {
+	l := newLogkitAgentManager(cfg)
+	l.EmptyConfDir = true
+	l.DeployName = getDeployName(agent.LogkitAPI, cfg.Name)
+	return &LogkitAPIAgentManagerImpl{l}
+}
+
+// List the logkit agents, which have no conf dir to be collected garbage from
+func (l *LogkitAPIAgentManagerImpl) List() ([]agent.Agent, error) {
+	agents, err := l.LogkitAgentManagerImpl.List()
+	if err != nil {
+		return agents, err
+	}
+	for i := range agents {
+		agents[i].ConfPath = ""
+	}
+	return agents, nil
+}
+
+// Add or update the runner of one logSource in logAgent agentName
+func (l *LogkitAPIAgentManagerImpl) AddConfig(logSource *api.LogSource, agentName string) (string, error) {
+	config, err := l.render(logSource)
+	if err != nil {
+		return "", err
+	}
+
+	addr, err := l.agentAddr(agentName)
+	if err != nil {
+		return "", err
+	}
+
+	runners, err := l.Client.ListRunners(addr)
+	if err != nil {
+		return "", err
+	}
+	name := getRunnerName(logSource)
+	if current, exist := runners[name]; !exist {
+		err = l.Client.AddRunner(addr, name, []byte(config))
+	} else if !sameRunnerConfig(current, []byte(config)) {
+		// Identical config is not updated again, otherwise logkit restarts the runner
+		err = l.Client.UpdateRunner(addr, name, []byte(config))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// The conf path has the same layout as the file based one, so that the agent name can be parsed from it
+	confPath := fmt.Sprintf("%s/%s", getLogkitAgentConfDir(agentName), name)
+	logSource.Status.ConfigStatus.Path = confPath
+
+	return confPath, nil
+}
+
+// Delete the runner of one logSource from logAgent agentName, the runner of the agent which is not running is gone already
+func (l *LogkitAPIAgentManagerImpl) DelConfig(logSource *api.LogSource, agentName string) error {
+	addr, err := l.AgentAddr(agentName)
+	if err != nil {
+		return err
+	}
+	if addr == "" {
+		return nil
+	}
+
+	err = l.Client.DeleteRunner(addr, getRunnerName(logSource))
+	if err != nil && !IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// Return the names of the runners of logAgent agentName
+func (l *LogkitAPIAgentManagerImpl) ListRunners(agentName string) ([]string, error) {
+	addr, err := l.agentAddr(agentName)
+	if err != nil {
+		return nil, err
+	}
+
+	runners, err := l.Client.ListRunners(addr)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(runners))
+	for name := range runners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Return the address of the api of logAgent agentName, which should be running
+func (l *LogkitAPIAgentManagerImpl) agentAddr(agentName string) (string, error) {
+	addr, err := l.AgentAddr(agentName)
+	if err != nil {
+		return "", err
+	}
+	if addr == "" {
+		return "", fmt.Errorf("log agent %s is not running", agentName)
+	}
+	return addr, nil
+}
+
+// Check whether the config of runner returned by logkit is the same as config, the create time set by logkit is ignored
+func sameRunnerConfig(current, config []byte) bool {
+	decode := func(data []byte) (map[string]interface{}, error) {
+		m := make(map[string]interface{})
+		err := json.Unmarshal(data, &m)
+		delete(m, "createtime")
+		return m, err
+	}
+	a, err := decode(current)
+	if err != nil {
+		return false
+	}
+	b, err := decode(config)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
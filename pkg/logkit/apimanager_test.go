@@ -0,0 +1,198 @@
+package logkit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+// The stand-in of logkit, which keeps the runner configs in memory
+type fakeLogkit struct {
+	lock     sync.Mutex
+	runners  map[string]json.RawMessage
+	requests []string
+
+	username string
+	password string
+	delay    time.Duration
+}
+
+func (f *fakeLogkit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	time.Sleep(f.delay)
+
+	if f.username != "" {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != f.username || password != f.password {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if r.URL.Path == LogkitConfigsPath && r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(f.runners)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, LogkitConfigsPath+"/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, LogkitConfigsPath+"/")
+	_, exist := f.runners[name]
+	switch r.Method {
+	case http.MethodPost, http.MethodPut:
+		if (r.Method == http.MethodPost) == exist {
+			http.Error(w, "runner exists or not", http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		f.runners[name] = body
+	case http.MethodDelete:
+		if !exist {
+			http.NotFound(w, r)
+			return
+		}
+		delete(f.runners, name)
+	}
+}
+
+func newTestAPIAgentManager(server *httptest.Server, cfg agent.APIConfig) *LogkitAPIAgentManagerImpl {
+	l := NewLogkitAPIAgentManager(&agent.AgentManagerConfig{APIConfig: cfg}).(*LogkitAPIAgentManagerImpl)
+	l.AgentAddr = func(agentName string) (string, error) {
+		if agentName == "stopped" {
+			return "", nil
+		}
+		return strings.TrimPrefix(server.URL, "http://"), nil
+	}
+	return l
+}
+
+func newTestAPILogSource() *api.LogSource {
+	return &api.LogSource{
+		Meta: api.Meta{
+			Name: "deployment_test_applog_test-xxx-yyy",
+		},
+		Spec: api.LogSourceSpec{
+			PodName:     "test-xxx-yyy",
+			Namespace:   "test-ns",
+			Stream:      "applog",
+			VolumeMount: "applog",
+			Config:      `{"name": "applog", "reader": {}, "parser": {"type": "raw"}, "senders": [{"sender_type": "discard"}]}`,
+		},
+	}
+}
+
+func TestAPIAgentManager(t *testing.T) {
+	fake := &fakeLogkit{runners: make(map[string]json.RawMessage)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	l := newTestAPIAgentManager(server, agent.APIConfig{})
+	logSource := newTestAPILogSource()
+
+	// The first add creates the runner, the second one of the same config does nothing, and the third one updates it
+	for i := 0; i < 3; i++ {
+		if i == 2 {
+			logSource.Spec.Config = strings.Replace(logSource.Spec.Config, "raw", "json", 1)
+		}
+		confPath, err := l.AddConfig(logSource, "logkit-0")
+		if err != nil {
+			t.Fatalf("add config failed, err: %v", err)
+		}
+		if confPath != "/logkit/logkit-0/deployment_test_applog_test-xxx-yyy" || l.GetAgentNameFromConf(confPath) != "logkit-0" {
+			t.Errorf("conf path is wrong, is %s", confPath)
+		}
+	}
+	expected := []string{
+		"GET /logkit/configs", "POST /logkit/configs/deployment_test_applog_test-xxx-yyy",
+		"GET /logkit/configs",
+		"GET /logkit/configs", "PUT /logkit/configs/deployment_test_applog_test-xxx-yyy",
+	}
+	if strings.Join(fake.requests, ",") != strings.Join(expected, ",") {
+		t.Errorf("expect the runner added, kept then updated, got %v", fake.requests)
+	}
+
+	runners, err := l.ListRunners("logkit-0")
+	if err != nil || len(runners) != 1 || runners[0] != "deployment_test_applog_test-xxx-yyy" {
+		t.Errorf("expect one runner listed, got %v, err: %v", runners, err)
+	}
+
+	// Deleting a runner twice or from the stopped agent succeeds
+	for _, agentName := range []string{"logkit-0", "logkit-0", "stopped"} {
+		err = l.DelConfig(logSource, agentName)
+		if err != nil {
+			t.Errorf("delete config from %s failed, err: %v", agentName, err)
+		}
+	}
+	if len(fake.runners) != 0 {
+		t.Errorf("expect no runner left, got %v", fake.runners)
+	}
+
+	_, err = l.AddConfig(logSource, "stopped")
+	if err == nil {
+		t.Errorf("expect adding config to the stopped agent failed")
+	}
+}
+
+func TestAPIAgentManagerAuth(t *testing.T) {
+	fake := &fakeLogkit{runners: make(map[string]json.RawMessage), username: "admin", password: "secret"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	l := newTestAPIAgentManager(server, agent.APIConfig{})
+	_, err := l.AddConfig(newTestAPILogSource(), "logkit-0")
+	if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expect unauthorized without auth, got %v", err)
+	}
+
+	l = newTestAPIAgentManager(server, agent.APIConfig{Username: "admin", Password: "secret"})
+	_, err = l.AddConfig(newTestAPILogSource(), "logkit-0")
+	if err != nil {
+		t.Errorf("add config with auth failed, err: %v", err)
+	}
+}
+
+func TestAPIAgentManagerTimeout(t *testing.T) {
+	fake := &fakeLogkit{runners: make(map[string]json.RawMessage), delay: 200 * time.Millisecond}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	l := newTestAPIAgentManager(server, agent.APIConfig{Timeout: 50 * time.Millisecond})
+	_, err := l.ListRunners("logkit-0")
+	if err == nil {
+		t.Errorf("expect the request timeout")
+	}
+}
+
+func TestConvertRunnerStatus(t *testing.T) {
+	runner := &RunnerStatus{
+		Name:          "applog_test-xxx-yyy",
+		ReadDataSize:  4096,
+		ReadDataCount: 100,
+		ParserStats:   StatsInfo{Success: 98, Errors: 2, LastError: "parse failed"},
+		SenderStats: map[string]StatsInfo{
+			"a_sender": {Success: 90, Errors: 8, LastError: "connection refused"},
+			"b_sender": {Success: 98},
+		},
+	}
+
+	status := convertRunnerStatus("logkit-0", runner, time.Unix(1000, 0))
+	if status.Agent != "logkit-0" || status.ReadLines != 100 || status.ReadBytes != 4096 || status.SentLines != 90 {
+		t.Errorf("the counters of runner status are wrong, is %+v", status)
+	}
+	if status.ParseErrors != 2 || status.SendErrors != 8 || status.LastError != "connection refused" {
+		t.Errorf("the errors of runner status are wrong, is %+v", status)
+	}
+}
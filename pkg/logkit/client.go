@@ -0,0 +1,131 @@
+package logkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+)
+
+const (
+	// The apis of logkit to manage the runner configs and query the status of runners
+	LogkitConfigsPath = "/logkit/configs"
+	LogkitStatusPath  = "/logkit/status"
+
+	// The default timeout of the requests to logkit
+	DefaultLogkitAPITimeout = 5 * time.Second
+)
+
+// The error returned by logkit api
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("logkit api returns status code %d, message: %s", e.StatusCode, e.Message)
+}
+
+// Check whether the error means the runner does not exist
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// Client calls the http api of logkit agents, the address of agent is given in every call
+type Client struct {
+	http     *http.Client
+	username string
+	password string
+}
+
+func NewClient(cfg agent.APIConfig) *Client {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultLogkitAPITimeout
+	}
+	return &Client{
+		http:     &http.Client{Timeout: timeout},
+		username: cfg.Username,
+		password: cfg.Password,
+	}
+}
+
+// List the configs of all the runners, which is keyed by runner name
+func (c *Client) ListRunners(addr string) (map[string]json.RawMessage, error) {
+	runners := make(map[string]json.RawMessage)
+	err := c.do(http.MethodGet, addr, LogkitConfigsPath, nil, &runners)
+	if err != nil {
+		return nil, err
+	}
+	return runners, nil
+}
+
+// Add a runner with the config
+func (c *Client) AddRunner(addr, name string, config []byte) error {
+	return c.do(http.MethodPost, addr, fmt.Sprintf("%s/%s", LogkitConfigsPath, name), config, nil)
+}
+
+// Update the config of a runner, logkit restarts the runner with the new config
+func (c *Client) UpdateRunner(addr, name string, config []byte) error {
+	return c.do(http.MethodPut, addr, fmt.Sprintf("%s/%s", LogkitConfigsPath, name), config, nil)
+}
+
+// Delete a runner
+func (c *Client) DeleteRunner(addr, name string) error {
+	return c.do(http.MethodDelete, addr, fmt.Sprintf("%s/%s", LogkitConfigsPath, name), nil, nil)
+}
+
+// Get the status of all the runners, which is keyed by runner name
+func (c *Client) RunnerStatus(addr string) (map[string]RunnerStatus, error) {
+	status := make(map[string]RunnerStatus)
+	err := c.do(http.MethodGet, addr, LogkitStatusPath, nil, &status)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func (c *Client) do(method, addr, path string, body []byte, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("http://%s%s", addr, path), reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &APIError{
+			StatusCode: resp.StatusCode,
+			Message:    string(bytes.TrimSpace(data)),
+		}
+	}
+
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,91 @@
+package logkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+// Compile the collect spec into the runner config of logkit, the reader settings fixed by renderConfig are left out
+func compileSpec(spec *api.CollectSpec) (string, error) {
+	config := LogkitConf{
+		ReaderConfig: make(map[string]string),
+		ParserConf:   map[string]string{"name": "parser", "type": "raw"},
+	}
+
+	// The dir reader of logkit filters the files by only one pattern
+	switch len(spec.Paths) {
+	case 0:
+	case 1:
+		config.ReaderConfig["valid_file_pattern"] = spec.Paths[0]
+	default:
+		return "", fmt.Errorf("logkit supports only one path pattern, has %d", len(spec.Paths))
+	}
+	if spec.ReadFrom != "" {
+		config.ReaderConfig["read_from"] = spec.ReadFrom
+	}
+	if spec.Multiline != nil {
+		config.ReaderConfig["head_pattern"] = spec.Multiline.Start
+	}
+
+	if spec.Parser != nil {
+		switch spec.Parser.Type {
+		case api.ParserRaw:
+		case api.ParserJSON:
+			config.ParserConf["type"] = "json"
+		default:
+			return "", fmt.Errorf("parser %s is not supported by logkit", spec.Parser.Type)
+		}
+	}
+
+	// The fields are the labels of parser, which are written as "k1 v1,k2 v2"
+	if len(spec.Fields) != 0 {
+		keys := make([]string, 0, len(spec.Fields))
+		for k := range spec.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		labels := make([]string, 0, len(keys))
+		for _, k := range keys {
+			v := spec.Fields[k]
+			if strings.ContainsAny(k+v, " ,") || v == "" {
+				return "", fmt.Errorf("field %s of logkit should be a non-empty value without spaces and commas", k)
+			}
+			labels = append(labels, fmt.Sprintf("%s %s", k, v))
+		}
+		config.ParserConf["labels"] = strings.Join(labels, ",")
+	}
+
+	dest := spec.Destination
+	switch dest.Type {
+	case api.DestinationHTTP:
+		if len(dest.Headers) != 0 || dest.Username != "" {
+			return "", fmt.Errorf("http destination of logkit does not support headers and auth")
+		}
+		config.SenderConfig = []map[string]string{{
+			"sender_type":          "http",
+			"http_sender_url":      dest.URL,
+			"http_sender_protocol": "json",
+		}}
+	case api.DestinationElasticsearch:
+		sender := map[string]string{
+			"sender_type":   "elasticsearch",
+			"elastic_host":  strings.Join(dest.Hosts, ","),
+			"elastic_index": dest.Index,
+		}
+		if dest.Username != "" {
+			sender["auth_username"] = dest.Username
+			sender["auth_password"] = dest.Password
+		}
+		config.SenderConfig = []map[string]string{sender}
+	default:
+		return "", fmt.Errorf("destination %s is not supported by logkit", dest.Type)
+	}
+
+	data, err := json.Marshal(config)
+	return string(data), err
+}
@@ -4,9 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/fatsheep9146/kirklog/pkg/agent"
 	"github.com/fatsheep9146/kirklog/pkg/api"
 )
 
+// The grok pattern of the lines of cri container log, "<time> <stdout|stderr> <P|F> <message>"
+const CRIGrokPattern = "CRILOG %{NOTSPACE:time} %{WORD:stream} %{NOTSPACE:logtag} %{GREEDYDATA:log}"
+
 type MetricConfig struct {
 	MetricType string                 `json:"type"`
 	Attributes map[string]bool        `json:"attributes"`
@@ -45,17 +49,27 @@ type LogkitConf struct {
 	IsStopped     bool                     `json:"is_stopped,omitempty"`
 }
 
-func renderConfig(logSource *api.LogSource) (string, error) {
-	configRaw := logSource.Spec.Config
+func renderConfig(logSource *api.LogSource, secrets map[string]string) (string, error) {
+	configRaw, err := agent.RenderConfig(logSource, secrets, api.FormatJSON, compileSpec)
+	if err != nil {
+		return "", err
+	}
 	config := LogkitConf{}
 
-	err := json.Unmarshal([]byte(configRaw), &config)
+	err = json.Unmarshal([]byte(configRaw), &config)
 	if err != nil {
 		return "", err
 	}
 
 	config.RunnerInfo.RunnerName = getRunnerName(logSource)
 
+	if logSource.IsContainer() {
+		err = setContainerParser(&config, logSource)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	// Fix the readerconfig into mode dir and the right dir for log_path and meta_path
 	config.ReaderConfig["mode"] = "dir"
 	config.ReaderConfig["log_path"] = logSource.GetLogDir()
@@ -85,8 +99,37 @@ func renderConfig(logSource *api.LogSource) (string, error) {
 	return string(newConfigRaw), err
 }
 
+// Parse the lines of container log in the format of its runtime, the message is in the "log" field.
+// The parser of the config should be raw, and the lines can not be joined by the head pattern, since the lines
+// read are still in the format of runtime.
+func setContainerParser(config *LogkitConf, logSource *api.LogSource) error {
+	if config.ReaderConfig["head_pattern"] != "" {
+		return fmt.Errorf("head_pattern is not supported for the container log")
+	}
+	if config.ParserConf == nil {
+		config.ParserConf = map[string]string{"name": "parser"}
+	}
+	if parserType := config.ParserConf["type"]; parserType != "" && parserType != "raw" {
+		return fmt.Errorf("parser %s is not supported for the container log, it is parsed in the format of runtime", parserType)
+	}
+	if _, exist := config.ReaderConfig["valid_file_pattern"]; !exist {
+		config.ReaderConfig["valid_file_pattern"] = logSource.GetFilePatterns()[0]
+	}
+
+	switch logSource.Spec.ContainerLogFormat {
+	case api.ContainerLogDocker:
+		config.ParserConf["type"] = "json"
+	default:
+		config.ParserConf["type"] = "grok"
+		config.ParserConf["grok_patterns"] = "%{CRILOG}"
+		config.ParserConf["grok_custom_patterns"] = CRIGrokPattern
+	}
+	return nil
+}
+
+// The runner is named by logSource, which is unique among the log streams of pods and containers
 func getRunnerName(logSource *api.LogSource) string {
-	return fmt.Sprintf("%s_%s", logSource.Spec.VolumeMount, logSource.Spec.PodName)
+	return logSource.Meta.Name
 }
 
 func getConfigFileName(logSource *api.LogSource) string {
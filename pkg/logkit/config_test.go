@@ -2,6 +2,7 @@ package logkit
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 
 	"github.com/fatsheep9146/kirklog/pkg/api"
@@ -10,12 +11,14 @@ import (
 func TestLoadConfig(t *testing.T) {
 	testLogSource := &api.LogSource{
 		Meta: api.Meta{
-			Name: "test_applog_test-xxx-yyy",
+			Name: "deployment_test_applog_test-xxx-yyy",
 		},
 		Spec: api.LogSourceSpec{
-			PodName:     "test-xxx-yyy",
-			Namespace:   "test-ns",
-			VolumeMount: "applog",
+			PodName:        "test-xxx-yyy",
+			Namespace:      "test-ns",
+			ControllerName: "deployment_test",
+			Stream:         "applog",
+			VolumeMount:    "applog",
 			Config: `
 {
 	"name": "applog",
@@ -51,26 +54,26 @@ func TestLoadConfig(t *testing.T) {
 		},
 	}
 
-	configRaw, err := renderConfig(testLogSource)
+	configRaw, err := renderConfig(testLogSource, nil)
 	if err != nil {
-		t.Errorf(err.Error())
+		t.Error(err)
 	}
 	var config LogkitConf
 
 	err = json.Unmarshal([]byte(configRaw), &config)
 	if err != nil {
-		t.Errorf(err.Error())
+		t.Error(err)
 	}
 
 	if config.ReaderConfig["mode"] != "dir" {
 		t.Errorf("config reader config mode is not dir, is %v", config.ReaderConfig["mode"])
 	}
 
-	if config.ReaderConfig["log_path"] != "/applog/test-ns_test-xxx-yyy" {
+	if config.ReaderConfig["log_path"] != "/deployment_test_applog/test-ns_test-xxx-yyy" {
 		t.Errorf("config reader config log_path is wrong, is %v", config.ReaderConfig["log_path"])
 	}
 
-	if config.ReaderConfig["meta_path"] != "/applog/test-ns_test-xxx-yyy/.meta" {
+	if config.ReaderConfig["meta_path"] != "/deployment_test_applog/test-ns_test-xxx-yyy/.meta" {
 		t.Errorf("config reader config meta_path is wrong, is %v", config.ReaderConfig["meta_path"])
 	}
 
@@ -80,3 +83,170 @@ func TestLoadConfig(t *testing.T) {
 
 	t.Logf("%v", configRaw)
 }
+
+func TestRenderConfigTemplate(t *testing.T) {
+	testLogSource := &api.LogSource{
+		Meta: api.Meta{
+			Name: "deployment_test_applog_test-xxx-yyy",
+		},
+		Spec: api.LogSourceSpec{
+			PodName:        "test-xxx-yyy",
+			Namespace:      "test-ns",
+			NodeName:       "node-1",
+			PodLabels:      map[string]string{"app": "test"},
+			ControllerName: "deployment_test",
+			Stream:         "applog",
+			VolumeMount:    "applog",
+			Config: `
+{
+	"name": "applog",
+	"env_tag": "{{ .NodeName }}",
+	"reader": {},
+	"parser": {
+		"name": "applog_parser",
+		"type": "json"
+	},
+	"senders": [{
+		"name": "applog_sender",
+		"sender_type": "pandora",
+		"pandora_repo_name": "{{ .Namespace }}_{{ .Pod.Labels.app }}_{{ .Pod.Labels.tier }}",
+		"pandora_workflow_name": "{{ .Controller }}"
+	}]
+}`,
+		},
+	}
+
+	configRaw, err := renderConfig(testLogSource, nil)
+	if err != nil {
+		t.Fatalf("render config failed, err: %v", err)
+	}
+	var config LogkitConf
+
+	err = json.Unmarshal([]byte(configRaw), &config)
+	if err != nil {
+		t.Fatalf("unmarshal rendered config failed, err: %v", err)
+	}
+
+	if config.EnvTag != "node-1" {
+		t.Errorf("config env tag is wrong, is %v", config.EnvTag)
+	}
+
+	if config.SenderConfig[0]["pandora_repo_name"] != "test-ns_test_" {
+		t.Errorf("config sender repo name is wrong, is %v", config.SenderConfig[0]["pandora_repo_name"])
+	}
+
+	if config.SenderConfig[0]["pandora_workflow_name"] != "deployment_test" {
+		t.Errorf("config sender workflow name is wrong, is %v", config.SenderConfig[0]["pandora_workflow_name"])
+	}
+}
+
+func TestRenderConfigSecrets(t *testing.T) {
+	testLogSource := &api.LogSource{
+		Meta: api.Meta{
+			Name: "deployment_test_applog_test-xxx-yyy",
+		},
+		Spec: api.LogSourceSpec{
+			PodName:        "test-xxx-yyy",
+			Namespace:      "test-ns",
+			ControllerName: "deployment_test",
+			Stream:         "applog",
+			VolumeMount:    "applog",
+			Secrets: map[string]api.SecretKeySelector{
+				"pandora_ak": {Name: "pandora", Key: "ak"},
+				"pandora_sk": {Name: "pandora", Key: "sk"},
+			},
+			Config: `
+{
+	"name": "applog",
+	"reader": {},
+	"parser": {
+		"name": "applog_parser",
+		"type": "json"
+	},
+	"senders": [{
+		"name": "applog_sender",
+		"sender_type": "pandora",
+		"pandora_ak": "{{ .Secrets.pandora_ak }}",
+		"pandora_sk": "{{ .Secrets.pandora_sk }}"
+	}]
+}`,
+		},
+	}
+
+	configRaw, err := renderConfig(testLogSource, map[string]string{
+		"pandora_ak": "test-ak",
+		"pandora_sk": "test\"sk",
+	})
+	if err != nil {
+		t.Fatalf("render config failed, err: %v", err)
+	}
+	var config LogkitConf
+
+	err = json.Unmarshal([]byte(configRaw), &config)
+	if err != nil {
+		t.Fatalf("unmarshal rendered config failed, err: %v", err)
+	}
+
+	if config.SenderConfig[0]["pandora_ak"] != "test-ak" || config.SenderConfig[0]["pandora_sk"] != "test\"sk" {
+		t.Errorf("config sender secrets are not resolved or escaped")
+	}
+
+	if strings.Contains(testLogSource.Spec.Config, "test-ak") {
+		t.Errorf("secret values should not be kept in the log source")
+	}
+}
+
+func TestRenderCollectSpec(t *testing.T) {
+	testLogSource := &api.LogSource{
+		Meta: api.Meta{
+			Name: "test_applog_test-xxx-yyy",
+		},
+		Spec: api.LogSourceSpec{
+			PodName:     "test-xxx-yyy",
+			Namespace:   "test-ns",
+			Stream:      "applog",
+			VolumeMount: "applog",
+			PodLabels:   map[string]string{"team": "infra"},
+			Collect: &api.CollectSpec{
+				Paths:     []string{"*.log"},
+				Multiline: &api.MultilineSpec{Start: "^\\["},
+				Parser:    &api.ParserSpec{Type: api.ParserJSON},
+				Fields:    map[string]string{"team": "{{ .Pod.Labels.team }}", "env": "prod"},
+				Destination: api.DestinationSpec{
+					Type:  api.DestinationElasticsearch,
+					Hosts: []string{"http://es-1:9200", "http://es-2:9200"},
+					Index: "applog",
+				},
+			},
+		},
+	}
+
+	configRaw, err := renderConfig(testLogSource, nil)
+	if err != nil {
+		t.Fatalf("render collect spec failed, err: %v", err)
+	}
+	var config LogkitConf
+	err = json.Unmarshal([]byte(configRaw), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.ReaderConfig["valid_file_pattern"] != "*.log" || config.ReaderConfig["head_pattern"] != "^\\[" {
+		t.Errorf("reader of collect spec is wrong, is %v", config.ReaderConfig)
+	}
+	if config.ReaderConfig["log_path"] != testLogSource.GetLogDir() {
+		t.Errorf("log path should be fixed to the log dir, is %s", config.ReaderConfig["log_path"])
+	}
+	if config.ParserConf["type"] != "json" || config.ParserConf["labels"] != "env prod,team infra" {
+		t.Errorf("parser of collect spec is wrong, is %v", config.ParserConf)
+	}
+	if len(config.SenderConfig) != 1 || config.SenderConfig[0]["elastic_host"] != "http://es-1:9200,http://es-2:9200" {
+		t.Errorf("sender of collect spec is wrong, is %v", config.SenderConfig)
+	}
+
+	// The regexps of logkit are grok patterns, not the plain ones
+	testLogSource.Spec.Collect.Parser = &api.ParserSpec{Type: api.ParserRegex, Pattern: "(?P<level>\\w+)"}
+	if _, err := renderConfig(testLogSource, nil); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("expect regex parser rejected, got %v", err)
+	}
+}
@@ -1,21 +1,170 @@
 package logkit
 
-import "fmt"
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+)
 
 const (
 	LogkitManagerVolumeMountPath = "/logkit"
 	LogkitAgentVolumeMountPath   = "/logkit"
+
+	// The dir where the main config of logkit is mounted from configmap
+	LogkitMainConfDir  = "/etc/logkit"
+	LogkitMainConfFile = "logkit.conf"
+
+	// The port of the http api of logkit
+	LogkitAPIPort = 3000
+
+	DefaultLogkitImage = "wonderflow/logkit:latest"
 )
 
+// The main config of logkit, every agent reads the runner configs from the dir named by its pod name
+const logkitMainConf = `{
+	"max_procs": 1,
+	"debug_level": 1,
+	"bind_host": ":%d",
+	"confs_path": ["%s/${POD_NAME}"]
+}`
+
+// Replace the pod name in the main config and create the conf dir of this agent before starting logkit
+const logkitCommand = `mkdir -p %s/$POD_NAME && sed "s|\${POD_NAME}|$POD_NAME|g" %s/%s > /tmp/logkit.conf && exec /app/logkit -f /tmp/logkit.conf`
+
+// Create or update the configmap and deployment of logkit agents
 func (l *LogkitAgentManagerImpl) Deploy() error {
+	ownerReferences, err := agent.GetOwnerReferences(l.Cli, l.Namespace, l.Name)
+	if err != nil {
+		return err
+	}
 
-	fmt.Printf("Not Implemented yet")
+	err = agent.ApplyConfigMap(l.Cli, l.newConfigMap(ownerReferences))
+	if err != nil {
+		return err
+	}
+
+	return agent.ApplyWorkload(l.Cli, l.newDeployment(ownerReferences), l.DeployConfig, l.LogConfigs)
+}
+
+func (l *LogkitAgentManagerImpl) newConfigMap(ownerReferences []metav1.OwnerReference) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            l.DeployName,
+			Namespace:       l.Namespace,
+			Labels:          getDeployLabels(l.DeployName, l.Name),
+			OwnerReferences: ownerReferences,
+		},
+		Data: map[string]string{
+			LogkitMainConfFile: fmt.Sprintf(logkitMainConf, LogkitAPIPort, LogkitAgentVolumeMountPath),
+		},
+	}
+}
+
+func (l *LogkitAgentManagerImpl) newDeployment(ownerReferences []metav1.OwnerReference) *v1beta1.Deployment {
+	name := l.DeployName
+	labels := getDeployLabels(l.DeployName, l.Name)
+	replicas := l.DeployConfig.Replicas
+	image := l.DeployConfig.Image
+	if image == "" {
+		image = DefaultLogkitImage
+	}
+
+	confVolume, confVolumeMount, confContainers := agent.GetConfVolume("logkit-conf", l.getConfClaimName(), LogkitAgentVolumeMountPath, l.DeployConfig, "")
+	if l.EmptyConfDir {
+		confVolume.VolumeSource = v1.VolumeSource{
+			EmptyDir: &v1.EmptyDirVolumeSource{},
+		}
+		confContainers = nil
+	}
+
+	volumes, volumeMounts := agent.GetLogVolumes(l.LogConfigs)
+	volumes = append(volumes,
+		confVolume,
+		v1.Volume{
+			Name: "logkit-main-conf",
+			VolumeSource: v1.VolumeSource{
+				ConfigMap: &v1.ConfigMapVolumeSource{
+					LocalObjectReference: v1.LocalObjectReference{Name: name},
+				},
+			},
+		},
+	)
+	volumeMounts = append(volumeMounts,
+		confVolumeMount,
+		v1.VolumeMount{
+			Name:      "logkit-main-conf",
+			MountPath: LogkitMainConfDir,
+		},
+	)
+
+	return &v1beta1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       l.Namespace,
+			Labels:          labels,
+			OwnerReferences: ownerReferences,
+		},
+		Spec: v1beta1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: v1.PodSpec{
+					Containers: append([]v1.Container{
+						{
+							Name:    "logkit",
+							Image:   image,
+							Command: []string{"/bin/sh", "-c", fmt.Sprintf(logkitCommand, LogkitAgentVolumeMountPath, LogkitMainConfDir, LogkitMainConfFile)},
+							Env: []v1.EnvVar{
+								{
+									Name: "POD_NAME",
+									ValueFrom: &v1.EnvVarSource{
+										FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.name"},
+									},
+								},
+							},
+							Ports: []v1.ContainerPort{
+								{
+									Name:          "api",
+									ContainerPort: LogkitAPIPort,
+								},
+							},
+							Resources:    l.DeployConfig.Resources,
+							VolumeMounts: volumeMounts,
+						},
+					}, confContainers...),
+					Volumes: volumes,
+				},
+			},
+		},
+	}
+}
+
+func (l *LogkitAgentManagerImpl) getConfClaimName() string {
+	if l.DeployConfig.ConfClaimName != "" {
+		return l.DeployConfig.ConfClaimName
+	}
+	return fmt.Sprintf("%s-conf", l.DeployName)
+}
 
-	return nil
+// The workload of every agent type is named by the type, such as "logkit-<name>" and "logkit-api-<name>"
+func getDeployName(agentType agent.AgentType, name string) string {
+	return fmt.Sprintf("%s-%s", agentType, name)
 }
 
-func getDeployName(name string) string {
-	return fmt.Sprintf("logkit-%s", name)
+func getDeployLabels(deployName, name string) map[string]string {
+	return map[string]string{
+		"app":              deployName,
+		agent.ManagerLabel: name,
+	}
 }
 
 func getLogkitAgentConfDir(podname string) string {
@@ -0,0 +1,47 @@
+package logkit
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+)
+
+func TestDeploySideBySide(t *testing.T) {
+	cfg := &agent.AgentManagerConfig{
+		Name:      "kirklog",
+		Namespace: "kirklog-ns",
+	}
+	file := newLogkitAgentManager(cfg)
+	api := NewLogkitAPIAgentManager(cfg).(*LogkitAPIAgentManagerImpl)
+
+	fileDeploy, apiDeploy := file.newDeployment(nil), api.newDeployment(nil)
+	if fileDeploy.Name != "logkit-kirklog" || apiDeploy.Name != "logkit-api-kirklog" {
+		t.Errorf("agent types should be deployed in their own workloads, are %s and %s", fileDeploy.Name, apiDeploy.Name)
+	}
+	if file.newConfigMap(nil).Name == api.newConfigMap(nil).Name {
+		t.Errorf("agent types should have their own configmaps, both are %s", file.newConfigMap(nil).Name)
+	}
+
+	// The agents of one type are never listed by the other
+	for _, c := range []struct {
+		name     string
+		selector map[string]string
+		pod      map[string]string
+	}{
+		{"logkit", fileDeploy.Spec.Selector.MatchLabels, apiDeploy.Spec.Template.Labels},
+		{"logkit-api", apiDeploy.Spec.Selector.MatchLabels, fileDeploy.Spec.Template.Labels},
+	} {
+		if labels.SelectorFromSet(c.selector).Matches(labels.Set(c.pod)) {
+			t.Errorf("the selector %v of %s should not match the agents of the other type %v", c.selector, c.name, c.pod)
+		}
+	}
+
+	// The file based agents share the conf pvc with logmanager, the others do not mount it
+	for _, volume := range apiDeploy.Spec.Template.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == file.getConfClaimName() {
+			t.Errorf("logkit-api agents should not mount the conf pvc %s", file.getConfClaimName())
+		}
+	}
+}
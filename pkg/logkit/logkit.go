@@ -2,80 +2,130 @@ package logkit
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"strings"
 
 	"k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 
 	"github.com/fatsheep9146/kirklog/pkg/agent"
 	"github.com/fatsheep9146/kirklog/pkg/api"
+	"github.com/fatsheep9146/kirklog/pkg/secret"
 )
 
 type LogkitAgentManagerImpl struct {
-	Cli       *kubernetes.Clientset
-	Name      string
-	Namespace string
+	Cli             *kubernetes.Clientset
+	Name            string
+	Namespace       string
+	LogConfigs      []api.LogConfig
+	Secrets         *secret.Store
+	ConfFileOptions agent.FileOptions
+	DeployConfig    agent.DeployConfig
+
+	// The name of the workload, configmap and conf pvc of the agents, which is unique among the agent types
+	DeployName string
+
+	// The client of the http api of logkit
+	Client *Client
+
+	// Return the address of the api of one agent, "" if the agent is not running
+	AgentAddr func(agentName string) (string, error)
+
+	// The conf dir of agents is an emptyDir instead of the shared pvc, since the configs are not delivered by files
+	EmptyConfDir bool
+}
+
+func init() {
+	agent.Register(agent.Backend{
+		Type: agent.Logkit,
+		New:  NewLogkitAgentManager,
+		Schema: agent.ConfigSchema{
+			Format:      api.FormatJSON,
+			Description: "delivers configs by shared files watched by logkit",
+		},
+		Compile: compileSpec,
+	})
 }
 
 func NewLogkitAgentManager(cfg *agent.AgentManagerConfig) agent.AgentManager {
-	return &LogkitAgentManagerImpl{
-		Cli:       cfg.Cli,
-		Name:      cfg.Name,
-		Namespace: cfg.Namespace,
+	return newLogkitAgentManager(cfg)
+}
+
+func newLogkitAgentManager(cfg *agent.AgentManagerConfig) *LogkitAgentManagerImpl {
+	l := &LogkitAgentManagerImpl{
+		Cli:             cfg.Cli,
+		Name:            cfg.Name,
+		Namespace:       cfg.Namespace,
+		LogConfigs:      cfg.LogConfigs,
+		Secrets:         cfg.Secrets,
+		ConfFileOptions: cfg.ConfFileOptions,
+		DeployConfig:    cfg.DeployConfig,
+		DeployName:      getDeployName(agent.Logkit, cfg.Name),
+		Client:          NewClient(cfg.APIConfig),
 	}
+	l.AgentAddr = l.getAgentAddr
+	return l
 }
 
 func NewLogkitAgent(pod *v1.Pod) *agent.Agent {
 	return &agent.Agent{
 		Name:     pod.Name,
 		ConfPath: getLogkitAgentConfDir(pod.Name),
+		IP:       pod.Status.PodIP,
+		Node:     agent.GetAgentNode(pod),
 	}
 }
 
 func (l *LogkitAgentManagerImpl) List() ([]agent.Agent, error) {
 	agents := make([]agent.Agent, 0)
-	namespace := l.Namespace
-	name := l.Name
-	deployname := getDeployName(name)
 
-	deploy, err := l.Cli.ExtensionsV1beta1().Deployments(namespace).Get(deployname, metav1.GetOptions{})
+	pods, err := agent.ListAgentPods(l.Cli, l.Namespace, l.DeployName)
 	if err != nil {
 		return agents, err
 	}
-
-	labels := deploy.Spec.Selector.MatchLabels
-	labelSelectors := make([]string, 0)
-	for k, v := range labels {
-		labelSelectors = append(labelSelectors, fmt.Sprintf("%s=%s", k, v))
+	for i := range pods {
+		logAgent := NewLogkitAgent(&pods[i])
+		logAgent.Ordinal = i
+		agents = append(agents, *logAgent)
 	}
-	labelSelectorsStr := strings.Join(labelSelectors, ",")
 
-	pods, err := l.Cli.CoreV1().Pods(namespace).List(metav1.ListOptions{
-		LabelSelector: labelSelectorsStr,
-	})
+	return agents, nil
+}
 
-	for _, pod := range pods.Items {
-		agents = append(agents, *NewLogkitAgent(&pod))
-	}
+func (l *LogkitAgentManagerImpl) GetReplicas() (int32, error) {
+	return agent.GetDeploymentReplicas(l.Cli, l.Namespace, l.DeployName)
+}
 
-	return agents, nil
+func (l *LogkitAgentManagerImpl) Scale(replicas int32, victims []string) error {
+	return agent.ScaleDeployment(l.Cli, l.Namespace, l.DeployName, replicas, victims)
 }
 
-// Add the log config file of one logSource to logAgent agent
-func (l *LogkitAgentManagerImpl) AddConfig(logSource *api.LogSource, agent string) (string, error) {
+// Render the runner config of logSource with the secrets it references
+func (l *LogkitAgentManagerImpl) render(logSource *api.LogSource) (string, error) {
+	secrets := make(map[string]string)
+	if l.Secrets != nil {
+		var err error
+		secrets, err = l.Secrets.Resolve(logSource)
+		if err != nil {
+			return "", err
+		}
+	}
+	return renderConfig(logSource, secrets)
+}
+
+// Add the log config file of one logSource to logAgent agentName
+func (l *LogkitAgentManagerImpl) AddConfig(logSource *api.LogSource, agentName string) (string, error) {
 	// Generate the config file from logSource info
-	config, err := renderConfig(logSource)
+	config, err := l.render(logSource)
 	if err != nil {
 		return "", err
 	}
 
 	// Create log config to this log agent
-	filePath := fmt.Sprintf("%s/%s", getLogkitAgentConfDir(agent), getConfigFileName(logSource))
+	filePath := fmt.Sprintf("%s/%s", getLogkitAgentConfDir(agentName), getConfigFileName(logSource))
 
-	err = ioutil.WriteFile(filePath, []byte(config), 0644)
+	// Identical content is not written again, otherwise logkit reloads the runner
+	_, err = agent.WriteConfigFile(filePath, []byte(config), l.ConfFileOptions)
 	if err != nil {
 		return "", err
 	}
@@ -89,8 +139,9 @@ func (l *LogkitAgentManagerImpl) AddConfig(logSource *api.LogSource, agent strin
 func (l *LogkitAgentManagerImpl) DelConfig(logSource *api.LogSource, agent string) error {
 	filePath := fmt.Sprintf("%s/%s", getLogkitAgentConfDir(agent), getConfigFileName(logSource))
 
+	// The config file may be removed already by a previous try
 	err := os.Remove(filePath)
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
 	return nil
@@ -0,0 +1,262 @@
+package logkit
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+	"github.com/fatsheep9146/kirklog/pkg/secret"
+)
+
+const (
+	// The dir of the sidecar where the secret of runner configs is mounted
+	LogkitSidecarConfDir = "/logkit-sidecar"
+
+	// The api of the sidecar listens on localhost only, on an uncommon port since it shares the network of the pod
+	LogkitSidecarAPIPort = 39300
+
+	LogkitSidecarContainerName = "kirklog-logkit"
+	logkitSidecarConfVolume    = "kirklog-logkit-conf"
+)
+
+// The main config of the sidecar, it reads the runner configs from the secret mounted
+const logkitSidecarMainConf = `{"max_procs": 1, "debug_level": 1, "bind_host": "127.0.0.1:%d", "confs_path": ["%s"]}`
+
+// Write the main config before starting logkit, the sidecar has no configmap of logmanager in the namespace of pod
+const logkitSidecarCommand = `echo '%s' > /tmp/logkit.conf && exec /app/logkit -f /tmp/logkit.conf`
+
+// LogkitSidecarAgentManagerImpl manages the logkit sidecars injected into the pods by the webhook. Every sidecar
+// collects only the logSources of its own pod, and reads their runner configs from a secret of the pod, which
+// is written by logmanager and mounted into the sidecar as its config volume.
+type LogkitSidecarAgentManagerImpl struct {
+	Cli        *kubernetes.Clientset
+	Name       string
+	LogConfigs []api.LogConfig
+	Secrets    *secret.Store
+}
+
+func init() {
+	agent.Register(agent.Backend{
+		Type: agent.LogkitSidecar,
+		New:  NewLogkitSidecarAgentManager,
+		Schema: agent.ConfigSchema{
+			Format:      api.FormatJSON,
+			Description: "delivers configs by the secret of the pod to logkit injected as its sidecar by the webhook",
+		},
+		Compile: compileSpec,
+		Sidecar: NewLogkitSidecar,
+	})
+}
+
+func NewLogkitSidecarAgentManager(cfg *agent.AgentManagerConfig) agent.AgentManager {
+	return &LogkitSidecarAgentManagerImpl{
+		Cli:        cfg.Cli,
+		Name:       cfg.Name,
+		LogConfigs: cfg.LogConfigs,
+		Secrets:    cfg.Secrets,
+	}
+}
+
+// Build the logkit sidecar of pod, which mounts the log volumes of pod and the secret of runner configs
+func NewLogkitSidecar(pod *v1.Pod, logConfigs []api.LogConfig, config agent.DeployConfig) (v1.Container, []v1.Volume) {
+	image := config.Image
+	if image == "" {
+		image = DefaultLogkitImage
+	}
+	mainConf := fmt.Sprintf(logkitSidecarMainConf, LogkitSidecarAPIPort, LogkitSidecarConfDir)
+
+	// The secret is optional, the pod starts before logmanager delivers any config
+	optional := true
+	volumes := []v1.Volume{
+		{
+			Name: logkitSidecarConfVolume,
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{
+					SecretName: getSidecarSecretName(pod.Name),
+					Optional:   &optional,
+				},
+			},
+		},
+	}
+	volumeMounts := append(agent.GetPodLogVolumeMounts(pod, logConfigs), v1.VolumeMount{
+		Name:      logkitSidecarConfVolume,
+		MountPath: LogkitSidecarConfDir,
+		ReadOnly:  true,
+	})
+
+	container := v1.Container{
+		Name:         LogkitSidecarContainerName,
+		Image:        image,
+		Command:      []string{"/bin/sh", "-c", fmt.Sprintf(logkitSidecarCommand, mainConf)},
+		Resources:    config.Resources,
+		VolumeMounts: volumeMounts,
+	}
+	return container, volumes
+}
+
+// The sidecars are injected by the webhook, there is nothing to deploy
+func (l *LogkitSidecarAgentManagerImpl) Deploy() error {
+	return nil
+}
+
+// List the running pods injected with the sidecar of this logmanager in the namespaces of LogConfigs
+func (l *LogkitSidecarAgentManagerImpl) List() ([]agent.Agent, error) {
+	agents := make([]agent.Agent, 0)
+
+	visited := make(map[string]bool)
+	for _, logConfig := range l.LogConfigs {
+		if visited[logConfig.Namespace] {
+			continue
+		}
+		visited[logConfig.Namespace] = true
+
+		pods, err := l.Cli.CoreV1().Pods(logConfig.Namespace).List(metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("%s=%s", agent.SidecarLabel, l.Name),
+		})
+		if err != nil {
+			return agents, err
+		}
+		for _, pod := range pods.Items {
+			if pod.DeletionTimestamp != nil || pod.Status.Phase != v1.PodRunning {
+				continue
+			}
+			agents = append(agents, agent.Agent{
+				Name:    getSidecarAgentName(&pod),
+				IP:      pod.Status.PodIP,
+				Ordinal: len(agents),
+				Pod:     agent.GetPodKey(pod.Namespace, pod.Name),
+			})
+		}
+	}
+
+	return agents, nil
+}
+
+// Render the runner config of logSource with the secrets it references
+func (l *LogkitSidecarAgentManagerImpl) render(logSource *api.LogSource) (string, error) {
+	secrets := make(map[string]string)
+	if l.Secrets != nil {
+		var err error
+		secrets, err = l.Secrets.Resolve(logSource)
+		if err != nil {
+			return "", err
+		}
+	}
+	return renderConfig(logSource, secrets)
+}
+
+// Write the runner config of logSource into the secret of the pod of sidecar agentName. The secret is owned by
+// the pod, so it is removed with the pod, and it is taken over by the pod recreated with the same name.
+func (l *LogkitSidecarAgentManagerImpl) AddConfig(logSource *api.LogSource, agentName string) (string, error) {
+	config, err := l.render(logSource)
+	if err != nil {
+		return "", err
+	}
+	namespace, podName, uid, err := parseSidecarAgentName(agentName)
+	if err != nil {
+		return "", err
+	}
+
+	key := getConfigFileName(logSource)
+	owner := metav1.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Name:       podName,
+		UID:        types.UID(uid),
+	}
+	secrets := l.Cli.CoreV1().Secrets(namespace)
+	old, err := secrets.Get(getSidecarSecretName(podName), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = secrets.Create(&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            getSidecarSecretName(podName),
+				Namespace:       namespace,
+				Labels:          map[string]string{agent.ManagerLabel: l.Name},
+				OwnerReferences: []metav1.OwnerReference{owner},
+			},
+			Data: map[string][]byte{key: []byte(config)},
+		})
+	} else if err == nil {
+		// Identical content is not written again, otherwise logkit reloads the runner
+		owned := len(old.OwnerReferences) == 1 && old.OwnerReferences[0].UID == owner.UID
+		if !owned || !bytes.Equal(old.Data[key], []byte(config)) {
+			if old.Data == nil {
+				old.Data = make(map[string][]byte)
+			}
+			old.Data[key] = []byte(config)
+			old.OwnerReferences = []metav1.OwnerReference{owner}
+			_, err = secrets.Update(old)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// The conf path has the same layout as the file based one, so that the agent name can be parsed from it
+	confPath := fmt.Sprintf("%s/%s/%s", LogkitSidecarConfDir, agentName, key)
+	logSource.Status.ConfigStatus.Path = confPath
+
+	return confPath, nil
+}
+
+// Delete the runner config of logSource from the secret of the pod of sidecar agentName, the secret may be
+// removed with the pod already
+func (l *LogkitSidecarAgentManagerImpl) DelConfig(logSource *api.LogSource, agentName string) error {
+	namespace, podName, _, err := parseSidecarAgentName(agentName)
+	if err != nil {
+		return err
+	}
+
+	secrets := l.Cli.CoreV1().Secrets(namespace)
+	old, err := secrets.Get(getSidecarSecretName(podName), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	key := getConfigFileName(logSource)
+	if _, exist := old.Data[key]; !exist {
+		return nil
+	}
+	delete(old.Data, key)
+	_, err = secrets.Update(old)
+	return err
+}
+
+func (l *LogkitSidecarAgentManagerImpl) CheckLag(logSource *api.LogSource, agentName string) bool {
+	return true
+}
+
+func (l *LogkitSidecarAgentManagerImpl) GetAgentNameFromConf(confpath string) string {
+	strs := strings.Split(confpath, "/")
+	return strs[2]
+}
+
+// The sidecar agent is named by its pod and the uid, so that the pod recreated with the same name is another
+// agent, and the logSources are moved to it again
+func getSidecarAgentName(pod *v1.Pod) string {
+	return fmt.Sprintf("%s_%s_%s", pod.Namespace, pod.Name, pod.UID)
+}
+
+func parseSidecarAgentName(name string) (namespace, podName, uid string, err error) {
+	strs := strings.Split(name, "_")
+	if len(strs) != 3 {
+		return "", "", "", fmt.Errorf("invalid sidecar agent name %s", name)
+	}
+	return strs[0], strs[1], strs[2], nil
+}
+
+// The secret of the runner configs of the sidecar of pod, the name is known when the pod is admitted
+func getSidecarSecretName(podName string) string {
+	return fmt.Sprintf("logkit-sidecar-%s", podName)
+}
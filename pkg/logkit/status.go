@@ -0,0 +1,112 @@
+package logkit
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+// The status of one runner reported by logkit
+type RunnerStatus struct {
+	Name          string               `json:"name"`
+	Logpath       string               `json:"logpath"`
+	ReadDataSize  int64                `json:"readDataSize"`
+	ReadDataCount int64                `json:"readDataCount"`
+	ParserStats   StatsInfo            `json:"parserStats"`
+	SenderStats   map[string]StatsInfo `json:"senderStats"`
+	Error         string               `json:"error,omitempty"`
+	RunningStatus string               `json:"runningStatus"`
+}
+
+// The statistics of the parser or one sender of a runner
+type StatsInfo struct {
+	Errors    int64  `json:"errors"`
+	Success   int64  `json:"success"`
+	LastError string `json:"last_error"`
+}
+
+// Return the address of the api of logkit agentName, "" if the agent is removed or not running
+func (l *LogkitAgentManagerImpl) getAgentAddr(agentName string) (string, error) {
+	return agent.GetPodAddr(l.Cli, l.Namespace, agentName, LogkitAPIPort)
+}
+
+// Check whether logkit agentName stops the runner of logSource, the agent which is removed or not running
+// does not collect anything
+func (l *LogkitAgentManagerImpl) RunnerStopped(logSource *api.LogSource, agentName string) (bool, error) {
+	addr, err := l.AgentAddr(agentName)
+	if err != nil {
+		return false, err
+	}
+	if addr == "" {
+		return true, nil
+	}
+
+	status, err := l.Client.RunnerStatus(addr)
+	if err != nil {
+		return false, err
+	}
+	_, exist := status[getRunnerName(logSource)]
+	return !exist, nil
+}
+
+// Collect the status of the runners of logSources from logkit agentName
+func (l *LogkitAgentManagerImpl) CollectStatus(agentName string, logSources []*api.LogSource) (map[string]api.RunnerStatus, error) {
+	addr, err := l.AgentAddr(agentName)
+	if err != nil {
+		return nil, err
+	}
+	if addr == "" {
+		return nil, fmt.Errorf("log agent %s is not running", agentName)
+	}
+
+	status, err := l.Client.RunnerStatus(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := make(map[string]api.RunnerStatus)
+	for _, logSource := range logSources {
+		runner, exist := status[getRunnerName(logSource)]
+		if !exist {
+			continue
+		}
+		result[logSource.Meta.Name] = convertRunnerStatus(agentName, &runner, now)
+	}
+	return result, nil
+}
+
+func convertRunnerStatus(agentName string, runner *RunnerStatus, now time.Time) api.RunnerStatus {
+	status := api.RunnerStatus{
+		Agent:       agentName,
+		ReadLines:   runner.ReadDataCount,
+		ReadBytes:   runner.ReadDataSize,
+		ParseErrors: runner.ParserStats.Errors,
+		LastError:   runner.Error,
+		CollectedAt: now,
+	}
+	if status.LastError == "" {
+		status.LastError = runner.ParserStats.LastError
+	}
+
+	// A line is sent when all the senders succeed, so the least success of senders is counted
+	names := make([]string, 0, len(runner.SenderStats))
+	for name := range runner.SenderStats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		sender := runner.SenderStats[name]
+		if i == 0 || sender.Success < status.SentLines {
+			status.SentLines = sender.Success
+		}
+		status.SendErrors += sender.Errors
+		if sender.LastError != "" {
+			status.LastError = sender.LastError
+		}
+	}
+	return status
+}
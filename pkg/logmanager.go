@@ -3,9 +3,13 @@ package logmanager
 import (
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -17,7 +21,8 @@ import (
 
 	"github.com/fatsheep9146/kirklog/pkg/agent"
 	"github.com/fatsheep9146/kirklog/pkg/api"
-	"github.com/fatsheep9146/kirklog/pkg/logkit"
+	"github.com/fatsheep9146/kirklog/pkg/secret"
+	"github.com/fatsheep9146/kirklog/pkg/webhook"
 )
 
 type LogManagerConfig struct {
@@ -25,7 +30,58 @@ type LogManagerConfig struct {
 	Name         string `json:"name"`
 	Namespace    string
 	AgentType    string `json:"agent_type"`
-	Cli          *kubernetes.Clientset
+	Scheduler    string `json:"scheduler"`
+	// The mode and owner(uid:gid) of the config files written for log agents
+	ConfFileMode  string `json:"conf_file_mode"`
+	ConfFileOwner string `json:"conf_file_owner"`
+	// The interval to collect the orphaned config files of log agents, 0 means disabled
+	GCInterval    time.Duration `json:"gc_interval"`
+	GCGracePeriod time.Duration `json:"gc_grace_period"`
+	GCMode        string        `json:"gc_mode"`
+	// The settings used to deploy log agents
+	AgentImage         string `json:"agent_image"`
+	AgentReplicas      int32  `json:"agent_replicas"`
+	AgentMode          string `json:"agent_mode"`
+	AgentCPURequest    string `json:"agent_cpu_request"`
+	AgentMemoryRequest string `json:"agent_memory_request"`
+	AgentCPULimit      string `json:"agent_cpu_limit"`
+	AgentMemoryLimit   string `json:"agent_memory_limit"`
+	AgentConfClaim     string `json:"agent_conf_claim"`
+	AgentConfServer    string `json:"agent_conf_server"`
+	// The settings used to call the http api of log agents
+	AgentAPIUsername string        `json:"agent_api_username"`
+	AgentAPIPassword string        `json:"agent_api_password"`
+	AgentAPITimeout  time.Duration `json:"agent_api_timeout"`
+	// The settings used to scale log agents
+	AutoscaleMinReplicas     int32         `json:"autoscale_min_replicas"`
+	AutoscaleMaxReplicas     int32         `json:"autoscale_max_replicas"`
+	AutoscaleSourcesPerAgent int           `json:"autoscale_sources_per_agent"`
+	AutoscaleLagRatio        float64       `json:"autoscale_lag_ratio"`
+	AutoscaleInterval        time.Duration `json:"autoscale_interval"`
+	// The settings used to rebalance logSources among log agents
+	RebalanceThreshold float64       `json:"rebalance_threshold"`
+	RebalanceMaxMoves  int           `json:"rebalance_max_moves"`
+	RebalanceCooldown  time.Duration `json:"rebalance_cooldown"`
+	RebalanceInterval  time.Duration `json:"rebalance_interval"`
+	// The capacity of every log agent
+	AgentMaxSources   int     `json:"agent_max_sources"`
+	AgentMaxBytesRate float64 `json:"agent_max_bytes_rate"`
+	// The settings used to sample the log volume of logSources
+	SampleInterval time.Duration `json:"sample_interval"`
+	SampleAlpha    float64       `json:"sample_alpha"`
+	// The max time to wait for the old agent to stop the runner when moving a logSource
+	MoveStopTimeout time.Duration `json:"move_stop_timeout"`
+	// The interval to collect the status of runners from log agents
+	StatusInterval time.Duration `json:"status_interval"`
+	// The address to serve metrics
+	MetricsAddr string `json:"metrics_addr"`
+	// The address to serve the config files to the agents of daemonset, which are authenticated by the conf token
+	AgentConfAddr string `json:"agent_conf_addr"`
+	// The address, and the tls cert and key to serve the webhook which injects the sidecar log agents
+	WebhookAddr     string `json:"webhook_addr"`
+	WebhookCertFile string `json:"webhook_cert_file"`
+	WebhookKeyFile  string `json:"webhook_key_file"`
+	Cli             *kubernetes.Clientset
 }
 
 type LogManager struct {
@@ -44,8 +100,61 @@ type LogManager struct {
 	// the working queue to store the logSource wait to be processed
 	Queue workqueue.RateLimitingInterface
 
-	// the Agent used to manage the log agent components
-	LogAgentManager agent.AgentManager
+	// the AgentManagers used to manage the log agent components of every agent type
+	LogAgentManagers map[agent.AgentType]agent.AgentManager
+
+	// the Scheduler used to choose the log agent for logSources
+	Scheduler Scheduler
+
+	// the capacity of every log agent, the logSources which do not fit are pending
+	Capacity CapacityConfig
+
+	// the sampler of the log volume of logSources
+	Sampler *Sampler
+
+	// The store of the secrets referenced by the config of logSources
+	Secrets *secret.Store
+
+	// The interval, grace period and mode to collect the orphaned config files of log agents
+	GCInterval    time.Duration
+	GCGracePeriod time.Duration
+	GCMode        GCMode
+
+	// The config and interval to scale log agents
+	Autoscale         AutoscaleConfig
+	AutoscaleInterval time.Duration
+
+	// The agents being drained before scaling down, no logSource is scheduled to them
+	Draining map[string]bool
+
+	// The config and interval to rebalance logSources among log agents
+	Rebalance         RebalanceConfig
+	RebalanceInterval time.Duration
+
+	// The last time each logSource is moved by rebalance
+	LastMoved map[string]time.Time
+
+	// The max time to wait for the old agent to stop the runner when moving a logSource
+	MoveStopTimeout time.Duration
+
+	// The interval to collect the status of runners from log agents
+	StatusInterval time.Duration
+
+	// The copy of logSources served by the admin api, and the conf dirs of agents served by the conf server,
+	// which are updated in every sync
+	snapshotLock sync.RWMutex
+	snapshot     []LogSourceInfo
+	confDirs     map[string]string
+
+	// The address to serve metrics
+	MetricsAddr string
+
+	// The address to serve the config files to the agents of daemonset, and the token they authenticate with
+	ConfAddr  string
+	ConfToken string
+
+	// The webhook which injects the sidecar log agents into pods, nil if disabled
+	Webhook *webhook.Webhook
 
 	// The kubernetes client used to query info from k8s
 	Cli *kubernetes.Clientset
@@ -56,6 +165,20 @@ type Match struct {
 	PodName   string
 	AgentName string
 	ConfPath  string
+
+	// The reason why the logSource can not be scheduled to any agent, "" means it is not pending
+	PendingReason string
+
+	// The version of the secrets the config should be rendered with
+	Version string
+	// The version of the secrets the config file was rendered with
+	ConfVersion string
+
+	// The step of moving the logSource between agents, and the agents and start time of the move
+	MoveStep    string
+	MoveFrom    string
+	MoveTo      string
+	MoveStarted time.Time
 }
 
 func NewLogManagerConfig() *LogManagerConfig {
@@ -67,6 +190,12 @@ func NewLogManager(cfg *LogManagerConfig) *LogManager {
 		"func": "NewLogManager",
 	})
 
+	// The backend of the agent type is registered by the package linked into main
+	_, err := agent.GetBackend(agent.AgentType(cfg.AgentType))
+	if err != nil {
+		logger.Fatalf("Create AgentManager failed, err: %v", err)
+	}
+
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		panic(err.Error())
@@ -81,6 +210,10 @@ func NewLogManager(cfg *LogManagerConfig) *LogManager {
 	if err != nil {
 		logger.Fatalf("Load config file from dir %s failed, err: %v", cfg.LogConfigDir, err)
 	}
+	logConfigsOfType, err := groupLogConfigs(logConfigs, agent.AgentType(cfg.AgentType))
+	if err != nil {
+		logger.Fatalf("Group log configs by agent type failed, err: %v", err)
+	}
 	logConfigsMap := logConfigConvertFromSliceToMap(logConfigs)
 	logger.Info("Successfully load log configs")
 
@@ -92,42 +225,176 @@ func NewLogManager(cfg *LogManagerConfig) *LogManager {
 	}
 	logger.Info("Successfully get current log sources")
 
-	// Check and create LogAgentManager and the deployment of log collector if not exist
-	logAgentManager := newAgentManager(agent.AgentType(cfg.AgentType), &agent.AgentManagerConfig{
-		Name:       cfg.Name,
-		Namespace:  cfg.Namespace,
-		LogConfigs: logConfigs,
-		Cli:        cli,
-	})
-	logger.Infof("Successfully create AgentManager of type %s", cfg.AgentType)
+	secrets := secret.NewStore(cli)
 
-	logAgents, err := logAgentManager.List()
+	confFileOptions, err := agent.ParseFileOptions(cfg.ConfFileMode, cfg.ConfFileOwner)
+	if err != nil {
+		logger.Fatalf("Parse the options of config files failed, err: %v", err)
+	}
+	if cfg.SampleAlpha <= 0 || cfg.SampleAlpha > 1 {
+		logger.Fatalf("The alpha of sampling should be in (0, 1], is %v", cfg.SampleAlpha)
+	}
+	if GCMode(cfg.GCMode) != GCRemove && GCMode(cfg.GCMode) != GCQuarantine {
+		logger.Fatalf("Unknown gc mode %s", cfg.GCMode)
+	}
+	agentMode := agent.DeployMode(cfg.AgentMode)
+	if agentMode == "" {
+		agentMode = agent.DeployModeDeployment
+	}
+	if agentMode != agent.DeployModeDeployment && agentMode != agent.DeployModeDaemonSet {
+		logger.Fatalf("Unknown agent mode %s", cfg.AgentMode)
+	}
+	var confToken string
+	if agentMode == agent.DeployModeDaemonSet {
+		if cfg.AgentConfServer == "" || cfg.AgentConfAddr == "" {
+			logger.Fatal("The agents of daemonset sync their config files from the conf server, which needs both the agent conf server and the agent conf addr")
+		}
+		ownerReferences, err := agent.GetOwnerReferences(cli, cfg.Namespace, cfg.Name)
+		if err != nil {
+			logger.Fatalf("Get the owner of the conf token failed, err: %v", err)
+		}
+		confToken, err = agent.EnsureConfToken(cli, cfg.Namespace, agent.GetConfTokenSecretName(cfg.Name), ownerReferences)
+		if err != nil {
+			logger.Fatalf("Ensure the conf token of log agents failed, err: %v", err)
+		}
+	}
+
+	scheduler, err := newScheduler(cfg.Scheduler)
 	if err != nil {
-		logger.Fatal("List agent pods failed, err: %+v", err)
+		logger.Fatalf("Create scheduler failed, err: %v", err)
 	}
-	if len(logAgents) == 0 {
-		logger.Info("List no active log agent pods, then we should deploy a new log agent service")
+
+	agentResources, err := agent.ParseResources(cfg.AgentCPURequest, cfg.AgentMemoryRequest, cfg.AgentCPULimit, cfg.AgentMemoryLimit)
+	if err != nil {
+		logger.Fatalf("Parse the resources of log agents failed, err: %v", err)
+	}
+
+	// Create the LogAgentManager and apply the workload of log collector of every agent type
+	logAgentManagers := make(map[agent.AgentType]agent.AgentManager)
+	logAgents := make([]agent.Agent, 0)
+	sidecars := make([]webhook.Sidecar, 0)
+	for agentType, typeLogConfigs := range logConfigsOfType {
+		backend, _ := agent.GetBackend(agentType)
+		deployConfig := agent.DeployConfig{
+			Replicas:      cfg.AgentReplicas,
+			Resources:     agentResources,
+			ConfClaimName: cfg.AgentConfClaim,
+			Mode:          agentMode,
+			ConfServer:    cfg.AgentConfServer,
+		}
+		if agentMode == agent.DeployModeDaemonSet {
+			deployConfig.ConfTokenSecret = agent.GetConfTokenSecretName(cfg.Name)
+		}
+		// The image is only set for the default agent type, the others use the default image of their backends
+		if agentType == agent.AgentType(cfg.AgentType) {
+			deployConfig.Image = cfg.AgentImage
+		}
+		logAgentManager := backend.New(&agent.AgentManagerConfig{
+			Name:            cfg.Name,
+			Namespace:       cfg.Namespace,
+			LogConfigs:      typeLogConfigs,
+			Secrets:         secrets,
+			ConfFileOptions: confFileOptions,
+			DeployConfig:    deployConfig,
+			APIConfig: agent.APIConfig{
+				Username: cfg.AgentAPIUsername,
+				Password: cfg.AgentAPIPassword,
+				Timeout:  cfg.AgentAPITimeout,
+			},
+			Cli: cli,
+		})
+		logger.Infof("Successfully create AgentManager of type %s", agentType)
+
+		// The sidecars are injected into the pods matched by the label selectors, which are filled when listing logSources
+		if backend.Sidecar != nil {
+			sidecar := webhook.Sidecar{
+				AgentType:    agentType,
+				Inject:       backend.Sidecar,
+				DeployConfig: deployConfig,
+				LogConfigs:   make([]api.LogConfig, 0),
+			}
+			for _, logConfig := range logConfigsMap {
+				if agent.AgentType(logConfig.AgentType) == agentType {
+					sidecar.LogConfigs = append(sidecar.LogConfigs, *logConfig)
+				}
+			}
+			sidecars = append(sidecars, sidecar)
+		}
+
+		// The workload is always applied, so that the change of image, resources or log volumes is rolled out
 		err = logAgentManager.Deploy()
 		if err != nil {
-			logger.Fatal("Deploy new log agent service failed, err: %v", err)
+			logger.Fatalf("Deploy log agent service of type %s failed, err: %v", agentType, err)
 		}
-		logAgents, err = logAgentManager.List()
+		typeLogAgents, err := listLogAgents(agentType, logAgentManager)
 		if err != nil {
-			logger.Fatal("List agent pods failed, err: %+v", err)
+			logger.Fatalf("List agent pods of type %s failed, err: %+v", agentType, err)
 		}
+		logAgentManagers[agentType] = logAgentManager
+		logAgents = append(logAgents, typeLogAgents...)
 	}
 	logger.Info("Successfully list the log agents instance")
 
+	var sidecarWebhook *webhook.Webhook
+	if cfg.WebhookAddr != "" {
+		if len(sidecars) == 0 {
+			logger.Warn("The webhook is enabled, but no log config uses an agent type of sidecar")
+		}
+		sidecarWebhook = &webhook.Webhook{
+			Name:     cfg.Name,
+			Addr:     cfg.WebhookAddr,
+			CertFile: cfg.WebhookCertFile,
+			KeyFile:  cfg.WebhookKeyFile,
+			Sidecars: sidecars,
+		}
+	} else if len(sidecars) != 0 {
+		logger.Warn("Some log configs use an agent type of sidecar, but the webhook which injects the sidecars is disabled")
+	}
+
 	// ToDo: Restore the logsources map status from current situations in case this is a restart
 
 	return &LogManager{
-		LogConfigs:      logConfigsMap,
-		LogSources:      logSourceConvertFromSliceToMap(logSources),
-		LogAgents:       logAgentConvertFromSliceToMap(logAgents),
-		LogAgentManager: logAgentManager,
-		Match:           make(map[string]*Match),
-		Queue:           workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "logsource"),
-		Cli:             cli,
+		LogConfigs:       logConfigsMap,
+		LogSources:       logSourceConvertFromSliceToMap(logSources),
+		LogAgents:        logAgentConvertFromSliceToMap(logAgents),
+		LogAgentManagers: logAgentManagers,
+		Scheduler:        scheduler,
+		Capacity: CapacityConfig{
+			MaxSources:   cfg.AgentMaxSources,
+			MaxBytesRate: cfg.AgentMaxBytesRate,
+		},
+		Sampler: NewSampler(SampleConfig{
+			Interval: cfg.SampleInterval,
+			Alpha:    cfg.SampleAlpha,
+		}),
+		Match:         make(map[string]*Match),
+		Queue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "logsource"),
+		Secrets:       secrets,
+		GCInterval:    cfg.GCInterval,
+		GCGracePeriod: cfg.GCGracePeriod,
+		GCMode:        GCMode(cfg.GCMode),
+		Autoscale: AutoscaleConfig{
+			MinReplicas:     cfg.AutoscaleMinReplicas,
+			MaxReplicas:     cfg.AutoscaleMaxReplicas,
+			SourcesPerAgent: cfg.AutoscaleSourcesPerAgent,
+			LagRatio:        cfg.AutoscaleLagRatio,
+		},
+		AutoscaleInterval: cfg.AutoscaleInterval,
+		Draining:          make(map[string]bool),
+		Rebalance: RebalanceConfig{
+			Threshold: cfg.RebalanceThreshold,
+			MaxMoves:  cfg.RebalanceMaxMoves,
+			Cooldown:  cfg.RebalanceCooldown,
+		},
+		RebalanceInterval: cfg.RebalanceInterval,
+		LastMoved:         make(map[string]time.Time),
+		MoveStopTimeout:   cfg.MoveStopTimeout,
+		StatusInterval:    cfg.StatusInterval,
+		MetricsAddr:       cfg.MetricsAddr,
+		ConfAddr:          cfg.AgentConfAddr,
+		ConfToken:         confToken,
+		Webhook:           sidecarWebhook,
+		Cli:               cli,
 	}
 }
 
@@ -138,6 +405,24 @@ func (lm *LogManager) Run() {
 	})
 	logger.Info("Start the LogManager main loop")
 
+	// Serve the metrics of logmanager
+	if lm.MetricsAddr != "" {
+		go lm.serve()
+	}
+
+	// Serve the config files to the agents of daemonset
+	if lm.ConfAddr != "" && lm.ConfToken != "" {
+		go lm.serveConfs()
+	}
+
+	// Serve the webhook which injects the sidecar log agents
+	if lm.Webhook != nil {
+		go lm.Webhook.Run()
+	}
+
+	// Sample the log dirs of logSources out of the sync loop
+	go lm.Sampler.Run(stop)
+
 	// This function choose whether to rearrange the match relations between logSource and logAgent
 	go lm.syncInfo()
 
@@ -149,16 +434,6 @@ func (lm *LogManager) Run() {
 	<-stop
 }
 
-// Create an logAgentManager according to the type of agent.
-func newAgentManager(agentType agent.AgentType, cfg *agent.AgentManagerConfig) agent.AgentManager {
-
-	switch agentType {
-	case agent.Logkit:
-		return logkit.NewLogkitAgentManager(cfg)
-	}
-	return nil
-}
-
 // Loop function to sync the info about logSource and logAgent
 // If logSource or logAgent changes, use scheduling algorithm to
 func (lm *LogManager) syncInfo() {
@@ -170,7 +445,11 @@ func (lm *LogManager) syncInfo() {
 	// Get current logSources
 	listLogSourcesFunc := getListLogSourcesFunc(lm.Cli, lm.LogConfigs)
 	// Get current logAgents
-	listLogAgentsFunc := lm.LogAgentManager.List
+	listLogAgentsFunc := lm.listLogAgents
+	lastGC := time.Now()
+	lastAutoscale := time.Now()
+	lastRebalance := time.Now()
+	var lastStatus time.Time
 
 	for {
 		// Get current logSources
@@ -200,9 +479,29 @@ func (lm *LogManager) syncInfo() {
 		updateLogAgents(lm.LogAgents, logAgents, lm.Match)
 		logger.Info("Update logSources and logAgents succeeded")
 
+		// Update the version of the secrets referenced by logSources, the changed ones should be re-rendered
+		err = lm.Secrets.Sync(lm.LogSources)
+		if err != nil {
+			logger.Errorf("Sync secrets referenced by log sources failed, err: %v", err)
+		}
+		updateVersion(lm.LogSources, lm.Match, lm.configVersion)
+
+		// Update the log volume of logSources sampled, which is used to schedule by bytes/s
+		if lm.Sampler.Config.Interval > 0 {
+			lm.sampleLogSources()
+		}
+
+		// Scale the log agents, the logSources of draining agents are unassigned here
+		if lm.Autoscale.MaxReplicas > 0 && time.Since(lastAutoscale) >= lm.AutoscaleInterval {
+			lm.autoscale()
+			lastAutoscale = time.Now()
+		}
+
 		// Update the match relation between logSource and logAgent
-		logsources := updateMatch(lm.LogSources, lm.LogAgents, lm.Match)
+		candidates := schedulableAgents(lm.LogAgents, lm.Draining)
+		logsources := updateMatch(lm.LogSources, candidates, lm.Match, lm.Scheduler, &lm.Capacity)
 		logger.Info("Update match succeeded")
+		updatePendingMetrics(lm.Match)
 
 		// Enqueue the LogSources that are needed to be synced
 		for _, logsource := range logsources {
@@ -210,6 +509,28 @@ func (lm *LogManager) syncInfo() {
 			log.Debugf("Logsource %s is added to queue", logsource.Meta.Name)
 		}
 
+		// Move logSources from the most loaded agents to the least loaded ones
+		if lm.Rebalance.Threshold > 0 && time.Since(lastRebalance) >= lm.RebalanceInterval {
+			for _, key := range lm.rebalance(candidates) {
+				lm.Queue.Add(key)
+				log.Debugf("Logsource %s is added to queue for rebalance", key)
+			}
+			lastRebalance = time.Now()
+		}
+
+		// Collect the config files not owned by any logSource
+		if lm.GCInterval > 0 && time.Since(lastGC) >= lm.GCInterval {
+			lm.collectGarbage()
+			lastGC = time.Now()
+		}
+
+		// Collect the status of runners from log agents
+		if lm.StatusInterval > 0 && time.Since(lastStatus) >= lm.StatusInterval {
+			lm.collectStatus()
+			lastStatus = time.Now()
+		}
+		lm.updateSnapshot()
+
 		time.Sleep(3 * time.Second)
 	}
 }
@@ -253,7 +574,7 @@ func (lm *LogManager) sync(key string) (flag bool, err error) {
 	})
 
 	// Get logSource entry of this key from map
-	action := judgeAction(lm.Match[key])
+	action := judgeAction(lm.Match[key], lm.confAgentOf(key))
 	logger.Infof("Start handle with the logSource %s, action is %v", key, action)
 	// Info: Handle the logSource action
 	switch action {
@@ -263,6 +584,11 @@ func (lm *LogManager) sync(key string) (flag bool, err error) {
 		flag, err = lm.logSourceDelFunc(key)
 	case LogSourceMov:
 		flag, err = lm.logSourceMovFunc(key)
+	case LogSourceUpd:
+		flag, err = lm.logSourceUpdFunc(key)
+	case LogSourcePending:
+		// Nothing to do until it is scheduled by the sync loop
+		flag = true
 	}
 	logger.Infof("Handle logSource %s done", key)
 
@@ -275,13 +601,15 @@ func (lm *LogManager) removeLogSource(logSource *api.LogSource) error {
 		"func": "removeLogSource",
 		"key":  logSource.Meta.Name,
 	})
-	// Remove log dir
-	err := os.RemoveAll(logSource.GetLogDir())
-	if err != nil {
-		logger.Errorf("Remove log dir failed, err: %v", err)
-		return err
+	// Remove log dir, the node-local log dir is not mounted into logmanager and is left to the node
+	if !logSource.IsNodeLocal() {
+		err := os.RemoveAll(logSource.GetLogDir())
+		if err != nil {
+			logger.Errorf("Remove log dir failed, err: %v", err)
+			return err
+		}
+		logger.Infof("Remove log dir %s succeeded", logSource.GetLogDir())
 	}
-	logger.Infof("Remove log dir %s succeeded", logSource.GetLogDir())
 
 	key := logSource.Meta.Name
 	delete(lm.LogSources, key)
@@ -291,6 +619,99 @@ func (lm *LogManager) removeLogSource(logSource *api.LogSource) error {
 	return nil
 }
 
+// List the log agents of every agent type
+func (lm *LogManager) listLogAgents() ([]agent.Agent, error) {
+	logger := log.WithFields(log.Fields{
+		"func": "listLogAgents",
+	})
+
+	logAgents := make([]agent.Agent, 0)
+	listed := make(map[string]agent.AgentType)
+	for _, agentType := range lm.agentTypes() {
+		typeLogAgents, err := listLogAgents(agentType, lm.LogAgentManagers[agentType])
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range typeLogAgents {
+			// The backends deploying the same agents can not be used side by side, the agent is kept by the first type
+			if other, exist := listed[a.Name]; exist {
+				logger.Errorf("Log agent %s is listed by both agent type %s and %s, it is only used by %s", a.Name, other, agentType, other)
+				continue
+			}
+			listed[a.Name] = agentType
+			logAgents = append(logAgents, a)
+		}
+	}
+	return logAgents, nil
+}
+
+// Return the agent types of the AgentManagers in order
+func (lm *LogManager) agentTypes() []agent.AgentType {
+	types := make([]agent.AgentType, 0, len(lm.LogAgentManagers))
+	for t := range lm.LogAgentManagers {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i] < types[j]
+	})
+	return types
+}
+
+// List the log agents of one agent type, and mark them with the type
+func listLogAgents(agentType agent.AgentType, logAgentManager agent.AgentManager) ([]agent.Agent, error) {
+	logAgents, err := logAgentManager.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range logAgents {
+		logAgents[i].Type = agentType
+	}
+	return logAgents, nil
+}
+
+// Group the logConfigs by agent type, the logConfigs without agent type are set to the default type,
+// which always has a group even if no logConfig uses it
+func groupLogConfigs(logConfigs []api.LogConfig, defaultType agent.AgentType) (map[agent.AgentType][]api.LogConfig, error) {
+	groups := map[agent.AgentType][]api.LogConfig{
+		defaultType: make([]api.LogConfig, 0),
+	}
+	for i := range logConfigs {
+		if logConfigs[i].AgentType == "" {
+			logConfigs[i].AgentType = string(defaultType)
+		}
+		agentType := agent.AgentType(logConfigs[i].AgentType)
+		backend, err := agent.GetBackend(agentType)
+		if err != nil {
+			return nil, fmt.Errorf("log config %s: %v", logConfigs[i].GetControllerName(), err)
+		}
+		// The collect spec is compiled when the config is added, but the backend without compiler fails here
+		if backend.Compile == nil {
+			for _, stream := range logConfigs[i].GetStreams() {
+				if stream.Collect != nil {
+					return nil, fmt.Errorf("log config %s: agent type %s does not support the collect spec of log stream %s",
+						logConfigs[i].GetControllerName(), agentType, stream.Name)
+				}
+			}
+		}
+		// The sidecar only mounts the volumes of its pod, and its configs are delivered by the secret named by the pod,
+		// which is known by the webhook only for the pods of statefulset, the others are created with generateName
+		if backend.Sidecar != nil {
+			if logConfigs[i].Kind != "statefulset" {
+				return nil, fmt.Errorf("log config %s: agent type %s is a sidecar, which only collects the pods of statefulset, the pods of %s have no name when the sidecar is injected",
+					logConfigs[i].GetControllerName(), agentType, logConfigs[i].Kind)
+			}
+			for _, stream := range logConfigs[i].GetStreams() {
+				if stream.IsNodeLocal() {
+					return nil, fmt.Errorf("log config %s: agent type %s is a sidecar, which does not collect the node-local log stream %s",
+						logConfigs[i].GetControllerName(), agentType, stream.Name)
+				}
+			}
+		}
+		groups[agentType] = append(groups[agentType], logConfigs[i])
+	}
+	return groups, nil
+}
+
 // Create logconfig objects from the files under the path dir
 func loadLogConfig(path string) ([]api.LogConfig, error) {
 	logger := log.WithFields(log.Fields{
@@ -317,6 +738,11 @@ func loadLogConfig(path string) ([]api.LogConfig, error) {
 			logger.Errorf("Unmarshal file %s failed, err: %v", fmt.Sprintf("%s/%s", path, file.Name()), err)
 			continue
 		}
+		err = logConfig.Validate()
+		if err != nil {
+			logger.Errorf("Validate file %s failed, err: %v", fmt.Sprintf("%s/%s", path, file.Name()), err)
+			continue
+		}
 		logConfigs = append(logConfigs, *logConfig)
 	}
 
@@ -339,9 +765,24 @@ func updateLogSources(logSourcesMap map[string]*api.LogSource, logSources []api.
 	visited := make(map[string]bool)
 
 	for i, logSource := range logSources {
-		if _, exist := logSourcesMap[logSource.Meta.Name]; !exist {
+		if old, exist := logSourcesMap[logSource.Meta.Name]; !exist {
 			logger.Infof("Found a new logSource %s, add it to logSources map", logSource.Meta.Name)
 			logSourcesMap[logSource.Meta.Name] = &logSources[i]
+		} else if logSource.IsNodeLocal() && (old.Spec.NodeName != logSource.Spec.NodeName || old.GetLogDir() != logSource.GetLogDir()) {
+			// The pod is recreated with the same name, its node-local log is somewhere else now
+			logger.Infof("Found logSource %s moved from node %s to node %s", logSource.Meta.Name, old.Spec.NodeName, logSource.Spec.NodeName)
+			if m, exist := match[logSource.Meta.Name]; exist && old.Spec.NodeName != logSource.Spec.NodeName {
+				// It is re-scheduled to the agent on the new node, and moved there through the normal Move path
+				m.AgentName = ""
+			}
+			old.Spec = logSource.Spec
+		} else if old.Spec.NodeName != logSource.Spec.NodeName || !reflect.DeepEqual(old.Spec.PodLabels, logSource.Spec.PodLabels) ||
+			!reflect.DeepEqual(old.Spec.PodAnnotations, logSource.Spec.PodAnnotations) {
+			// The metadata of pod may be rendered into the config, which is re-rendered with the new version
+			logger.Infof("Found the labels, annotations or node of logSource %s changed", logSource.Meta.Name)
+			old.Spec.NodeName = logSource.Spec.NodeName
+			old.Spec.PodLabels = logSource.Spec.PodLabels
+			old.Spec.PodAnnotations = logSource.Spec.PodAnnotations
 		}
 		if _, exist := match[logSource.Meta.Name]; !exist {
 			logger.Infof("Found a new not matched logSource %s, add it to match", logSource.Meta.Name)
@@ -395,14 +836,25 @@ func updateLogAgents(logAgentsMap map[string]*agent.Agent, logAgents []agent.Age
 
 // Schedule Algorithm which is used to schedule the match relation between logSources and logAgents
 // Return the key of LogSource whose match relation is changed
-func updateMatch(logSourcesMap map[string]*api.LogSource, logAgentsMap map[string]*agent.Agent, match map[string]*Match) []api.LogSource {
+func updateMatch(logSourcesMap map[string]*api.LogSource, logAgentsMap map[string]*agent.Agent, match map[string]*Match, scheduler Scheduler, capacity *CapacityConfig) []api.LogSource {
 	logger := log.WithFields(log.Fields{
 		"func": "updateMatch",
 	})
 	logsources := make([]api.LogSource, 0)
 
+	// Visit the match in order, so that the scheduling result is deterministic
+	keys := make([]string, 0, len(match))
+	for k := range match {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// The schedule state is only built when there is a logSource to be scheduled
+	var state *ScheduleState
+
 	// First visit all match found all match need to be added into the queue
-	for k, m := range match {
+	for _, k := range keys {
+		m := match[k]
 		needAdded := false
 		needSchedule := false
 		if m.PodName != "" && m.AgentName == "" && m.ConfPath == "" {
@@ -418,11 +870,21 @@ func updateMatch(logSourcesMap map[string]*api.LogSource, logAgentsMap map[strin
 			logger.Infof("LogSource %s is a agent-changed logSource", k)
 			needAdded = true
 			needSchedule = true
+		} else if m.PodName != "" && m.AgentName != "" && m.ConfPath != "" && m.Version != m.ConfVersion {
+			logger.Infof("LogSource %s is a config-changed logSource", k)
+			needAdded = true
 		}
 
 		if needSchedule {
 			logger.Infof("LogSource %s needs to be scheduled or re-scheduled", k)
-			schedule(logSourcesMap[k], logAgentsMap, match)
+			if state == nil {
+				state = newScheduleState(logSourcesMap, logAgentsMap, match, capacity)
+			}
+			schedule(scheduler, logSourcesMap[k], state, match)
+			if m.PendingReason != "" {
+				// The pending logSource is not enqueued, since there is nothing to do with it
+				continue
+			}
 			logger.Infof("LogSource %s is scheduled or re-scheduled to agent %s", logSourcesMap[k].Meta.Name, match[k].AgentName)
 		}
 		if needAdded {
@@ -434,6 +896,36 @@ func updateMatch(logSourcesMap map[string]*api.LogSource, logAgentsMap map[strin
 	return logsources
 }
 
+// Return the version of the config of logSource, which changes with the secrets it references, with the metadata of
+// its pod rendered by the templates, and with its log dir if it is node-local, so that the config is re-rendered
+// when the pod is recreated on the same node
+func (lm *LogManager) configVersion(logSource *api.LogSource) string {
+	version := fmt.Sprintf("%s#%s", lm.Secrets.Version(logSource), podMetaVersion(logSource))
+	if logSource.IsNodeLocal() {
+		version = fmt.Sprintf("%s@%s", version, logSource.GetLogDir())
+	}
+	return version
+}
+
+// Return the hash of the node, labels and annotations of the pod of logSource
+func podMetaVersion(logSource *api.LogSource) string {
+	data, _ := json.Marshal([]interface{}{logSource.Spec.NodeName, logSource.Spec.PodLabels, logSource.Spec.PodAnnotations})
+	h := fnv.New32a()
+	h.Write(data)
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// Update the version of the secrets that the config of each logSource should be rendered with
+func updateVersion(logSourcesMap map[string]*api.LogSource, match map[string]*Match, versionFunc func(*api.LogSource) string) {
+	for k, m := range match {
+		logSource, exist := logSourcesMap[k]
+		if !exist || m.PodName == "" {
+			continue
+		}
+		m.Version = versionFunc(logSource)
+	}
+}
+
 // Return the function that can be used to return newest logSources info from existing logConfigs
 func getListLogSourcesFunc(cli *kubernetes.Clientset, logConfigs map[string]*api.LogConfig) func() ([]api.LogSource, error) {
 	logger := log.WithFields(log.Fields{
@@ -458,8 +950,15 @@ func getListLogSourcesFunc(cli *kubernetes.Clientset, logConfigs map[string]*api
 			podList, _ := cli.CoreV1().Pods(logConfig.Namespace).List(metav1.ListOptions{
 				LabelSelector: logConfig.LabelSelector,
 			})
+			streams := logConfig.GetStreams()
 			for _, pod := range podList.Items {
-				logSources = append(logSources, *api.NewLogSource(&pod, logConfig))
+				for i := range streams {
+					if streams[i].IsContainer() {
+						logSources = append(logSources, api.NewContainerLogSources(&pod, logConfig, &streams[i])...)
+						continue
+					}
+					logSources = append(logSources, *api.NewLogSource(&pod, logConfig, &streams[i]))
+				}
 			}
 		}
 
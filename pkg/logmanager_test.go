@@ -0,0 +1,41 @@
+package logmanager
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+func TestGroupLogConfigsSidecar(t *testing.T) {
+	sidecarType := agent.AgentType("test-sidecar")
+	if _, err := agent.GetBackend(sidecarType); err != nil {
+		agent.Register(agent.Backend{
+			Type: sidecarType,
+			New: func(cfg *agent.AgentManagerConfig) agent.AgentManager {
+				return newFakeAgentManager()
+			},
+			Sidecar: func(pod *v1.Pod, logConfigs []api.LogConfig, config agent.DeployConfig) (v1.Container, []v1.Volume) {
+				return v1.Container{}, nil
+			},
+		})
+	}
+
+	logConfigs := []api.LogConfig{
+		{Name: "test", Kind: "statefulset", VolumeMount: "applog", AgentType: string(sidecarType)},
+	}
+	groups, err := groupLogConfigs(logConfigs, sidecarType)
+	if err != nil || len(groups[sidecarType]) != 1 {
+		t.Fatalf("log config of statefulset should use the sidecar, groups: %v, err: %v", groups, err)
+	}
+
+	// The pods of deployment are created with generateName, the webhook does not know the secret of their configs
+	logConfigs[0].Kind = "deployment"
+	_, err = groupLogConfigs(logConfigs, sidecarType)
+	if err == nil || !strings.Contains(err.Error(), "only collects the pods of statefulset") {
+		t.Errorf("expect log config of deployment rejected by the sidecar, got %v", err)
+	}
+}
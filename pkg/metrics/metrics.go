@@ -0,0 +1,161 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The registry of all the metrics of logmanager, which are exposed in the prometheus text format
+var defaultRegistry = &registry{
+	vecs: make(map[string]*vec),
+}
+
+type registry struct {
+	lock sync.RWMutex
+	vecs map[string]*vec
+}
+
+// The metric with a set of label names, every combination of label values is a series
+type vec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	lock   sync.RWMutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+}
+
+// GaugeVec is a metric whose value can go up and down
+type GaugeVec struct {
+	*vec
+}
+
+// CounterVec is a metric whose value only goes up
+type CounterVec struct {
+	*vec
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{register(name, help, "gauge", labels)}
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{register(name, help, "counter", labels)}
+}
+
+func register(name, help, kind string, labels []string) *vec {
+	defaultRegistry.lock.Lock()
+	defer defaultRegistry.lock.Unlock()
+
+	if _, exist := defaultRegistry.vecs[name]; exist {
+		panic(fmt.Sprintf("metric %s is registered twice", name))
+	}
+	v := &vec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*series),
+	}
+	defaultRegistry.vecs[name] = v
+	return v
+}
+
+func (v *vec) get(labelValues []string) *series {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, but %d values are given", v.name, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, exist := v.series[key]
+	if !exist {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+// Remove all the series, which is used before setting the newest values of a gauge
+func (v *vec) Reset() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.series = make(map[string]*series)
+}
+
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.get(labelValues).value = value
+}
+
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.get(labelValues).value += value
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (v *vec) write(buf *bytes.Buffer) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	fmt.Fprintf(buf, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", v.name, v.kind)
+
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := v.series[k]
+		pairs := make([]string, 0, len(v.labels))
+		for i, label := range v.labels {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", label, s.labelValues[i]))
+		}
+		if len(pairs) == 0 {
+			fmt.Fprintf(buf, "%s %v\n", v.name, s.value)
+		} else {
+			fmt.Fprintf(buf, "%s{%s} %v\n", v.name, strings.Join(pairs, ","), s.value)
+		}
+	}
+}
+
+// Write all the metrics in the prometheus text format
+func Write(buf *bytes.Buffer) {
+	defaultRegistry.lock.RLock()
+	defer defaultRegistry.lock.RUnlock()
+
+	names := make([]string, 0, len(defaultRegistry.vecs))
+	for name := range defaultRegistry.vecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		defaultRegistry.vecs[name].write(buf)
+	}
+}
+
+// The http handler which exposes all the metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := &bytes.Buffer{}
+		Write(buf)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(buf.Bytes())
+	})
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	gauge := NewGaugeVec("test_pending", "The count of pending items.", "reason")
+	counter := NewCounterVec("test_moves_total", "The count of moves.")
+
+	gauge.Set(2, "no capacity")
+	gauge.Set(1, "no agent")
+	counter.Inc()
+	counter.Add(2)
+
+	buf := &bytes.Buffer{}
+	Write(buf)
+	expected := `# HELP test_moves_total The count of moves.
+# TYPE test_moves_total counter
+test_moves_total 3
+# HELP test_pending The count of pending items.
+# TYPE test_pending gauge
+test_pending{reason="no agent"} 1
+test_pending{reason="no capacity"} 2
+`
+	if buf.String() != expected {
+		t.Errorf("metrics output is wrong, is\n%s", buf.String())
+	}
+
+	gauge.Reset()
+	buf.Reset()
+	Write(buf)
+	if strings.Contains(buf.String(), "test_pending{") {
+		t.Errorf("gauge should be reset, is\n%s", buf.String())
+	}
+}
@@ -0,0 +1,132 @@
+package logmanager
+
+import (
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+// The config used to rebalance the logSources among log agents
+type RebalanceConfig struct {
+	// Rebalance when the count of logSources of the most loaded agent exceeds the average by this ratio,
+	// 0 means rebalance is disabled
+	Threshold float64
+
+	// The max count of logSources moved in one rebalance cycle
+	MaxMoves int
+
+	// The min interval between two rebalance moves of one logSource, so that its offset does not churn
+	Cooldown time.Duration
+}
+
+// The move of one logSource planned by rebalance
+type rebalanceMove struct {
+	Key  string
+	From string
+	To   string
+}
+
+// Move the logSources from the most loaded agents to the least loaded ones, for example when new agents join.
+// Return the keys of the moved logSources, which should be enqueued to be handled by the Move path.
+func (lm *LogManager) rebalance(logAgentsMap map[string]*agent.Agent) []string {
+	logger := log.WithFields(log.Fields{
+		"func": "rebalance",
+	})
+
+	// Forget the logSources which are removed
+	for k := range lm.LastMoved {
+		if _, exist := lm.Match[k]; !exist {
+			delete(lm.LastMoved, k)
+		}
+	}
+
+	// The agents of every agent type are balanced separately, the max moves are shared by all types
+	now := time.Now()
+	moves := make([]rebalanceMove, 0)
+	for _, agentType := range lm.agentTypes() {
+		cfg := lm.Rebalance
+		cfg.MaxMoves -= len(moves)
+		moves = append(moves, planRebalance(lm.LogSources, agentsOfType(logAgentsMap, agentType), lm.LogAgentManagers[agentType], lm.Match, &lm.Capacity, &cfg, lm.LastMoved, now)...)
+	}
+
+	keys := make([]string, 0, len(moves))
+	for _, move := range moves {
+		logger.Infof("Rebalance logSource %s from agent %s to agent %s", move.Key, move.From, move.To)
+		lm.Match[move.Key].AgentName = move.To
+		lm.LastMoved[move.Key] = now
+		keys = append(keys, move.Key)
+	}
+	return keys
+}
+
+// Plan the rebalance moves, only the logSources whose config is already on its agent and not in cooldown are moved
+func planRebalance(logSourcesMap map[string]*api.LogSource, logAgentsMap map[string]*agent.Agent, manager agent.AgentManager, match map[string]*Match, capacity *CapacityConfig, cfg *RebalanceConfig, lastMoved map[string]time.Time, now time.Time) []rebalanceMove {
+	moves := make([]rebalanceMove, 0)
+	if cfg.Threshold <= 0 || len(logAgentsMap) < 2 {
+		return moves
+	}
+
+	state := newScheduleState(logSourcesMap, logAgentsMap, match, capacity)
+	total := 0
+	for _, name := range state.Agents {
+		total += state.Count(name)
+		// Move the logSources in order of name, so that the plan is deterministic
+		sort.Slice(state.Sources[name], func(i, j int) bool {
+			return state.Sources[name][i].Meta.Name < state.Sources[name][j].Meta.Name
+		})
+	}
+	average := float64(total) / float64(len(state.Agents))
+
+	// A logSource is moved at most once in one plan
+	planned := make(map[string]bool)
+
+	for len(moves) < cfg.MaxMoves {
+		maxAgent, minAgent := state.Agents[0], state.Agents[0]
+		for _, name := range state.Agents {
+			if state.Count(name) > state.Count(maxAgent) {
+				maxAgent = name
+			}
+			if state.Count(name) < state.Count(minAgent) {
+				minAgent = name
+			}
+		}
+		if float64(state.Count(maxAgent)) <= average*(1+cfg.Threshold) || state.Count(maxAgent)-state.Count(minAgent) <= 1 {
+			break
+		}
+
+		var candidate *api.LogSource
+		for _, logSource := range state.Sources[maxAgent] {
+			key := logSource.Meta.Name
+			m := match[key]
+			if planned[key] || m.PodName == "" || m.ConfPath == "" || manager.GetAgentNameFromConf(m.ConfPath) != m.AgentName {
+				continue
+			}
+			if t, exist := lastMoved[key]; exist && now.Sub(t) < cfg.Cooldown {
+				continue
+			}
+			if !state.Fits(minAgent, logSource) {
+				continue
+			}
+			candidate = logSource
+			break
+		}
+		if candidate == nil {
+			break
+		}
+
+		state.Unassign(maxAgent, candidate)
+		state.Assign(minAgent, candidate)
+		planned[candidate.Meta.Name] = true
+		moves = append(moves, rebalanceMove{
+			Key:  candidate.Meta.Name,
+			From: maxAgent,
+			To:   minAgent,
+		})
+	}
+
+	return moves
+}
@@ -0,0 +1,90 @@
+package logmanager
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+func TestPlanRebalance(t *testing.T) {
+	logSources := make(map[string]*api.LogSource)
+	match := make(map[string]*Match)
+	for i := 0; i < 6; i++ {
+		logSource := newTestLogSource("deployment_test", fmt.Sprintf("pod-%d", i))
+		logSources[logSource.Meta.Name] = logSource
+		match[logSource.Meta.Name] = &Match{
+			PodName:   logSource.Spec.PodName,
+			AgentName: "logkit-a",
+			ConfPath:  "/logkit/logkit-a/" + logSource.Meta.Name,
+		}
+	}
+	agents := newTestAgents("logkit-a", "logkit-b", "logkit-c")
+	cfg := &RebalanceConfig{
+		Threshold: 0.2,
+		MaxMoves:  3,
+		Cooldown:  time.Hour,
+	}
+	now := time.Now()
+	lastMoved := map[string]time.Time{
+		"deployment_test_applog_pod-0": now.Add(-time.Minute),
+	}
+
+	moves := planRebalance(logSources, agents, newFakeAgentManager(), match, nil, cfg, lastMoved, now)
+	if len(moves) != 3 {
+		t.Fatalf("the move budget should be used up, moves are %v", moves)
+	}
+	expected := []rebalanceMove{
+		{Key: "deployment_test_applog_pod-1", From: "logkit-a", To: "logkit-b"},
+		{Key: "deployment_test_applog_pod-2", From: "logkit-a", To: "logkit-c"},
+		{Key: "deployment_test_applog_pod-3", From: "logkit-a", To: "logkit-b"},
+	}
+	for i := range expected {
+		if moves[i] != expected[i] {
+			t.Errorf("move %d should be %v, is %v", i, expected[i], moves[i])
+		}
+	}
+
+	// The logSource out of cooldown is moved in the next cycle, and then the agents are balanced
+	applyMoves(match, moves)
+	moves = planRebalance(logSources, agents, newFakeAgentManager(), match, nil, cfg, nil, now)
+	if len(moves) != 1 || moves[0].Key != "deployment_test_applog_pod-0" || moves[0].To != "logkit-c" {
+		t.Fatalf("the logSource out of cooldown should be moved, moves are %v", moves)
+	}
+
+	applyMoves(match, moves)
+	moves = planRebalance(logSources, agents, newFakeAgentManager(), match, nil, cfg, nil, now)
+	if len(moves) != 0 {
+		t.Errorf("balanced agents should not be rebalanced, moves are %v", moves)
+	}
+}
+
+func applyMoves(match map[string]*Match, moves []rebalanceMove) {
+	for _, move := range moves {
+		match[move.Key].AgentName = move.To
+		match[move.Key].ConfPath = "/logkit/" + move.To + "/" + move.Key
+	}
+}
+
+func TestPlanRebalanceConfOnOtherAgent(t *testing.T) {
+	logSources := make(map[string]*api.LogSource)
+	match := make(map[string]*Match)
+	for i := 0; i < 4; i++ {
+		logSource := newTestLogSource("deployment_test", fmt.Sprintf("pod-%d", i))
+		logSources[logSource.Meta.Name] = logSource
+		// The config is still on logkit-10, whose name contains logkit-1
+		match[logSource.Meta.Name] = &Match{
+			PodName:   logSource.Spec.PodName,
+			AgentName: "logkit-1",
+			ConfPath:  "/logkit/logkit-10/" + logSource.Meta.Name,
+		}
+	}
+	agents := newTestAgents("logkit-1", "logkit-10")
+	cfg := &RebalanceConfig{Threshold: 0.2, MaxMoves: 3, Cooldown: time.Hour}
+
+	moves := planRebalance(logSources, agents, newFakeAgentManager(), match, nil, cfg, nil, time.Now())
+	if len(moves) != 0 {
+		t.Errorf("the logSources whose config is not on their agent should not be moved, moves are %v", moves)
+	}
+}
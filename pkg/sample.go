@@ -0,0 +1,196 @@
+package logmanager
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/fatsheep9146/kirklog/pkg/api"
+	"github.com/fatsheep9146/kirklog/pkg/metrics"
+)
+
+var (
+	logSourceBytesRate = metrics.NewGaugeVec("kirklog_logsource_bytes_rate", "The moving average of bytes/s written to the log dir of logSource.", "logsource", "agent")
+)
+
+// The config used to sample the size of the log dirs of logSources
+type SampleConfig struct {
+	// The interval to sample, 0 means sampling is disabled
+	Interval time.Duration
+
+	// The weight of the newest sample in the moving average of bytes rate, in (0, 1]
+	Alpha float64
+}
+
+// The log dir of one logSource to sample, and the agent it is matched to
+type SampleTarget struct {
+	LogDir  string
+	MetaDir string
+	Agent   string
+}
+
+// Sampler walks the log dirs of logSources in its own goroutine, so that the sync loop is never blocked by the
+// shared volumes. The sync loop sets the log dirs to sample, and reads the status sampled.
+type Sampler struct {
+	Config SampleConfig
+
+	lock     sync.Mutex
+	targets  map[string]SampleTarget
+	statuses map[string]api.LogStatus
+
+	// The sizes of the log files of every logSource in the last sample, keyed by inode, only used by the sampler
+	files map[string]map[uint64]int64
+}
+
+func NewSampler(config SampleConfig) *Sampler {
+	return &Sampler{
+		Config:   config,
+		targets:  make(map[string]SampleTarget),
+		statuses: make(map[string]api.LogStatus),
+		files:    make(map[string]map[uint64]int64),
+	}
+}
+
+// Sample the log dirs every interval until stop is closed
+func (s *Sampler) Run(stop <-chan struct{}) {
+	if s.Config.Interval <= 0 {
+		return
+	}
+	wait.Until(s.sample, s.Config.Interval, stop)
+}
+
+// Set the log dirs of logSources to sample, the logSources not in targets are not sampled anymore
+func (s *Sampler) SetTargets(targets map[string]SampleTarget) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.targets = targets
+}
+
+// Return the status sampled of the logSource, the second result is false if it is not sampled yet
+func (s *Sampler) Status(key string) (api.LogStatus, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	status, exist := s.statuses[key]
+	return status, exist
+}
+
+func (s *Sampler) sample() {
+	logger := log.WithFields(log.Fields{
+		"func": "Sampler.sample",
+	})
+
+	// The maps are replaced as a whole, never changed in place
+	s.lock.Lock()
+	targets, last := s.targets, s.statuses
+	s.lock.Unlock()
+
+	statuses := make(map[string]api.LogStatus)
+	files := make(map[string]map[uint64]int64)
+	logSourceBytesRate.Reset()
+	for k, target := range targets {
+		status := last[k]
+		now := time.Now()
+		current := logDirFiles(target.LogDir, target.MetaDir)
+		size := int64(0)
+		for _, fileSize := range current {
+			size += fileSize
+		}
+		updateBytesRate(&status, size, fileGrowth(s.files[k], current), now, s.Config.Alpha)
+
+		statuses[k] = status
+		files[k] = current
+		logSourceBytesRate.Set(status.BytesRate, k, target.Agent)
+		logger.Debugf("LogSource %s has %d bytes, %.2f bytes/s", k, size, status.BytesRate)
+	}
+
+	s.lock.Lock()
+	s.statuses = statuses
+	s.lock.Unlock()
+	s.files = files
+}
+
+// Set the log dirs of logSources on the shared volumes to sample, and update the bytes rate of logSources
+// with the newest status sampled
+func (lm *LogManager) sampleLogSources() {
+	targets := make(map[string]SampleTarget)
+	for k, logSource := range lm.LogSources {
+		m, exist := lm.Match[k]
+		// The node-local logs are not reachable from logmanager
+		if !exist || m.PodName == "" || logSource.IsNodeLocal() {
+			continue
+		}
+		targets[k] = SampleTarget{
+			LogDir:  logSource.GetLogDir(),
+			MetaDir: logSource.GetLogMetaDir(),
+			Agent:   m.AgentName,
+		}
+		if status, exist := lm.Sampler.Status(k); exist {
+			logSource.Status.LogStatus.BytesRate = status.BytesRate
+			logSource.Status.LogStatus.Bytes = status.Bytes
+			logSource.Status.LogStatus.SampledAt = status.SampledAt
+		}
+	}
+	lm.Sampler.SetTargets(targets)
+}
+
+// Update the moving average of bytes rate with the newest size of log dir and the bytes written since the last
+// sample, the first sample is only the baseline
+func updateBytesRate(status *api.LogStatus, size, growth int64, now time.Time, alpha float64) {
+	if !status.SampledAt.IsZero() {
+		elapsed := now.Sub(status.SampledAt).Seconds()
+		if elapsed > 0 {
+			rate := float64(growth) / elapsed
+			if status.BytesRate == 0 {
+				status.BytesRate = rate
+			} else {
+				status.BytesRate = alpha*rate + (1-alpha)*status.BytesRate
+			}
+		}
+	}
+
+	status.Bytes = size
+	status.SampledAt = now
+}
+
+// Return the bytes written to the log files between two samples. The file renamed by rotation keeps its inode,
+// so only the bytes appended to it are counted, and the new file is counted from the beginning. The files
+// removed by cleaning never make the growth negative.
+func fileGrowth(last, current map[uint64]int64) int64 {
+	var growth int64
+	for inode, size := range current {
+		lastSize, exist := last[inode]
+		if !exist || size < lastSize {
+			// The new file, or the file truncated
+			growth += size
+		} else {
+			growth += size - lastSize
+		}
+	}
+	return growth
+}
+
+// Return the sizes of the log files under dir keyed by inode, the meta dir of log agents is excluded
+func logDirFiles(dir, metaDir string) map[uint64]int64 {
+	files := make(map[uint64]int64)
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() && path == metaDir {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			files[uint64(stat.Ino)] = info.Size()
+		}
+		return nil
+	})
+	return files
+}
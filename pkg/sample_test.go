@@ -0,0 +1,78 @@
+package logmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+func TestUpdateBytesRate(t *testing.T) {
+	status := &api.LogStatus{}
+	now := time.Now()
+
+	updateBytesRate(status, 1000, 1000, now, 0.5)
+	if status.BytesRate != 0 || status.Bytes != 1000 {
+		t.Errorf("the first sample should only be the baseline, status is %+v", status)
+	}
+
+	updateBytesRate(status, 2000, 1000, now.Add(10*time.Second), 0.5)
+	if status.BytesRate != 100 {
+		t.Errorf("bytes rate should be 100, is %v", status.BytesRate)
+	}
+
+	// The size of log dir goes down after the old log files are cleaned, the bytes written are still counted
+	updateBytesRate(status, 500, 3000, now.Add(20*time.Second), 0.5)
+	if status.BytesRate != 200 || status.Bytes != 500 {
+		t.Errorf("bytes rate should be the moving average 200, status is %+v", status)
+	}
+}
+
+func TestSamplerRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kirklog-sample")
+	if err != nil {
+		t.Fatalf("create temp dir failed, err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	metaDir := filepath.Join(dir, ".meta")
+	os.MkdirAll(metaDir, 0755)
+	path := filepath.Join(dir, "app.log")
+	ioutil.WriteFile(path, make([]byte, 100), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "app.log.1"), make([]byte, 50), 0644)
+	ioutil.WriteFile(filepath.Join(metaDir, "offset"), make([]byte, 10), 0644)
+
+	s := NewSampler(SampleConfig{Interval: time.Minute, Alpha: 1})
+	s.SetTargets(map[string]SampleTarget{"test": {LogDir: dir, MetaDir: metaDir}})
+	s.sample()
+	if status, exist := s.Status("test"); !exist || status.Bytes != 150 {
+		t.Fatalf("log dir size should be 150 without the meta dir, status is %+v", status)
+	}
+
+	// The log file is rotated after 20 bytes appended, the old rotated file is cleaned, and 30 bytes are written
+	// to the new file, the log dir is smaller but 50 bytes are written
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.Write(make([]byte, 20))
+	f.Close()
+	os.Remove(filepath.Join(dir, "app.log.1"))
+	os.Rename(path, filepath.Join(dir, "app.log.1"))
+	ioutil.WriteFile(path, make([]byte, 30), 0644)
+
+	last, _ := s.Status("test")
+	s.sample()
+	status, _ := s.Status("test")
+	elapsed := status.SampledAt.Sub(last.SampledAt).Seconds()
+	if status.Bytes != 150 || status.BytesRate != 50/elapsed {
+		t.Errorf("the bytes written across rotation should be 50, status is %+v", status)
+	}
+
+	// The logSource no longer sampled is dropped
+	s.SetTargets(map[string]SampleTarget{})
+	s.sample()
+	if _, exist := s.Status("test"); exist {
+		t.Errorf("the status of logSource not sampled should be dropped")
+	}
+}
@@ -1,53 +1,389 @@
 package logmanager
 
 import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
 	"github.com/fatsheep9146/kirklog/pkg/agent"
 	"github.com/fatsheep9146/kirklog/pkg/api"
-	log "github.com/sirupsen/logrus"
 )
 
-// Currently we schedule the logSource to the agent with the smallest count of logSources
-func schedule(logsource *api.LogSource, logAgentsMap map[string]*agent.Agent, match map[string]*Match) {
-	logger := log.WithFields(log.Fields{
-		"func": "schedule",
-	})
-	logger.Infof("Start scheduling the logsource %s", logsource.Meta.Name)
-	// logger.Debug("Candidate log agent includes:")
-	// for _, agent := range logAgentsMap {
-	// 	logger.Debugf("  Candidate log agent %s", agent.Name)
-	// }
+const (
+	LeastCountScheduler         = "least-count"
+	LeastBytesScheduler         = "least-bytes"
+	ConsistentHashScheduler     = "consistent-hash"
+	ControllerAffinityScheduler = "controller-affinity"
+
+	// The count of virtual nodes of every agent in the hash ring
+	hashRingReplicas = 100
+
+	// The reasons why a logSource is pending
+	PendingNoAgent     = "no log agent available"
+	PendingNoCapacity  = "no log agent has free capacity"
+	PendingNoAgentType = "no log agent of its agent type available"
+	PendingNoNodeAgent = "no log agent on the node of its pod"
+	PendingNoPodAgent  = "no log agent in its pod"
+)
+
+// The capacity of every log agent, 0 means unlimited
+type CapacityConfig struct {
+	// The max count of logSources of one agent
+	MaxSources int
+
+	// The max sum of the bytes rate of logSources of one agent
+	MaxBytesRate float64
+}
+
+// Scheduler chooses the log agent for a logSource
+type Scheduler interface {
+	// Return the name of the agent chosen for the logSource, "" if there is no candidate agent
+	Schedule(logSource *api.LogSource, state *ScheduleState) string
+}
 
-	counts := make(map[string]int)
+// Weigher returns the weight of a logSource, which is used to balance the agents
+type Weigher func(logSource *api.LogSource) float64
+
+// ScheduleState is the placement of logSources on the candidate agents, it is built once per sync
+// and updated after every scheduling decision, so that the match relation is not rescanned for every agent.
+type ScheduleState struct {
+	// The names of candidate agents, sorted so that ties are broken deterministically
+	Agents []string
+
+	// The logSources placed on every candidate agent
+	Sources map[string][]*api.LogSource
+
+	// The type of every candidate agent, a logSource is only placed on the agents of its agent type
+	types map[string]string
+
+	// The node of every candidate agent of daemonset, a node-local logSource is only placed on the agent of its node
+	nodes map[string]string
+
+	// The pod of every candidate sidecar agent, a sidecar agent only collects the logSources of its own pod
+	pods map[string]string
+
+	// The capacity of every agent, and the sum of the bytes rate of the logSources on every agent
+	capacity CapacityConfig
+	rates    map[string]float64
+
+	// The weights of agents, computed on first use
+	weigher Weigher
+	weights map[string]float64
+}
+
+func newScheduleState(logSourcesMap map[string]*api.LogSource, logAgentsMap map[string]*agent.Agent, match map[string]*Match, capacity *CapacityConfig) *ScheduleState {
+	state := &ScheduleState{
+		Agents:  make([]string, 0, len(logAgentsMap)),
+		Sources: make(map[string][]*api.LogSource),
+		types:   make(map[string]string),
+		nodes:   make(map[string]string),
+		pods:    make(map[string]string),
+		rates:   make(map[string]float64),
+	}
+	if capacity != nil {
+		state.capacity = *capacity
+	}
 
 	for k, a := range logAgentsMap {
-		count := 0
-		for _, m := range match {
-			if m.AgentName == a.Name {
-				count++
+		state.Agents = append(state.Agents, k)
+		state.Sources[k] = make([]*api.LogSource, 0)
+		state.types[k] = string(a.Type)
+		if a.Node != "" {
+			state.nodes[k] = a.Node
+		}
+		if a.Pod != "" {
+			state.pods[k] = a.Pod
+		}
+	}
+	sort.Strings(state.Agents)
+
+	for k, m := range match {
+		if _, exist := logAgentsMap[m.AgentName]; !exist {
+			continue
+		}
+		if logSource, exist := logSourcesMap[k]; exist {
+			state.Sources[m.AgentName] = append(state.Sources[m.AgentName], logSource)
+			state.rates[m.AgentName] += logSource.Status.LogStatus.BytesRate
+		}
+	}
+
+	return state
+}
+
+// Check whether the agent is of the agent type of the logSource, is on the node of the node-local logSource,
+// and has free capacity for it. The sidecar agent only fits the logSources of its pod, whatever the capacity is.
+func (s *ScheduleState) Fits(agent string, logSource *api.LogSource) bool {
+	if s.types[agent] != logSource.Spec.AgentType {
+		return false
+	}
+	if pod, exist := s.pods[agent]; exist {
+		return pod == getPodKey(logSource)
+	}
+	if logSource.IsNodeLocal() && s.nodes[agent] != logSource.Spec.NodeName {
+		return false
+	}
+	if s.capacity.MaxSources > 0 && s.Count(agent)+1 > s.capacity.MaxSources {
+		return false
+	}
+	if s.capacity.MaxBytesRate > 0 && s.rates[agent]+logSource.Status.LogStatus.BytesRate > s.capacity.MaxBytesRate {
+		return false
+	}
+	return true
+}
+
+// Check whether there is any candidate agent of the agent type
+func (s *ScheduleState) HasType(agentType string) bool {
+	for _, t := range s.types {
+		if t == agentType {
+			return true
+		}
+	}
+	return false
+}
+
+// Check whether there is any candidate agent of the agent type on the node
+func (s *ScheduleState) HasNodeAgent(agentType, node string) bool {
+	for name, t := range s.types {
+		if t == agentType && s.nodes[name] == node {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the key of the pod of the logSource, which is the same as the pod of its sidecar agent
+func getPodKey(logSource *api.LogSource) string {
+	return agent.GetPodKey(logSource.Spec.Namespace, logSource.Spec.PodName)
+}
+
+// Check whether the agents of the agent type are sidecars
+func (s *ScheduleState) IsSidecarType(agentType string) bool {
+	for name, t := range s.types {
+		if t == agentType && s.pods[name] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the count of logSources placed on the agent
+func (s *ScheduleState) Count(agent string) int {
+	return len(s.Sources[agent])
+}
+
+// Return the sum of the weights of logSources placed on the agent
+func (s *ScheduleState) Weight(agent string, weigher Weigher) float64 {
+	if s.weights == nil {
+		s.weigher = weigher
+		s.weights = make(map[string]float64)
+		for name, logSources := range s.Sources {
+			for _, logSource := range logSources {
+				s.weights[name] += weigher(logSource)
 			}
 		}
-		counts[k] = count
 	}
-	// for k, count := range counts {
-	// 	logger.Debugf("The count of agent %s is %d", k, count)
-	// }
+	return s.weights[agent]
+}
+
+// Place the logSource on the agent
+func (s *ScheduleState) Assign(agent string, logSource *api.LogSource) {
+	s.Sources[agent] = append(s.Sources[agent], logSource)
+	s.rates[agent] += logSource.Status.LogStatus.BytesRate
+	if s.weights != nil {
+		s.weights[agent] += s.weigher(logSource)
+	}
+}
+
+// Remove the logSource from the agent
+func (s *ScheduleState) Unassign(agent string, logSource *api.LogSource) {
+	logSources := s.Sources[agent]
+	for i := range logSources {
+		if logSources[i].Meta.Name == logSource.Meta.Name {
+			s.Sources[agent] = append(logSources[:i:i], logSources[i+1:]...)
+			break
+		}
+	}
+	s.rates[agent] -= logSource.Status.LogStatus.BytesRate
+	if s.weights != nil {
+		s.weights[agent] -= s.weigher(logSource)
+	}
+}
+
+// Create the scheduler by name
+func newScheduler(name string) (Scheduler, error) {
+	switch name {
+	case LeastCountScheduler, "":
+		return &leastCountScheduler{}, nil
+	case LeastBytesScheduler:
+		return &leastBytesScheduler{weigher: bytesRateWeigher}, nil
+	case ConsistentHashScheduler:
+		return &consistentHashScheduler{}, nil
+	case ControllerAffinityScheduler:
+		return &controllerAffinityScheduler{}, nil
+	}
+	return nil, fmt.Errorf("unknown scheduler %s, should be one of [%s]", name, strings.Join([]string{
+		LeastCountScheduler, LeastBytesScheduler, ConsistentHashScheduler, ControllerAffinityScheduler,
+	}, ", "))
+}
+
+// Schedule the logSource to the agent with the smallest count of logSources
+type leastCountScheduler struct{}
+
+func (s *leastCountScheduler) Schedule(logSource *api.LogSource, state *ScheduleState) string {
+	minAgent := ""
+	for _, name := range state.Agents {
+		if !state.Fits(name, logSource) {
+			continue
+		}
+		if minAgent == "" || state.Count(name) < state.Count(minAgent) {
+			minAgent = name
+		}
+	}
+	return minAgent
+}
+
+// Schedule the logSource to the agent with the smallest sum of weights, which is the bytes/s of logs by default
+type leastBytesScheduler struct {
+	weigher Weigher
+}
+
+func (s *leastBytesScheduler) Schedule(logSource *api.LogSource, state *ScheduleState) string {
+	minAgent := ""
+	for _, name := range state.Agents {
+		if !state.Fits(name, logSource) {
+			continue
+		}
+		if minAgent == "" || state.Weight(name, s.weigher) < state.Weight(minAgent, s.weigher) {
+			minAgent = name
+		}
+	}
+	return minAgent
+}
+
+// Schedule the logSource by the consistent hashing of its name, so that the placement keeps stable
+// across the restarts of logmanager, and only a few logSources are affected when agents change.
+type consistentHashScheduler struct {
+	// The hash ring of the current agents
+	agents string
+	ring   []uint32
+	owners map[uint32]string
+}
+
+func (s *consistentHashScheduler) Schedule(logSource *api.LogSource, state *ScheduleState) string {
+	if len(state.Agents) == 0 {
+		return ""
+	}
+
+	agents := strings.Join(state.Agents, ",")
+	if agents != s.agents {
+		s.buildRing(state.Agents)
+		s.agents = agents
+	}
 
-	var minCount int
-	var minAgent string = ""
+	hash := hashKey(logSource.Meta.Name)
+	i := sort.Search(len(s.ring), func(i int) bool {
+		return s.ring[i] >= hash
+	})
+
+	// Walk the ring clockwise until an agent with free capacity is found
+	for j := 0; j < len(s.ring); j++ {
+		owner := s.owners[s.ring[(i+j)%len(s.ring)]]
+		if state.Fits(owner, logSource) {
+			return owner
+		}
+	}
+	return ""
+}
+
+func (s *consistentHashScheduler) buildRing(agents []string) {
+	s.ring = make([]uint32, 0, len(agents)*hashRingReplicas)
+	s.owners = make(map[uint32]string)
 
-	for k, v := range counts {
-		if minAgent == "" {
-			minAgent = k
-			minCount = v
-		} else {
-			if minCount > counts[k] {
-				minAgent = k
-				minCount = v
+	for _, name := range agents {
+		for i := 0; i < hashRingReplicas; i++ {
+			hash := hashKey(fmt.Sprintf("%s#%d", name, i))
+			// The agents are sorted, so the collided virtual node always belongs to the same agent
+			if _, exist := s.owners[hash]; exist {
+				continue
 			}
+			s.owners[hash] = name
+			s.ring = append(s.ring, hash)
 		}
 	}
+	sort.Slice(s.ring, func(i, j int) bool {
+		return s.ring[i] < s.ring[j]
+	})
+}
 
-	logger.Infof("Log agent %s is chosen", minAgent)
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// Schedule the logSource to the agent which holds the most logSources of the same controller,
+// so that the pods of one workload are co-located, the logSource of a new workload goes to the least-count agent.
+type controllerAffinityScheduler struct{}
+
+func (s *controllerAffinityScheduler) Schedule(logSource *api.LogSource, state *ScheduleState) string {
+	bestAgent, bestCount := "", 0
+	for _, name := range state.Agents {
+		if !state.Fits(name, logSource) {
+			continue
+		}
+		count := 0
+		for _, placed := range state.Sources[name] {
+			if placed.Spec.ControllerName == logSource.Spec.ControllerName {
+				count++
+			}
+		}
+		if count > bestCount || (count == bestCount && count > 0 && state.Count(name) < state.Count(bestAgent)) {
+			bestAgent, bestCount = name, count
+		}
+	}
+
+	if bestAgent == "" {
+		return (&leastCountScheduler{}).Schedule(logSource, state)
+	}
+	return bestAgent
+}
+
+// Weigh the logSource by the rate of bytes written to its log dir, the logSource not sampled yet
+// costs one byte/s, so that the empty ones are balanced by count
+func bytesRateWeigher(logSource *api.LogSource) float64 {
+	return logSource.Status.LogStatus.BytesRate + 1
+}
+
+// Schedule the logSource to one of the agents, and update the match relation and the schedule state
+func schedule(scheduler Scheduler, logsource *api.LogSource, state *ScheduleState, match map[string]*Match) {
+	logger := log.WithFields(log.Fields{
+		"func": "schedule",
+	})
+	logger.Infof("Start scheduling the logsource %s", logsource.Meta.Name)
+
+	m := match[logsource.Meta.Name]
+	agentName := scheduler.Schedule(logsource, state)
+	m.AgentName = agentName
+	if agentName == "" {
+		// The logSource is pending, and it is scheduled again in the next sync
+		m.PendingReason = PendingNoCapacity
+		if len(state.Agents) == 0 {
+			m.PendingReason = PendingNoAgent
+		} else if !state.HasType(logsource.Spec.AgentType) {
+			m.PendingReason = PendingNoAgentType
+		} else if state.IsSidecarType(logsource.Spec.AgentType) {
+			m.PendingReason = PendingNoPodAgent
+		} else if logsource.IsNodeLocal() && !state.HasNodeAgent(logsource.Spec.AgentType, logsource.Spec.NodeName) {
+			m.PendingReason = PendingNoNodeAgent
+		}
+		logger.Warnf("LogSource %s is pending, reason: %s", logsource.Meta.Name, m.PendingReason)
+		return
+	}
 
-	match[logsource.Meta.Name].AgentName = minAgent
+	state.Assign(agentName, logsource)
+	m.PendingReason = ""
+	logger.Infof("Log agent %s is chosen", agentName)
 }
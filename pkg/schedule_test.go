@@ -0,0 +1,324 @@
+package logmanager
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+	"github.com/fatsheep9146/kirklog/pkg/secret"
+)
+
+func newTestLogSource(controller, pod string) *api.LogSource {
+	return &api.LogSource{
+		Meta: api.Meta{
+			Name: fmt.Sprintf("%s_applog_%s", controller, pod),
+		},
+		Spec: api.LogSourceSpec{
+			PodName:        pod,
+			Namespace:      "test-ns",
+			ControllerName: controller,
+			Stream:         "applog",
+			VolumeMount:    "applog",
+		},
+	}
+}
+
+func newTestAgents(names ...string) map[string]*agent.Agent {
+	agents := make(map[string]*agent.Agent)
+	for _, name := range names {
+		agents[name] = &agent.Agent{Name: name}
+	}
+	return agents
+}
+
+func TestLeastCountScheduler(t *testing.T) {
+	logSources := make(map[string]*api.LogSource)
+	match := make(map[string]*Match)
+	for i := 0; i < 5; i++ {
+		logSource := newTestLogSource("deployment_test", fmt.Sprintf("pod-%d", i))
+		logSources[logSource.Meta.Name] = logSource
+		match[logSource.Meta.Name] = &Match{PodName: logSource.Spec.PodName}
+	}
+	match["deployment_test_applog_pod-0"].AgentName = "logkit-a"
+
+	scheduler, _ := newScheduler(LeastCountScheduler)
+	updateMatch(logSources, newTestAgents("logkit-a", "logkit-b", "logkit-c"), match, scheduler, nil)
+
+	expected := map[string]string{
+		"deployment_test_applog_pod-0": "logkit-a",
+		"deployment_test_applog_pod-1": "logkit-b",
+		"deployment_test_applog_pod-2": "logkit-c",
+		"deployment_test_applog_pod-3": "logkit-a",
+		"deployment_test_applog_pod-4": "logkit-b",
+	}
+	for k, agentName := range expected {
+		if match[k].AgentName != agentName {
+			t.Errorf("logSource %s should be scheduled to %s, is %s", k, agentName, match[k].AgentName)
+		}
+	}
+}
+
+func TestLeastBytesScheduler(t *testing.T) {
+	weights := map[string]float64{
+		"deployment_test_applog_pod-0": 100,
+		"deployment_test_applog_pod-1": 10,
+		"deployment_test_applog_pod-2": 10,
+	}
+	scheduler := &leastBytesScheduler{
+		weigher: func(logSource *api.LogSource) float64 {
+			return weights[logSource.Meta.Name]
+		},
+	}
+
+	state := newScheduleState(nil, newTestAgents("logkit-a", "logkit-b"), nil, nil)
+	for _, pod := range []string{"pod-0", "pod-1", "pod-2"} {
+		logSource := newTestLogSource("deployment_test", pod)
+		state.Assign(scheduler.Schedule(logSource, state), logSource)
+	}
+
+	if state.Count("logkit-a") != 1 || state.Count("logkit-b") != 2 {
+		t.Errorf("the heavy logSource should be alone, counts are %d and %d", state.Count("logkit-a"), state.Count("logkit-b"))
+	}
+}
+
+func TestConsistentHashScheduler(t *testing.T) {
+	scheduler := &consistentHashScheduler{}
+	agents := newTestAgents("logkit-a", "logkit-b", "logkit-c")
+
+	placement := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		logSource := newTestLogSource("deployment_test", fmt.Sprintf("pod-%d", i))
+		agentName := scheduler.Schedule(logSource, newScheduleState(nil, agents, nil, nil))
+		placement[logSource.Meta.Name] = agentName
+		counts[agentName]++
+	}
+	for name, count := range counts {
+		if count < 50 {
+			t.Errorf("agent %s only gets %d logSources", name, count)
+		}
+	}
+
+	// A new scheduler, like the one after restart, should keep the placement
+	restarted := &consistentHashScheduler{}
+	for k, agentName := range placement {
+		logSource := &api.LogSource{Meta: api.Meta{Name: k}}
+		if restarted.Schedule(logSource, newScheduleState(nil, agents, nil, nil)) != agentName {
+			t.Errorf("logSource %s should be kept on %s after restart", k, agentName)
+		}
+	}
+
+	// Only the logSources of the removed agent are moved
+	delete(agents, "logkit-c")
+	for k, agentName := range placement {
+		logSource := &api.LogSource{Meta: api.Meta{Name: k}}
+		if newAgent := restarted.Schedule(logSource, newScheduleState(nil, agents, nil, nil)); agentName != "logkit-c" && newAgent != agentName {
+			t.Errorf("logSource %s should be kept on %s, is moved to %s", k, agentName, newAgent)
+		}
+	}
+}
+
+func TestControllerAffinityScheduler(t *testing.T) {
+	scheduler := &controllerAffinityScheduler{}
+	state := newScheduleState(nil, newTestAgents("logkit-a", "logkit-b"), nil, nil)
+
+	for i := 0; i < 3; i++ {
+		for _, controller := range []string{"deployment_foo", "deployment_bar"} {
+			logSource := newTestLogSource(controller, fmt.Sprintf("%s-pod-%d", controller, i))
+			state.Assign(scheduler.Schedule(logSource, state), logSource)
+		}
+	}
+
+	for _, name := range state.Agents {
+		controllers := make(map[string]bool)
+		for _, logSource := range state.Sources[name] {
+			controllers[logSource.Spec.ControllerName] = true
+		}
+		if len(controllers) != 1 || state.Count(name) != 3 {
+			t.Errorf("agent %s should hold the 3 logSources of one controller, holds %d of %v", name, state.Count(name), controllers)
+		}
+	}
+
+	if scheduler.Schedule(newTestLogSource("deployment_foo", "pod-x"), newScheduleState(nil, nil, nil, nil)) != "" {
+		t.Errorf("no agent should be chosen when there is no candidate")
+	}
+}
+
+func TestScheduleCapacity(t *testing.T) {
+	logSources := make(map[string]*api.LogSource)
+	match := make(map[string]*Match)
+	for i := 0; i < 5; i++ {
+		logSource := newTestLogSource("deployment_test", fmt.Sprintf("pod-%d", i))
+		logSources[logSource.Meta.Name] = logSource
+		match[logSource.Meta.Name] = &Match{PodName: logSource.Spec.PodName}
+	}
+	scheduler, _ := newScheduler(LeastCountScheduler)
+
+	enqueued := updateMatch(logSources, newTestAgents(), match, scheduler, nil)
+	if len(enqueued) != 0 {
+		t.Errorf("pending logSources should not be enqueued, enqueued %d", len(enqueued))
+	}
+	for k, m := range match {
+		if m.PendingReason != PendingNoAgent || judgeAction(m, "") != LogSourcePending {
+			t.Errorf("logSource %s should be pending for no agent, reason is %s", k, m.PendingReason)
+		}
+	}
+
+	capacity := &CapacityConfig{MaxSources: 2}
+	enqueued = updateMatch(logSources, newTestAgents("logkit-a", "logkit-b"), match, scheduler, capacity)
+	if len(enqueued) != 4 {
+		t.Errorf("4 logSources should be scheduled, enqueued %d", len(enqueued))
+	}
+	m := match["deployment_test_applog_pod-4"]
+	if m.AgentName != "" || m.PendingReason != PendingNoCapacity {
+		t.Errorf("the last logSource should be pending for no capacity, agent is %s, reason is %s", m.AgentName, m.PendingReason)
+	}
+
+	// The pending logSource is scheduled when the capacity is freed
+	delete(match, "deployment_test_applog_pod-0")
+	updateMatch(logSources, newTestAgents("logkit-a", "logkit-b"), match, scheduler, capacity)
+	if m.AgentName == "" || m.PendingReason != "" {
+		t.Errorf("the pending logSource should be scheduled, reason is %s", m.PendingReason)
+	}
+}
+
+func TestScheduleAgentType(t *testing.T) {
+	logSources := make(map[string]*api.LogSource)
+	match := make(map[string]*Match)
+	for i, agentType := range []string{"logkit", "fluentbit", "fluentbit", "vector"} {
+		logSource := newTestLogSource("deployment_test", fmt.Sprintf("pod-%d", i))
+		logSource.Spec.AgentType = agentType
+		logSources[logSource.Meta.Name] = logSource
+		match[logSource.Meta.Name] = &Match{PodName: logSource.Spec.PodName}
+	}
+	agents := map[string]*agent.Agent{
+		"logkit-a":    {Name: "logkit-a", Type: agent.Logkit},
+		"fluentbit-a": {Name: "fluentbit-a", Type: agent.Fluentbit},
+		"fluentbit-b": {Name: "fluentbit-b", Type: agent.Fluentbit},
+	}
+
+	for _, name := range []string{LeastCountScheduler, ConsistentHashScheduler, ControllerAffinityScheduler} {
+		for _, m := range match {
+			m.AgentName = ""
+		}
+		scheduler, _ := newScheduler(name)
+		updateMatch(logSources, agents, match, scheduler, nil)
+
+		for k, m := range match {
+			logSource := logSources[k]
+			if logSource.Spec.AgentType == "vector" {
+				if m.AgentName != "" || m.PendingReason != PendingNoAgentType {
+					t.Errorf("%s: logSource %s should be pending for no agent of its type, agent is %s, reason is %s", name, k, m.AgentName, m.PendingReason)
+				}
+				continue
+			}
+			if m.AgentName == "" || string(agents[m.AgentName].Type) != logSource.Spec.AgentType {
+				t.Errorf("%s: logSource %s of type %s is scheduled to agent %q", name, k, logSource.Spec.AgentType, m.AgentName)
+			}
+		}
+	}
+}
+
+func TestScheduleNodeLocal(t *testing.T) {
+	logSources := make(map[string]*api.LogSource)
+	match := make(map[string]*Match)
+	for i, node := range []string{"node-1", "node-2", "node-3"} {
+		logSource := newTestLogSource("deployment_test", fmt.Sprintf("pod-%d", i))
+		logSource.Spec.NodeName = node
+		logSource.Spec.NodeLogDir = fmt.Sprintf("/data/logs/test-ns_pod-%d", i)
+		logSources[logSource.Meta.Name] = logSource
+		match[logSource.Meta.Name] = &Match{PodName: logSource.Spec.PodName}
+	}
+	agents := map[string]*agent.Agent{
+		"logkit-a": {Name: "logkit-a", Node: "node-1"},
+		"logkit-b": {Name: "logkit-b", Node: "node-2"},
+	}
+
+	scheduler, _ := newScheduler(LeastCountScheduler)
+	updateMatch(logSources, agents, match, scheduler, nil)
+
+	if match["deployment_test_applog_pod-0"].AgentName != "logkit-a" || match["deployment_test_applog_pod-1"].AgentName != "logkit-b" {
+		t.Errorf("node-local logSources should be scheduled to the agents on their nodes, are %s and %s",
+			match["deployment_test_applog_pod-0"].AgentName, match["deployment_test_applog_pod-1"].AgentName)
+	}
+	if m := match["deployment_test_applog_pod-2"]; m.AgentName != "" || m.PendingReason != PendingNoNodeAgent {
+		t.Errorf("logSource on the node without agent should be pending, agent is %s, reason is %s", m.AgentName, m.PendingReason)
+	}
+
+	// The pod is recreated on another node, then it is re-scheduled to the agent there
+	match["deployment_test_applog_pod-0"].ConfPath = "/logkit/logkit-a/applog_pod-0.conf"
+	moved := *logSources["deployment_test_applog_pod-0"]
+	moved.Spec.NodeName = "node-2"
+	listed := []api.LogSource{moved, *logSources["deployment_test_applog_pod-1"], *logSources["deployment_test_applog_pod-2"]}
+	updateLogSources(logSources, listed, match)
+	updateMatch(logSources, agents, match, scheduler, nil)
+
+	if m := match["deployment_test_applog_pod-0"]; m.AgentName != "logkit-b" || judgeAction(m, "logkit-a") != LogSourceMov {
+		t.Errorf("moved logSource should be moved to logkit-b, agent is %s, action is %s", m.AgentName, judgeAction(m, "logkit-a"))
+	}
+}
+
+func TestUpdateLogSourcesPodMeta(t *testing.T) {
+	lm := &LogManager{Secrets: secret.NewStore(nil)}
+	logSources := map[string]*api.LogSource{}
+	match := make(map[string]*Match)
+
+	logSource := newTestLogSource("deployment_test", "pod-0")
+	logSource.Spec.PodLabels = map[string]string{"team": "infra"}
+	updateLogSources(logSources, []api.LogSource{*logSource}, match)
+	m := match[logSource.Meta.Name]
+	m.AgentName, m.ConfPath = "logkit-a", "/logkit/logkit-a/applog_pod-0.conf"
+	updateVersion(logSources, match, lm.configVersion)
+	m.ConfVersion = m.Version
+
+	// The labels of pod change, then the config rendered with them is updated
+	relabeled := *logSource
+	relabeled.Spec.PodLabels = map[string]string{"team": "storage"}
+	updateLogSources(logSources, []api.LogSource{relabeled}, match)
+	updateVersion(logSources, match, lm.configVersion)
+	if logSources[logSource.Meta.Name].Spec.PodLabels["team"] != "storage" || judgeAction(m, "logkit-a") != LogSourceUpd {
+		t.Errorf("logSource with changed labels should be updated, labels are %v, action is %s",
+			logSources[logSource.Meta.Name].Spec.PodLabels, judgeAction(m, "logkit-a"))
+	}
+
+	m.ConfVersion = m.Version
+	updateLogSources(logSources, []api.LogSource{relabeled}, match)
+	updateVersion(logSources, match, lm.configVersion)
+	if judgeAction(m, "logkit-a") != LogSourceNop {
+		t.Errorf("logSource not changed should not be updated, action is %s", judgeAction(m, "logkit-a"))
+	}
+}
+
+func TestScheduleSidecar(t *testing.T) {
+	logSources := make(map[string]*api.LogSource)
+	match := make(map[string]*Match)
+	for _, pod := range []string{"pod-0", "pod-1"} {
+		for _, stream := range []string{"applog", "auditlog"} {
+			logSource := newTestLogSource("statefulset_test", pod)
+			logSource.Meta.Name = fmt.Sprintf("statefulset_test_%s_%s", stream, pod)
+			logSource.Spec.Stream = stream
+			logSources[logSource.Meta.Name] = logSource
+			match[logSource.Meta.Name] = &Match{PodName: pod}
+		}
+	}
+	agents := map[string]*agent.Agent{
+		"test-ns_pod-0_uid-0": {Name: "test-ns_pod-0_uid-0", Pod: "test-ns/pod-0"},
+		"test-ns_other_uid-1": {Name: "test-ns_other_uid-1", Pod: "test-ns/other"},
+	}
+
+	// The capacity does not limit the sidecar agents, every pod has its own
+	scheduler, _ := newScheduler(LeastCountScheduler)
+	updateMatch(logSources, agents, match, scheduler, &CapacityConfig{MaxSources: 1})
+
+	for _, name := range []string{"statefulset_test_applog_pod-0", "statefulset_test_auditlog_pod-0"} {
+		if m := match[name]; m.AgentName != "test-ns_pod-0_uid-0" {
+			t.Errorf("logSource %s should be scheduled to the sidecar of its pod, is %s, reason is %s", name, m.AgentName, m.PendingReason)
+		}
+	}
+	for _, name := range []string{"statefulset_test_applog_pod-1", "statefulset_test_auditlog_pod-1"} {
+		if m := match[name]; m.AgentName != "" || m.PendingReason != PendingNoPodAgent {
+			t.Errorf("logSource %s of the pod without sidecar should be pending, agent is %s, reason is %s", name, m.AgentName, m.PendingReason)
+		}
+	}
+}
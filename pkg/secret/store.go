@@ -0,0 +1,244 @@
+package secret
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+// The interval to retry watching a secret after it fails
+const WatchRetryInterval = 5 * time.Second
+
+// Store caches the secrets referenced by the logSources, which are kept up to date by watching each of them by name,
+// and read by the AgentManager when it renders the config of a logSource. The values of the secrets are escaped
+// by the templates of configs, and must never be logged.
+type Store struct {
+	cli *kubernetes.Clientset
+
+	lock    sync.RWMutex
+	secrets map[string]*v1.Secret
+
+	// The keys of the referenced secrets, and the stop channels of their watches
+	refs    map[string]bool
+	watches map[string]chan struct{}
+}
+
+func NewStore(cli *kubernetes.Clientset) *Store {
+	return &Store{
+		cli:     cli,
+		secrets: make(map[string]*v1.Secret),
+		refs:    make(map[string]bool),
+		watches: make(map[string]chan struct{}),
+	}
+}
+
+// Update the secrets referenced by the logSources. The new ones are fetched at once and watched, the watches of
+// the secrets no longer referenced are stopped. The secret failed to fetch is kept, so that the transient errors
+// of apiserver never change the version of the logSources referencing it.
+func (s *Store) Sync(logSources map[string]*api.LogSource) error {
+	refs := make(map[string]bool)
+	for _, logSource := range logSources {
+		for _, ref := range logSource.Spec.Secrets {
+			refs[secretKey(logSource.Spec.Namespace, ref.Name)] = true
+		}
+	}
+
+	s.lock.Lock()
+	s.refs = refs
+	for key := range s.secrets {
+		if !refs[key] {
+			delete(s.secrets, key)
+		}
+	}
+	for key, stop := range s.watches {
+		if !refs[key] {
+			close(stop)
+			delete(s.watches, key)
+		}
+	}
+	missing := make([]string, 0)
+	for key := range refs {
+		if _, exist := s.watches[key]; !exist {
+			stop := make(chan struct{})
+			s.watches[key] = stop
+			go s.watch(key, stop)
+		}
+		if _, exist := s.secrets[key]; !exist {
+			missing = append(missing, key)
+		}
+	}
+	s.lock.Unlock()
+
+	errs := make([]string, 0)
+	for _, key := range missing {
+		namespace, name := splitSecretKey(key)
+		secret, err := s.cli.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("get secret %s failed, err: %v", key, err))
+			continue
+		}
+		s.update(key, secret)
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Watch the secret of key until stop is closed, the watch is restarted from a list after it ends
+func (s *Store) watch(key string, stop chan struct{}) {
+	logger := log.WithFields(log.Fields{
+		"func":   "Store.watch",
+		"secret": key,
+	})
+
+	for {
+		err := s.watchOnce(key, stop)
+		if err != nil {
+			logger.Warnf("Watch the secret failed, err: %v", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(WatchRetryInterval):
+		}
+	}
+}
+
+// Only the secret of key is listed and watched by its name, so that no other secrets of the namespace are read
+func (s *Store) watchOnce(key string, stop chan struct{}) error {
+	namespace, name := splitSecretKey(key)
+	options := metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String()}
+	list, err := s.cli.CoreV1().Secrets(namespace).List(options)
+	if err != nil {
+		return err
+	}
+	if len(list.Items) != 0 {
+		s.update(key, &list.Items[0])
+	} else {
+		// The secret deleted while not watching is removed
+		s.lock.Lock()
+		delete(s.secrets, key)
+		s.lock.Unlock()
+	}
+
+	options.ResourceVersion = list.ResourceVersion
+	w, err := s.cli.CoreV1().Secrets(namespace).Watch(options)
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return nil
+			}
+			secret, ok := event.Object.(*v1.Secret)
+			if !ok {
+				return fmt.Errorf("unexpected watch event %s", event.Type)
+			}
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				s.update(key, secret)
+			case watch.Deleted:
+				s.lock.Lock()
+				delete(s.secrets, key)
+				s.lock.Unlock()
+			}
+		}
+	}
+}
+
+// Cache the secret if it is referenced
+func (s *Store) update(key string, secret *v1.Secret) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.refs[key] {
+		s.secrets[key] = secret
+	}
+}
+
+// Resolve the values of the secret keys referenced by the logSource
+func (s *Store) Resolve(logSource *api.LogSource) (map[string]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	values := make(map[string]string)
+	for name, ref := range logSource.Spec.Secrets {
+		key := secretKey(logSource.Spec.Namespace, ref.Name)
+		secret, exist := s.secrets[key]
+		if !exist {
+			return nil, fmt.Errorf("secret %s referenced by %s is not found", key, name)
+		}
+		value, exist := secret.Data[ref.Key]
+		if !exist {
+			return nil, fmt.Errorf("secret %s has no key %s referenced by %s", key, ref.Key, name)
+		}
+		values[name] = string(value)
+	}
+	return values, nil
+}
+
+// Fetch the values of the secret keys in namespace from kubernetes without the cache, which is used to render
+// the configs shared by all the logSources of a LogConfig before the sync loop starts
+func (s *Store) Get(namespace string, refs map[string]api.SecretKeySelector) (map[string]string, error) {
+	values := make(map[string]string)
+	for name, ref := range refs {
+		key := secretKey(namespace, ref.Name)
+		secret, err := s.cli.CoreV1().Secrets(namespace).Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("get secret %s referenced by %s failed, err: %v", key, name, err)
+		}
+		value, exist := secret.Data[ref.Key]
+		if !exist {
+			return nil, fmt.Errorf("secret %s has no key %s referenced by %s", key, ref.Key, name)
+		}
+		values[name] = string(value)
+	}
+	return values, nil
+}
+
+// Return the version of the secrets referenced by the logSource, which changes when any of them changes
+func (s *Store) Version(logSource *api.LogSource) string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	visited := make(map[string]bool)
+	versions := make([]string, 0)
+	for _, ref := range logSource.Spec.Secrets {
+		key := secretKey(logSource.Spec.Namespace, ref.Name)
+		if visited[key] {
+			continue
+		}
+		visited[key] = true
+		if secret, exist := s.secrets[key]; exist {
+			versions = append(versions, fmt.Sprintf("%s@%s", key, secret.ResourceVersion))
+		}
+	}
+	sort.Strings(versions)
+	return strings.Join(versions, ",")
+}
+
+func secretKey(namespace, name string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
+
+func splitSecretKey(key string) (string, string) {
+	parts := strings.SplitN(key, "/", 2)
+	return parts[0], parts[1]
+}
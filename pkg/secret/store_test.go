@@ -0,0 +1,136 @@
+package secret
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+// fakeAPIServer serves the secret pandora in namespace test-ns, and streams the events sent to its watch
+type fakeAPIServer struct {
+	lock    sync.Mutex
+	failing bool
+	secret  v1.Secret
+	events  chan string
+}
+
+func newTestSecret(version, ak string) v1.Secret {
+	return v1.Secret{
+		TypeMeta:   metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "pandora", Namespace: "test-ns", ResourceVersion: version},
+		Data:       map[string][]byte{"ak": []byte(ak)},
+	}
+}
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	failing, secret := f.failing, f.secret
+	f.lock.Unlock()
+	if failing {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Only the secret pandora is listed and watched, the others of the namespace are never read
+	if r.URL.Path == "/api/v1/namespaces/test-ns/secrets" && r.URL.Query().Get("fieldSelector") != "metadata.name=pandora" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.URL.Path == "/api/v1/namespaces/test-ns/secrets/pandora":
+		json.NewEncoder(w).Encode(secret)
+	case r.URL.Path == "/api/v1/namespaces/test-ns/secrets" && r.URL.Query().Get("watch") == "true":
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case event := <-f.events:
+				fmt.Fprintln(w, event)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	case r.URL.Path == "/api/v1/namespaces/test-ns/secrets":
+		json.NewEncoder(w).Encode(v1.SecretList{
+			TypeMeta: metav1.TypeMeta{Kind: "SecretList", APIVersion: "v1"},
+			ListMeta: metav1.ListMeta{ResourceVersion: secret.ResourceVersion},
+			Items:    []v1.Secret{secret},
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestStoreSync(t *testing.T) {
+	fake := &fakeAPIServer{secret: newTestSecret("1", "ak-1"), events: make(chan string)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	cli, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("create client failed, err: %v", err)
+	}
+
+	store := NewStore(cli)
+	logSources := map[string]*api.LogSource{
+		"test": {
+			Spec: api.LogSourceSpec{
+				Namespace: "test-ns",
+				Secrets:   map[string]api.SecretKeySelector{"pandora_ak": {Name: "pandora", Key: "ak"}},
+			},
+		},
+	}
+	logSource := logSources["test"]
+
+	err = store.Sync(logSources)
+	if err != nil {
+		t.Fatalf("sync secrets failed, err: %v", err)
+	}
+	version := store.Version(logSource)
+	if values, err := store.Resolve(logSource); err != nil || values["pandora_ak"] != "ak-1" {
+		t.Fatalf("secret should be resolved as ak-1, values: %v, err: %v", values, err)
+	}
+
+	// The change of secret is watched without fetching it again
+	secret := newTestSecret("2", "ak-2")
+	data, _ := json.Marshal(secret)
+	fake.events <- fmt.Sprintf(`{"type":"MODIFIED","object":%s}`, data)
+	for i := 0; i < 50 && store.Version(logSource) == version; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if values, _ := store.Resolve(logSource); values["pandora_ak"] != "ak-2" || store.Version(logSource) == version {
+		t.Errorf("secret should be updated by the watch, values: %v, version: %s", values, store.Version(logSource))
+	}
+
+	// The secret cached is kept when the apiserver fails
+	version = store.Version(logSource)
+	fake.lock.Lock()
+	fake.failing = true
+	fake.lock.Unlock()
+	err = store.Sync(logSources)
+	if err != nil {
+		t.Errorf("sync cached secrets should not fetch them, err: %v", err)
+	}
+	if values, err := store.Resolve(logSource); err != nil || values["pandora_ak"] != "ak-2" || store.Version(logSource) != version {
+		t.Errorf("secret should be kept when apiserver fails, values: %v, err: %v", values, err)
+	}
+
+	// The secret no longer referenced is removed
+	err = store.Sync(map[string]*api.LogSource{})
+	if err != nil || store.Version(logSource) != "" {
+		t.Errorf("secret not referenced should be removed, version: %s, err: %v", store.Version(logSource), err)
+	}
+}
@@ -0,0 +1,176 @@
+package logmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+	"github.com/fatsheep9146/kirklog/pkg/metrics"
+)
+
+var (
+	pendingLogSources = metrics.NewGaugeVec("kirklog_logsources_pending", "The count of logSources which can not be scheduled to any log agent.", "reason")
+)
+
+// The info of one logSource served by the admin api
+type LogSourceInfo struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	PodName   string `json:"pod_name"`
+	Stream    string `json:"stream"`
+
+	// The type and name of the agent the logSource is scheduled to, and the reason if it is pending
+	AgentType     string `json:"agent_type"`
+	AgentName     string `json:"agent_name"`
+	PendingReason string `json:"pending_reason,omitempty"`
+
+	Status api.LogSourceStatus `json:"status"`
+}
+
+// Serve the metrics and the admin api of logmanager
+func (lm *LogManager) serve() {
+	logger := log.WithFields(log.Fields{
+		"func": "serve",
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/api/v1/logsources", lm.listLogSourcesHandler)
+
+	logger.Infof("Start serving metrics and admin api on %s", lm.MetricsAddr)
+	err := http.ListenAndServe(lm.MetricsAddr, mux)
+	if err != nil {
+		logger.Errorf("Serve metrics and admin api failed, err: %v", err)
+	}
+}
+
+// Serve the config files to the agents of daemonset. The config files hold the values of secrets, so they are
+// served apart from the admin api, and only to the agents with the conf token.
+func (lm *LogManager) serveConfs() {
+	logger := log.WithFields(log.Fields{
+		"func": "serveConfs",
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(agent.ConfsAPIPath, lm.getConfsHandler)
+
+	logger.Infof("Start serving the config files of log agents on %s", lm.ConfAddr)
+	err := http.ListenAndServe(lm.ConfAddr, mux)
+	if err != nil {
+		logger.Errorf("Serve the config files of log agents failed, err: %v", err)
+	}
+}
+
+// Copy the logSources for the admin api, so that the handlers do not read the maps being synced
+func (lm *LogManager) updateSnapshot() {
+	snapshot := make([]LogSourceInfo, 0, len(lm.Match))
+	for k, m := range lm.Match {
+		logSource, exist := lm.LogSources[k]
+		if !exist || m.PodName == "" {
+			continue
+		}
+		snapshot = append(snapshot, LogSourceInfo{
+			Name:          k,
+			Namespace:     logSource.Spec.Namespace,
+			PodName:       logSource.Spec.PodName,
+			Stream:        logSource.Spec.Stream,
+			AgentType:     logSource.Spec.AgentType,
+			AgentName:     m.AgentName,
+			PendingReason: m.PendingReason,
+			Status:        logSource.Status,
+		})
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].Name < snapshot[j].Name
+	})
+
+	// The conf dirs of agents are served to the agents of daemonset by the conf server
+	confDirs := make(map[string]string, len(lm.LogAgents))
+	for name, logAgent := range lm.LogAgents {
+		confDirs[name] = logAgent.ConfPath
+	}
+
+	lm.snapshotLock.Lock()
+	lm.snapshot = snapshot
+	lm.confDirs = confDirs
+	lm.snapshotLock.Unlock()
+}
+
+// List the logSources and their status, only the ones whose logs fail to ship are listed with ?failing=true
+func (lm *LogManager) listLogSourcesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	failing := r.URL.Query().Get("failing") == "true"
+
+	lm.snapshotLock.RLock()
+	logSources := make([]LogSourceInfo, 0, len(lm.snapshot))
+	for _, info := range lm.snapshot {
+		if failing && info.Status.RunnerStatus.FailingReason == "" {
+			continue
+		}
+		logSources = append(logSources, info)
+	}
+	lm.snapshotLock.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logSources)
+}
+
+// Get the config files of one agent as a tar, which are synced by the agents of daemonset with the conf token
+func (lm *LogManager) getConfsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := log.WithFields(log.Fields{
+		"func": "getConfsHandler",
+	})
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !agent.CheckConfToken(r, lm.ConfToken) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	agentName := strings.TrimPrefix(r.URL.Path, agent.ConfsAPIPath)
+
+	lm.snapshotLock.RLock()
+	confDir, exist := lm.confDirs[agentName]
+	lm.snapshotLock.RUnlock()
+	if !exist {
+		http.Error(w, fmt.Sprintf("log agent %s not found", agentName), http.StatusNotFound)
+		return
+	}
+
+	// The tar is built before writing, so that a failure is never synced as an empty conf dir
+	buf := &bytes.Buffer{}
+	err := agent.WriteConfTar(buf, confDir)
+	if err != nil {
+		logger.Errorf("Read the config files of log agent %s failed, err: %v", agentName, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Write(buf.Bytes())
+}
+
+func updatePendingMetrics(match map[string]*Match) {
+	counts := make(map[string]int)
+	for _, m := range match {
+		if m.PodName != "" && m.PendingReason != "" {
+			counts[m.PendingReason]++
+		}
+	}
+
+	pendingLogSources.Reset()
+	for _, reason := range []string{PendingNoAgent, PendingNoCapacity, PendingNoAgentType, PendingNoNodeAgent, PendingNoPodAgent} {
+		pendingLogSources.Set(float64(counts[reason]), reason)
+	}
+}
@@ -0,0 +1,63 @@
+package logmanager
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+)
+
+func TestGetConfsHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kirklog-confs")
+	if err != nil {
+		t.Fatalf("create temp dir failed, err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "test.conf"), []byte("test"), 0644)
+
+	lm := &LogManager{
+		LogAgents: map[string]*agent.Agent{
+			"agent-0": {Name: "agent-0", ConfPath: dir},
+		},
+		ConfToken: "test-token",
+	}
+	lm.updateSnapshot()
+	newRequest := func(agentName, token string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, agent.ConfsAPIPath+agentName, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		return r
+	}
+
+	w := httptest.NewRecorder()
+	lm.getConfsHandler(w, newRequest("agent-0", "test-token"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("get confs of agent-0 should succeed, code is %d", w.Code)
+	}
+	header, err := tar.NewReader(w.Body).Next()
+	if err != nil || header.Name != "test.conf" {
+		t.Errorf("confs of agent-0 should have test.conf, header is %+v, err: %v", header, err)
+	}
+
+	// The config files hold the values of secrets, they are never served without the conf token
+	for _, token := range []string{"", "wrong-token"} {
+		w = httptest.NewRecorder()
+		lm.getConfsHandler(w, newRequest("agent-0", token))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("get confs with token %q should be unauthorized, code is %d", token, w.Code)
+		}
+	}
+
+	// The agent not listed yet retries later, instead of syncing an empty conf dir
+	w = httptest.NewRecorder()
+	lm.getConfsHandler(w, newRequest("agent-1", "test-token"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("get confs of unknown agent should be not found, code is %d", w.Code)
+	}
+}
@@ -0,0 +1,135 @@
+package logmanager
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+	"github.com/fatsheep9146/kirklog/pkg/metrics"
+)
+
+const (
+	// The reasons why the logs of a logSource fail to ship
+	FailingAgentUnavailable = "status of log agent is unavailable"
+	FailingRunnerMissing    = "runner is not found in log agent"
+	FailingSendErrors       = "send errors increase"
+	FailingParseErrors      = "parse errors increase"
+)
+
+var (
+	runnerLines       = metrics.NewGaugeVec("kirklog_logsource_runner_lines", "The lines read and sent by the runner of logSource.", "logsource", "agent", "kind")
+	runnerErrors      = metrics.NewGaugeVec("kirklog_logsource_runner_errors", "The parse and send errors of the runner of logSource.", "logsource", "agent", "kind")
+	failingLogSources = metrics.NewGaugeVec("kirklog_logsources_failing", "The count of logSources whose logs fail to ship.", "reason")
+)
+
+// Poll the log agents for the status of runners, and fill in the runner status of every logSource
+func (lm *LogManager) collectStatus() {
+	logger := log.WithFields(log.Fields{
+		"func": "collectStatus",
+	})
+
+	// Only the logSources whose config is already on its agent are collected, the moving ones are skipped
+	logSourcesOfAgent := make(map[string][]*api.LogSource)
+	for k, m := range lm.Match {
+		logSource, exist := lm.LogSources[k]
+		if !exist || m.PodName == "" || m.AgentName == "" || m.MoveStep != "" || lm.confAgentOf(k) != m.AgentName {
+			continue
+		}
+		logSourcesOfAgent[m.AgentName] = append(logSourcesOfAgent[m.AgentName], logSource)
+	}
+
+	now := time.Now()
+	for agentName, logSources := range logSourcesOfAgent {
+		a, exist := lm.LogAgents[agentName]
+		if !exist {
+			continue
+		}
+		collector, ok := lm.LogAgentManagers[a.Type].(agent.StatusCollector)
+		if !ok {
+			logger.Debugf("The agent manager of type %s does not support collecting status", a.Type)
+			continue
+		}
+		status, err := collector.CollectStatus(agentName, logSources)
+		if err != nil {
+			logger.Errorf("Collect status from log agent %s failed, err: %v", agentName, err)
+		}
+
+		for _, logSource := range logSources {
+			old := &logSource.Status.RunnerStatus
+			switch newStatus, exist := status[logSource.Meta.Name]; {
+			case err != nil:
+				old.FailingReason = FailingAgentUnavailable
+			case !exist:
+				old.Agent = agentName
+				old.CollectedAt = now
+				old.FailingReason = FailingRunnerMissing
+				// The runners added through the api of agent are lost after the agent restarts, the config is added again
+				logger.Infof("The runner of logSource %s is missing in log agent %s, add it again", logSource.Meta.Name, agentName)
+				m := lm.Match[logSource.Meta.Name]
+				m.ConfPath = ""
+				m.ConfVersion = ""
+				lm.Queue.Add(logSource.Meta.Name)
+			default:
+				*old = mergeRunnerStatus(old, newStatus, now)
+			}
+			if old.FailingReason != "" {
+				logger.Warnf("The logs of logSource %s fail to ship, reason: %s, last error: %s", logSource.Meta.Name, old.FailingReason, old.LastError)
+			}
+		}
+	}
+
+	lm.updateRunnerMetrics()
+}
+
+// Merge the newest status of runner with the previous one, the last activity and the failing reason
+// are decided by the increase of the counters
+func mergeRunnerStatus(old *api.RunnerStatus, status api.RunnerStatus, now time.Time) api.RunnerStatus {
+	status.LastActivity = old.LastActivity
+	if status.ReadLines > old.ReadLines || status.ReadBytes > old.ReadBytes || status.Agent != old.Agent {
+		status.LastActivity = now
+	}
+
+	// The counters start from 0 again when the runner moves to another agent or restarts
+	base := *old
+	if status.Agent != old.Agent || status.ReadLines < old.ReadLines {
+		base = api.RunnerStatus{}
+	}
+
+	status.FailingReason = ""
+	if status.SendErrors > base.SendErrors {
+		status.FailingReason = FailingSendErrors
+	} else if status.ParseErrors > base.ParseErrors {
+		status.FailingReason = FailingParseErrors
+	}
+	return status
+}
+
+func (lm *LogManager) updateRunnerMetrics() {
+	runnerLines.Reset()
+	runnerErrors.Reset()
+	counts := make(map[string]int)
+	for k, m := range lm.Match {
+		logSource, exist := lm.LogSources[k]
+		if !exist || m.PodName == "" {
+			continue
+		}
+		status := &logSource.Status.RunnerStatus
+		if status.CollectedAt.IsZero() {
+			continue
+		}
+		runnerLines.Set(float64(status.ReadLines), k, status.Agent, "read")
+		runnerLines.Set(float64(status.SentLines), k, status.Agent, "sent")
+		runnerErrors.Set(float64(status.ParseErrors), k, status.Agent, "parse")
+		runnerErrors.Set(float64(status.SendErrors), k, status.Agent, "send")
+		if status.FailingReason != "" {
+			counts[status.FailingReason]++
+		}
+	}
+
+	failingLogSources.Reset()
+	for _, reason := range []string{FailingAgentUnavailable, FailingRunnerMissing, FailingSendErrors, FailingParseErrors} {
+		failingLogSources.Set(float64(counts[reason]), reason)
+	}
+}
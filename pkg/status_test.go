@@ -0,0 +1,81 @@
+package logmanager
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+func TestMergeRunnerStatus(t *testing.T) {
+	start := time.Unix(1000, 0)
+	now := start.Add(time.Minute)
+	old := &api.RunnerStatus{Agent: "agent-0", ReadLines: 100, SentLines: 100, LastActivity: start}
+
+	tests := []struct {
+		name     string
+		status   api.RunnerStatus
+		activity time.Time
+		reason   string
+	}{
+		{"idle", api.RunnerStatus{Agent: "agent-0", ReadLines: 100, SentLines: 100}, start, ""},
+		{"reading", api.RunnerStatus{Agent: "agent-0", ReadLines: 200, SentLines: 200}, now, ""},
+		{"send errors", api.RunnerStatus{Agent: "agent-0", ReadLines: 200, SentLines: 100, SendErrors: 3}, now, FailingSendErrors},
+		{"parse errors", api.RunnerStatus{Agent: "agent-0", ReadLines: 200, SentLines: 190, ParseErrors: 10}, now, FailingParseErrors},
+		{"moved", api.RunnerStatus{Agent: "agent-1", ReadLines: 10, SentLines: 10}, now, ""},
+	}
+
+	for _, test := range tests {
+		status := mergeRunnerStatus(old, test.status, now)
+		if !status.LastActivity.Equal(test.activity) || status.FailingReason != test.reason {
+			t.Errorf("%s: expect last activity %v and reason %q, got %v and %q", test.name, test.activity, test.reason, status.LastActivity, status.FailingReason)
+		}
+	}
+
+	// The errors already reported before do not make the logSource failing again
+	old = &api.RunnerStatus{Agent: "agent-0", ReadLines: 100, SendErrors: 3, FailingReason: FailingSendErrors}
+	status := mergeRunnerStatus(old, api.RunnerStatus{Agent: "agent-0", ReadLines: 150, SentLines: 150, SendErrors: 3}, now)
+	if status.FailingReason != "" {
+		t.Errorf("expect the logSource recovered, got reason %q", status.FailingReason)
+	}
+}
+
+func TestCollectStatusRunnerMissing(t *testing.T) {
+	f := newFakeAgentManager()
+	logSource := newTestLogSource("deploy_app", "pod-0")
+	key := logSource.Meta.Name
+	confPath, _ := f.AddConfig(logSource, "agent-0")
+
+	lm := &LogManager{
+		LogSources:       map[string]*api.LogSource{key: logSource},
+		LogAgents:        map[string]*agent.Agent{"agent-0": {Name: "agent-0"}},
+		LogAgentManagers: map[agent.AgentType]agent.AgentManager{"": f},
+		Match: map[string]*Match{
+			key: {PodName: "pod-0", AgentName: "agent-0", ConfPath: confPath, Version: "1", ConfVersion: "1"},
+		},
+		Queue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+	defer lm.Queue.ShutDown()
+
+	lm.collectStatus()
+	if logSource.Status.RunnerStatus.FailingReason != "" || lm.Queue.Len() != 0 {
+		t.Errorf("the running runner should not be added again, status: %+v", logSource.Status.RunnerStatus)
+	}
+
+	// The agent restarts and loses the runner, then the config is added again
+	f.DelConfig(logSource, "agent-0")
+	lm.collectStatus()
+	if logSource.Status.RunnerStatus.FailingReason != FailingRunnerMissing {
+		t.Errorf("the failing reason should be %s, is %s", FailingRunnerMissing, logSource.Status.RunnerStatus.FailingReason)
+	}
+	if action := judgeAction(lm.Match[key], lm.confAgentOf(key)); action != LogSourceAdd || lm.Queue.Len() != 1 {
+		t.Errorf("the logSource with missing runner should be added again, action is %s, queue length is %d", action, lm.Queue.Len())
+	}
+	if ok, err := lm.logSourceAddFunc(key); !ok || err != nil || lm.Match[key].ConfPath != fmt.Sprintf("/logkit/agent-0/%s.conf", key) {
+		t.Errorf("add the config again failed, err: %v, match: %+v", err, lm.Match[key])
+	}
+}
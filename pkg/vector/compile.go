@@ -0,0 +1,111 @@
+package vector
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+// The timeout of the multiline aggregation of the file source
+const MultilineTimeoutMs = 1000
+
+// Compile the collect spec into the config of log stream of vector, the paths are taken by renderConfig.
+// The parser and the fields are done by one remap transform, which the sink reads from.
+func compileSpec(spec *api.CollectSpec) (string, error) {
+	config := make(map[string]interface{})
+
+	source := make(map[string]interface{})
+	switch spec.ReadFrom {
+	case api.ReadFromOldest:
+		source["read_from"] = "beginning"
+	case api.ReadFromNewest:
+		source["read_from"] = "end"
+	}
+	if spec.Multiline != nil {
+		source["multiline"] = map[string]interface{}{
+			"start_pattern":     spec.Multiline.Start,
+			"condition_pattern": spec.Multiline.Start,
+			"mode":              "halt_before",
+			"timeout_ms":        int64(MultilineTimeoutMs),
+		}
+	}
+	if len(source) != 0 {
+		config["source"] = source
+	}
+
+	program := make([]string, 0)
+	if spec.Parser != nil {
+		switch spec.Parser.Type {
+		case api.ParserRaw:
+		case api.ParserJSON:
+			program = append(program, "parsed, err = parse_json(.message)\n")
+		case api.ParserRegex:
+			if strings.Contains(spec.Parser.Pattern, "'") {
+				return "", fmt.Errorf("regex parser pattern of vector should have no single quotes")
+			}
+			program = append(program, fmt.Sprintf("parsed, err = parse_regex(.message, r'%s')\n", spec.Parser.Pattern))
+		default:
+			return "", fmt.Errorf("parser %s is not supported by vector", spec.Parser.Type)
+		}
+		if len(program) != 0 {
+			program = append(program, "if err == null && is_object(parsed) { . = merge(., object!(parsed)) }\n")
+		}
+	}
+	keys := make([]string, 0, len(spec.Fields))
+	for k := range spec.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		program = append(program, fmt.Sprintf(".%s = %s\n", strconv.Quote(k), strconv.Quote(spec.Fields[k])))
+	}
+
+	sink := make(map[string]interface{})
+	if len(program) != 0 {
+		config["transforms"] = map[string]interface{}{
+			"collect": map[string]interface{}{
+				"type":   "remap",
+				"source": strings.Join(program, ""),
+			},
+		}
+		sink["inputs"] = []interface{}{"collect"}
+	}
+
+	dest := spec.Destination
+	switch dest.Type {
+	case api.DestinationHTTP:
+		sink["type"] = "http"
+		sink["uri"] = dest.URL
+		sink["encoding"] = map[string]interface{}{"codec": "json"}
+		if len(dest.Headers) != 0 {
+			headers := make(map[string]interface{})
+			for k, v := range dest.Headers {
+				headers[k] = v
+			}
+			sink["request"] = map[string]interface{}{"headers": headers}
+		}
+	case api.DestinationElasticsearch:
+		endpoints := make([]interface{}, 0, len(dest.Hosts))
+		for _, host := range dest.Hosts {
+			endpoints = append(endpoints, host)
+		}
+		sink["type"] = "elasticsearch"
+		sink["endpoints"] = endpoints
+		sink["bulk"] = map[string]interface{}{"index": dest.Index}
+	default:
+		return "", fmt.Errorf("destination %s is not supported by vector", dest.Type)
+	}
+	if dest.Username != "" {
+		sink["auth"] = map[string]interface{}{
+			"strategy": "basic",
+			"user":     dest.Username,
+			"password": dest.Password,
+		}
+	}
+	config["sinks"] = map[string]interface{}{"destination": sink}
+
+	return encodeTOML(config)
+}
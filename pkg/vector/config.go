@@ -0,0 +1,244 @@
+package vector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+const (
+	// The key of the event which holds the path of the log file, the same as the datasource_tag of logkit
+	LogSourceKey = "log_source"
+)
+
+// The keys of the file source which are decided by kirklog, and can not be overridden by the config of log stream
+var fixedSourceKeys = []string{"type", "include", "data_dir"}
+
+// Render the config bundle of logSource in TOML. The config of log stream is written in TOML too, which has
+// the transforms and sinks tables of vector, and optionally a source table whose keys are merged into the file
+// source of the log dir. For example:
+//
+//	[source]
+//	read_from = "beginning"
+//
+//	[transforms.parse]
+//	type = "remap"
+//	source = '. = parse_json!(.message)'
+//
+//	[sinks.es]
+//	type = "elasticsearch"
+//	inputs = ["parse"]
+//	endpoints = ["http://es:9200"]
+//
+// The components are prefixed by the id of logSource so that the bundles of logSources never collide, the
+// transforms and sinks without inputs read from the remap transform which adds the pod metadata.
+func renderConfig(logSource *api.LogSource, secrets map[string]string) (string, error) {
+	configRaw, err := agent.RenderConfig(logSource, secrets, api.FormatTOML, compileSpec)
+	if err != nil {
+		return "", err
+	}
+	config, err := decodeTOML(configRaw)
+	if err != nil {
+		return "", err
+	}
+
+	id := getComponentID(logSource)
+	metadataID := fmt.Sprintf("%s_k8s", id)
+
+	source := map[string]interface{}{}
+	userTransforms := map[string]interface{}{}
+	userSinks := map[string]interface{}{}
+	for k, v := range config {
+		table, ok := v.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("key %s should be a table in the config of log stream", k)
+		}
+		switch k {
+		case "source":
+			source = table
+		case "transforms":
+			userTransforms = table
+		case "sinks":
+			userSinks = table
+		default:
+			return "", fmt.Errorf("table %s is not supported in the config of log stream", k)
+		}
+	}
+	if len(userSinks) == 0 {
+		return "", fmt.Errorf("no sink in the config of log stream")
+	}
+
+	fileSource := make(map[string]interface{})
+	for k, v := range source {
+		if !isFixedSourceKey(k) {
+			fileSource[k] = v
+		}
+	}
+	// The lines of container log are still in the format of runtime in the file source
+	if _, exist := fileSource["multiline"]; exist && logSource.IsContainer() {
+		return "", fmt.Errorf("multiline of source is not supported for the container log")
+	}
+	fileSource["type"] = "file"
+	include := make([]interface{}, 0)
+	for _, path := range logSource.GetLogPaths() {
+		include = append(include, path)
+	}
+	fileSource["include"] = include
+	fileSource["data_dir"] = logSource.GetLogMetaDir()
+	if _, exist := fileSource["file_key"]; !exist {
+		fileSource["file_key"] = LogSourceKey
+	}
+
+	transforms := map[string]interface{}{
+		metadataID: map[string]interface{}{
+			"type":   "remap",
+			"inputs": []interface{}{id},
+			"source": renderMetadataProgram(logSource),
+		},
+	}
+	sinks := make(map[string]interface{})
+
+	for _, group := range []struct {
+		tables map[string]interface{}
+		result map[string]interface{}
+	}{
+		{userTransforms, transforms},
+		{userSinks, sinks},
+	} {
+		for name, v := range group.tables {
+			component, ok := v.(map[string]interface{})
+			if !ok {
+				return "", fmt.Errorf("component %s should be a table in the config of log stream", name)
+			}
+			inputs, err := renderInputs(name, component["inputs"], userTransforms, id, metadataID)
+			if err != nil {
+				return "", err
+			}
+			component["inputs"] = inputs
+			group.result[fmt.Sprintf("%s_%s", id, name)] = component
+		}
+	}
+
+	return encodeTOML(map[string]interface{}{
+		"sources": map[string]interface{}{
+			id: fileSource,
+		},
+		"transforms": transforms,
+		"sinks":      sinks,
+	})
+}
+
+// Rewrite the inputs of a component to the prefixed names, the component only reads from the transforms
+// of the same logSource
+func renderInputs(name string, value interface{}, userTransforms map[string]interface{}, id, metadataID string) ([]interface{}, error) {
+	if value == nil {
+		return []interface{}{metadataID}, nil
+	}
+	inputs, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("inputs of component %s should be an array", name)
+	}
+	if len(inputs) == 0 {
+		return []interface{}{metadataID}, nil
+	}
+
+	result := make([]interface{}, 0, len(inputs))
+	for _, input := range inputs {
+		inputName, ok := input.(string)
+		if !ok {
+			return nil, fmt.Errorf("inputs of component %s should be strings", name)
+		}
+		if _, exist := userTransforms[inputName]; !exist {
+			return nil, fmt.Errorf("input %s of component %s is not a transform in the config of log stream", inputName, name)
+		}
+		result = append(result, fmt.Sprintf("%s_%s", id, inputName))
+	}
+	return result, nil
+}
+
+// The remap programs which parse the lines of container log in the format of runtime, the message and the stream
+// are taken out of the line. The messages split by the runtime are not joined.
+var containerLogPrograms = map[string]string{
+	api.ContainerLogCRI: `parsed, err = parse_regex(.message, r'^(?P<time>\S+) (?P<stream>stdout|stderr) (?P<logtag>\S+) (?P<log>.*)$')
+if err == null {
+  .message = parsed.log
+  .stream = parsed.stream
+}
+`,
+	api.ContainerLogDocker: `parsed, err = parse_json(.message)
+if err == null && is_object(parsed) {
+  docker = object!(parsed)
+  .message = replace(string(docker.log) ?? "", r'\n$', "")
+  .stream = docker.stream
+}
+`,
+}
+
+// The remap program which adds the pod metadata to the events, like the k8sdir transform of logkit.
+// The lines of container log are parsed first.
+func renderMetadataProgram(logSource *api.LogSource) string {
+	lines := make([]string, 0)
+	if logSource.IsContainer() {
+		lines = append(lines, containerLogPrograms[logSource.Spec.ContainerLogFormat])
+	}
+	for _, field := range []struct {
+		key   string
+		value string
+	}{
+		{"k8s_namespace", logSource.Spec.Namespace},
+		{"k8s_pod_name", logSource.Spec.PodName},
+		{"k8s_node_name", logSource.Spec.NodeName},
+		{"k8s_controller", logSource.Spec.ControllerName},
+		{"k8s_stream", logSource.Spec.Stream},
+		{"k8s_container", logSource.Spec.Container},
+	} {
+		if field.value != "" {
+			lines = append(lines, fmt.Sprintf(".%s = %s\n", field.key, strconv.Quote(field.value)))
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// Render the base config of vector, which serves the api and exports the internal metrics
+func renderBaseConfig() (string, error) {
+	return encodeTOML(map[string]interface{}{
+		"data_dir": VectorDataDir,
+		"api": map[string]interface{}{
+			"enabled": true,
+			"address": fmt.Sprintf("0.0.0.0:%d", VectorAPIPort),
+		},
+		"sources": map[string]interface{}{
+			"kirklog_internal_metrics": map[string]interface{}{
+				"type": "internal_metrics",
+			},
+		},
+		"sinks": map[string]interface{}{
+			"kirklog_prometheus": map[string]interface{}{
+				"type":    "prometheus_exporter",
+				"inputs":  []interface{}{"kirklog_internal_metrics"},
+				"address": fmt.Sprintf("0.0.0.0:%d", VectorMetricsPort),
+			},
+		},
+	})
+}
+
+func isFixedSourceKey(key string) bool {
+	for _, fixed := range fixedSourceKeys {
+		if key == fixed {
+			return true
+		}
+	}
+	return false
+}
+
+// The id of the file source of logSource, which is also the prefix of its transforms and sinks
+func getComponentID(logSource *api.LogSource) string {
+	return logSource.Meta.Name
+}
+
+func getConfigFileName(logSource *api.LogSource) string {
+	return fmt.Sprintf("%s.toml", logSource.Meta.Name)
+}
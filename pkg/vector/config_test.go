@@ -0,0 +1,148 @@
+package vector
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent/agenttest"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+var update = flag.Bool("update", false, "update the golden files of rendered configs")
+
+func TestRenderConfigGolden(t *testing.T) {
+	inputs, err := filepath.Glob("testdata/*.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no test case in testdata")
+	}
+
+	for _, input := range inputs {
+		streamConfig, err := ioutil.ReadFile(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := renderConfig(agenttest.NewLogSource(string(streamConfig)), map[string]string{"es_password": "secret"})
+		if err != nil {
+			t.Errorf("render %s failed, err: %v", input, err)
+			continue
+		}
+
+		golden := strings.TrimSuffix(input, ".conf") + ".golden"
+		if *update {
+			err = ioutil.WriteFile(golden, []byte(config), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config != string(expected) {
+			t.Errorf("rendered config of %s is wrong, is\n%s", input, config)
+		}
+
+		// The rendered config should be decoded and encoded to the same text
+		table, err := decodeTOML(config)
+		if err != nil {
+			t.Errorf("decode rendered config of %s failed, err: %v", input, err)
+			continue
+		}
+		encoded, err := encodeTOML(table)
+		if err != nil {
+			t.Errorf("encode rendered config of %s failed, err: %v", input, err)
+			continue
+		}
+		if encoded != config {
+			t.Errorf("round trip of %s is wrong, is\n%s", input, encoded)
+		}
+	}
+}
+
+func TestRenderConfigInvalid(t *testing.T) {
+	agenttest.CheckRenderInvalid(t, renderConfig, map[string]string{
+		"[source]\nread_from = \"beginning\"\n":                             "no sink",
+		"[api]\nenabled = true\n[sinks.out]\ntype = \"console\"\n":          "not supported",
+		"[sinks.out]\ntype = \"console\"\ninputs = [\"other_logsource\"]\n": "is not a transform",
+	})
+}
+
+func TestDecodeTOML(t *testing.T) {
+	table, err := decodeTOML(`
+# comment
+a.b = "x\ty"
+c = [1, 2.5, 'lit', # comment
+  true,
+]
+d = { e = -3, "f g" = """
+multi""" }
+
+[h.i]
+j = '''
+k'''
+`)
+	if err != nil {
+		t.Fatalf("decode failed, err: %v", err)
+	}
+	expected := map[string]interface{}{
+		"a": map[string]interface{}{"b": "x\ty"},
+		"c": []interface{}{int64(1), 2.5, "lit", true},
+		"d": map[string]interface{}{"e": int64(-3), "f g": "multi"},
+		"h": map[string]interface{}{
+			"i": map[string]interface{}{"j": "k"},
+		},
+	}
+	if !reflect.DeepEqual(table, expected) {
+		t.Errorf("decoded table is wrong, is %#v", table)
+	}
+
+	_, err = decodeTOML("[[sinks]]\ntype = \"console\"\n")
+	if err == nil {
+		t.Errorf("expect array of tables rejected")
+	}
+}
+
+func TestRenderCollectSpec(t *testing.T) {
+	logSource := agenttest.NewLogSource("")
+	logSource.Spec.Collect = &api.CollectSpec{
+		Paths:     []string{"*.log"},
+		ReadFrom:  api.ReadFromOldest,
+		Multiline: &api.MultilineSpec{Start: `^\d{4}-`},
+		Parser:    &api.ParserSpec{Type: api.ParserRegex, Pattern: `^(?P<level>\w+) (?P<msg>.*)$`},
+		Fields:    map[string]string{"team": "{{ .Pod.Labels.team }}"},
+		Destination: api.DestinationSpec{
+			Type:     api.DestinationElasticsearch,
+			Hosts:    []string{"http://es.{{ .Namespace }}:9200"},
+			Index:    "applog",
+			Username: "elastic",
+			Password: "{{ .Secrets.es_password }}",
+		},
+	}
+
+	config, err := renderConfig(logSource, map[string]string{"es_password": "secret"})
+	if err != nil {
+		t.Fatalf("render collect spec failed, err: %v", err)
+	}
+
+	golden := "testdata/collect.golden"
+	if *update {
+		err = ioutil.WriteFile(golden, []byte(config), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config != string(expected) {
+		t.Errorf("rendered collect spec is wrong, is\n%s", config)
+	}
+}
@@ -0,0 +1,97 @@
+package vector
+
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+)
+
+const (
+	// The dir where the conf pvc is mounted, every agent watches the config files in the dir named by its pod name
+	VectorConfVolumeMountPath = "/vector"
+
+	// The dir where the base config of vector is mounted from configmap
+	VectorBaseConfDir  = "/etc/vector"
+	VectorBaseConfFile = "vector.toml"
+
+	// The dir where vector keeps its own state
+	VectorDataDir = "/var/lib/vector"
+
+	// The ports of the api and the prometheus exporter of vector
+	VectorAPIPort     = 8686
+	VectorMetricsPort = 9598
+
+	DefaultVectorImage = "timberio/vector:0.34.1-debian"
+)
+
+// Create the conf dir of this agent before starting vector, which watches the base config and the conf dir
+const vectorCommand = `mkdir -p %s/$POD_NAME && exec vector --watch-config --config %s/%s --config-dir %s/$POD_NAME`
+
+// Create or update the configmap and deployment of vector agents
+func (v *VectorAgentManagerImpl) Deploy() error {
+	ownerReferences, err := agent.GetOwnerReferences(v.Cli, v.Namespace, v.Name)
+	if err != nil {
+		return err
+	}
+
+	baseConfig, err := renderBaseConfig()
+	if err != nil {
+		return err
+	}
+
+	configMap := &v1.ConfigMap{
+		ObjectMeta: v.NewObjectMeta(ownerReferences),
+		Data: map[string]string{
+			VectorBaseConfFile: baseConfig,
+		},
+	}
+	err = agent.ApplyConfigMap(v.Cli, configMap)
+	if err != nil {
+		return err
+	}
+
+	container := v1.Container{
+		Name:    "vector",
+		Image:   v.GetImage(DefaultVectorImage),
+		Command: []string{"/bin/sh", "-c", fmt.Sprintf(vectorCommand, VectorConfVolumeMountPath, VectorBaseConfDir, VectorBaseConfFile, VectorConfVolumeMountPath)},
+		Ports: []v1.ContainerPort{
+			{
+				Name:          "api",
+				ContainerPort: VectorAPIPort,
+			},
+			{
+				Name:          "metrics",
+				ContainerPort: VectorMetricsPort,
+			},
+		},
+		VolumeMounts: []v1.VolumeMount{
+			{
+				Name:      "vector-base-conf",
+				MountPath: VectorBaseConfDir,
+			},
+			{
+				Name:      "vector-data",
+				MountPath: VectorDataDir,
+			},
+		},
+	}
+	volumes := []v1.Volume{
+		{
+			Name: "vector-base-conf",
+			VolumeSource: v1.VolumeSource{
+				ConfigMap: &v1.ConfigMapVolumeSource{
+					LocalObjectReference: v1.LocalObjectReference{Name: v.DeployName},
+				},
+			},
+		},
+		{
+			Name: "vector-data",
+			VolumeSource: v1.VolumeSource{
+				EmptyDir: &v1.EmptyDirVolumeSource{},
+			},
+		},
+	}
+	return agent.ApplyWorkload(v.Cli, v.NewDeployment(ownerReferences, container, volumes), v.DeployConfig, v.LogConfigs)
+}
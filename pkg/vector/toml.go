@@ -0,0 +1,571 @@
+package vector
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The TOML encoder and decoder of the subset used by the configs of vector: tables, dotted keys, strings,
+// integers, floats, booleans, arrays and inline tables. The arrays of tables and date-times are not supported.
+// The tables are decoded as map[string]interface{}, the integers as int64 and the arrays as []interface{}.
+
+// Encode the table with the keys sorted, the values of a table are written before its sub tables
+func encodeTOML(table map[string]interface{}) (string, error) {
+	buf := &bytes.Buffer{}
+	err := writeTable(buf, nil, table)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func writeTable(buf *bytes.Buffer, path []string, table map[string]interface{}) error {
+	keys := sortedKeys(table)
+
+	values, tables := make([]string, 0), make([]string, 0)
+	for _, k := range keys {
+		if _, ok := table[k].(map[string]interface{}); ok {
+			tables = append(tables, k)
+		} else {
+			values = append(values, k)
+		}
+	}
+
+	// The table which only has sub tables is defined implicitly by them
+	if len(path) != 0 && (len(values) != 0 || len(tables) == 0) {
+		if buf.Len() != 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(buf, "[%s]\n", encodeKeyPath(path))
+	}
+	for _, k := range values {
+		value, err := encodeValue(table[k])
+		if err != nil {
+			return fmt.Errorf("key %s: %v", encodeKeyPath(append(path, k)), err)
+		}
+		fmt.Fprintf(buf, "%s = %s\n", encodeKey(k), value)
+	}
+	for _, k := range tables {
+		err := writeTable(buf, append(append([]string{}, path...), k), table[k].(map[string]interface{}))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		// The multi-line strings like the programs of remap are written as literal strings, so that they are readable
+		if strings.Contains(v, "\n") && !strings.Contains(v, "'''") && !strings.ContainsAny(v, "\r\x00") {
+			return fmt.Sprintf("'''\n%s'''", v), nil
+		}
+		return encodeBasicString(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eEn") {
+			s += ".0"
+		}
+		return s, nil
+	case []string:
+		items := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			items = append(items, item)
+		}
+		return encodeValue(items)
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := encodeValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return fmt.Sprintf("[%s]", strings.Join(items, ", ")), nil
+	case map[string]interface{}:
+		items := make([]string, 0, len(v))
+		for _, k := range sortedKeys(v) {
+			s, err := encodeValue(v[k])
+			if err != nil {
+				return "", err
+			}
+			items = append(items, fmt.Sprintf("%s = %s", encodeKey(k), s))
+		}
+		if len(items) == 0 {
+			return "{}", nil
+		}
+		return fmt.Sprintf("{ %s }", strings.Join(items, ", ")), nil
+	}
+	return "", fmt.Errorf("unsupported type %T", v)
+}
+
+func encodeBasicString(s string) string {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(buf, `\u%04X`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+func encodeKey(k string) string {
+	if k != "" && strings.IndexFunc(k, func(r rune) bool { return !isBareKeyChar(r) }) == -1 {
+		return k
+	}
+	return encodeBasicString(k)
+}
+
+func encodeKeyPath(path []string) string {
+	keys := make([]string, 0, len(path))
+	for _, k := range path {
+		keys = append(keys, encodeKey(k))
+	}
+	return strings.Join(keys, ".")
+}
+
+func isBareKeyChar(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-'
+}
+
+func sortedKeys(table map[string]interface{}) []string {
+	keys := make([]string, 0, len(table))
+	for k := range table {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Decode the TOML document
+func decodeTOML(text string) (map[string]interface{}, error) {
+	p := &tomlParser{s: text, line: 1}
+	root := make(map[string]interface{})
+	current := root
+
+	for {
+		p.skipBlank(true)
+		if p.eof() {
+			return root, nil
+		}
+
+		if p.peek() == '[' {
+			if strings.HasPrefix(p.s[p.pos:], "[[") {
+				return nil, p.errorf("arrays of tables are not supported")
+			}
+			p.pos++
+			p.skipBlank(false)
+			path, err := p.parseKeyPath()
+			if err != nil {
+				return nil, err
+			}
+			p.skipBlank(false)
+			if !p.consume("]") {
+				return nil, p.errorf("expect ] after table %s", strings.Join(path, "."))
+			}
+			current, err = getTable(root, path)
+			if err != nil {
+				return nil, p.errorf("%v", err)
+			}
+		} else {
+			err := p.parseKeyValue(current)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if err := p.endOfLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Return the table at path, the missing tables are created
+func getTable(root map[string]interface{}, path []string) (map[string]interface{}, error) {
+	table := root
+	for i, k := range path {
+		v, exist := table[k]
+		if !exist {
+			v = make(map[string]interface{})
+			table[k] = v
+		}
+		sub, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("key %s is not a table", strings.Join(path[:i+1], "."))
+		}
+		table = sub
+	}
+	return table, nil
+}
+
+type tomlParser struct {
+	s    string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("toml line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *tomlParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *tomlParser) consume(prefix string) bool {
+	if strings.HasPrefix(p.s[p.pos:], prefix) {
+		p.line += strings.Count(prefix, "\n")
+		p.pos += len(prefix)
+		return true
+	}
+	return false
+}
+
+// Skip the spaces and comments, and the newlines if newline is true
+func (p *tomlParser) skipBlank(newline bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '\n' && newline:
+			p.pos++
+			p.line++
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) endOfLine() error {
+	p.skipBlank(false)
+	if p.eof() {
+		return nil
+	}
+	if p.peek() != '\n' {
+		return p.errorf("unexpected %q at the end of line", p.peek())
+	}
+	p.pos++
+	p.line++
+	return nil
+}
+
+func (p *tomlParser) parseKeyValue(table map[string]interface{}) error {
+	path, err := p.parseKeyPath()
+	if err != nil {
+		return err
+	}
+	p.skipBlank(false)
+	if !p.consume("=") {
+		return p.errorf("expect = after key %s", strings.Join(path, "."))
+	}
+	p.skipBlank(false)
+	value, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+
+	table, err = getTable(table, path[:len(path)-1])
+	if err != nil {
+		return p.errorf("%v", err)
+	}
+	key := path[len(path)-1]
+	if _, exist := table[key]; exist {
+		return p.errorf("key %s is defined twice", strings.Join(path, "."))
+	}
+	table[key] = value
+	return nil
+}
+
+func (p *tomlParser) parseKeyPath() ([]string, error) {
+	path := make([]string, 0)
+	for {
+		var key string
+		var err error
+		switch p.peek() {
+		case '"':
+			p.pos++
+			key, err = p.parseBasicString()
+		case '\'':
+			p.pos++
+			key, err = p.parseLiteralString()
+		default:
+			start := p.pos
+			for !p.eof() && isBareKeyChar(rune(p.peek())) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, p.errorf("expect key, got %q", p.peek())
+			}
+			key = p.s[start:p.pos]
+		}
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, key)
+
+		p.skipBlank(false)
+		if !p.consume(".") {
+			return path, nil
+		}
+		p.skipBlank(false)
+	}
+}
+
+func (p *tomlParser) parseValue() (interface{}, error) {
+	switch {
+	case p.consume(`"""`):
+		return p.parseMultiLineBasicString()
+	case p.consume(`"`):
+		return p.parseBasicString()
+	case p.consume("'''"):
+		return p.parseMultiLineLiteralString()
+	case p.consume("'"):
+		return p.parseLiteralString()
+	case p.consume("["):
+		return p.parseArray()
+	case p.consume("{"):
+		return p.parseInlineTable()
+	case p.consume("true"):
+		return true, nil
+	case p.consume("false"):
+		return false, nil
+	}
+	return p.parseNumber()
+}
+
+func (p *tomlParser) parseBasicString() (string, error) {
+	buf := &bytes.Buffer{}
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.s[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return buf.String(), nil
+		case '\\':
+			err := p.parseEscape(buf)
+			if err != nil {
+				return "", err
+			}
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
+
+func (p *tomlParser) parseMultiLineBasicString() (string, error) {
+	p.consumeFirstNewline()
+	buf := &bytes.Buffer{}
+	for {
+		if p.eof() {
+			return "", p.errorf("unterminated multi-line string")
+		}
+		if p.consume(`"""`) {
+			return buf.String(), nil
+		}
+		c := p.s[p.pos]
+		p.pos++
+		switch c {
+		case '\\':
+			// The backslash at the end of line trims the newline and the following spaces
+			rest := strings.TrimLeft(p.s[p.pos:], " \t\r")
+			if strings.HasPrefix(rest, "\n") {
+				for !p.eof() && strings.ContainsRune(" \t\r\n", rune(p.peek())) {
+					if p.peek() == '\n' {
+						p.line++
+					}
+					p.pos++
+				}
+				continue
+			}
+			err := p.parseEscape(buf)
+			if err != nil {
+				return "", err
+			}
+		case '\n':
+			p.line++
+			buf.WriteByte(c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
+
+func (p *tomlParser) parseEscape(buf *bytes.Buffer) error {
+	if p.eof() {
+		return p.errorf("unterminated escape")
+	}
+	c := p.s[p.pos]
+	p.pos++
+	switch c {
+	case 'b':
+		buf.WriteByte('\b')
+	case 't':
+		buf.WriteByte('\t')
+	case 'n':
+		buf.WriteByte('\n')
+	case 'f':
+		buf.WriteByte('\f')
+	case 'r':
+		buf.WriteByte('\r')
+	case '"':
+		buf.WriteByte('"')
+	case '\\':
+		buf.WriteByte('\\')
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		if p.pos+n > len(p.s) {
+			return p.errorf("invalid unicode escape")
+		}
+		code, err := strconv.ParseUint(p.s[p.pos:p.pos+n], 16, 32)
+		if err != nil {
+			return p.errorf("invalid unicode escape %s", p.s[p.pos:p.pos+n])
+		}
+		p.pos += n
+		buf.WriteRune(rune(code))
+	default:
+		return p.errorf("invalid escape \\%c", c)
+	}
+	return nil
+}
+
+func (p *tomlParser) parseLiteralString() (string, error) {
+	end := strings.IndexAny(p.s[p.pos:], "'\n")
+	if end == -1 || p.s[p.pos+end] == '\n' {
+		return "", p.errorf("unterminated literal string")
+	}
+	s := p.s[p.pos : p.pos+end]
+	p.pos += end + 1
+	return s, nil
+}
+
+func (p *tomlParser) parseMultiLineLiteralString() (string, error) {
+	p.consumeFirstNewline()
+	end := strings.Index(p.s[p.pos:], "'''")
+	if end == -1 {
+		return "", p.errorf("unterminated multi-line literal string")
+	}
+	s := p.s[p.pos : p.pos+end]
+	p.line += strings.Count(s, "\n")
+	p.pos += end + 3
+	return s, nil
+}
+
+// The newline immediately following the opening delimiter of multi-line string is trimmed
+func (p *tomlParser) consumeFirstNewline() {
+	if !p.consume("\n") {
+		p.consume("\r\n")
+	}
+}
+
+func (p *tomlParser) parseArray() ([]interface{}, error) {
+	items := make([]interface{}, 0)
+	for {
+		p.skipBlank(true)
+		if p.consume("]") {
+			return items, nil
+		}
+		item, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		p.skipBlank(true)
+		if p.consume("]") {
+			return items, nil
+		}
+		if !p.consume(",") {
+			return nil, p.errorf("expect , or ] in array")
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable() (map[string]interface{}, error) {
+	table := make(map[string]interface{})
+	p.skipBlank(false)
+	if p.consume("}") {
+		return table, nil
+	}
+	for {
+		p.skipBlank(false)
+		err := p.parseKeyValue(table)
+		if err != nil {
+			return nil, err
+		}
+		p.skipBlank(false)
+		if p.consume("}") {
+			return table, nil
+		}
+		if !p.consume(",") {
+			return nil, p.errorf("expect , or } in inline table")
+		}
+	}
+}
+
+func (p *tomlParser) parseNumber() (interface{}, error) {
+	start := p.pos
+	for !p.eof() && strings.ContainsRune("+-0123456789_.eE", rune(p.peek())) {
+		p.pos++
+	}
+	s := strings.Replace(p.s[start:p.pos], "_", "", -1)
+	if s == "" {
+		return nil, p.errorf("invalid value %q", p.peek())
+	}
+	if strings.ContainsAny(s, ".eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, p.errorf("invalid float %s", s)
+		}
+		return f, nil
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil, p.errorf("invalid integer %s", s)
+	}
+	return i, nil
+}
@@ -0,0 +1,106 @@
+package vector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+const (
+	// The graphql api of vector
+	VectorGraphQLPath = "/graphql"
+
+	// The query of the ids of all the running sources
+	sourcesQuery = `{ sources { edges { node { componentId } } } }`
+
+	// The default timeout of the requests to vector
+	DefaultVectorAPITimeout = 5 * time.Second
+)
+
+// VectorAgentManagerImpl manages vector agents, the config bundle of every logSource is a TOML file
+// in the config dir of its agent, which is watched by vector.
+type VectorAgentManagerImpl struct {
+	*agent.FileAgentManager
+
+	// The client of the api of vector
+	Client *http.Client
+}
+
+func init() {
+	agent.Register(agent.Backend{
+		Type: agent.Vector,
+		New:  NewVectorAgentManager,
+		Schema: agent.ConfigSchema{
+			Format:      api.FormatTOML,
+			Description: "delivers configs by TOML files in the watched config dir of vector",
+		},
+		Compile: compileSpec,
+	})
+}
+
+func NewVectorAgentManager(cfg *agent.AgentManagerConfig) agent.AgentManager {
+	timeout := cfg.APIConfig.Timeout
+	if timeout <= 0 {
+		timeout = DefaultVectorAPITimeout
+	}
+	v := &VectorAgentManagerImpl{
+		FileAgentManager: agent.NewFileAgentManager(agent.Vector, cfg, VectorConfVolumeMountPath, VectorAPIPort),
+		Client:           &http.Client{Timeout: timeout},
+	}
+	v.Render = renderConfig
+	v.FileName = getConfigFileName
+	// The checkpoints of file source are kept in the log meta dir
+	v.CreateMetaDir = true
+	return v
+}
+
+// Check whether vector agentName stops the file source of logSource, which happens after the config is reloaded
+func (v *VectorAgentManagerImpl) RunnerStopped(logSource *api.LogSource, agentName string) (bool, error) {
+	addr, err := v.AgentAddr(agentName)
+	if err != nil {
+		return false, err
+	}
+	if addr == "" {
+		return true, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"query": sourcesQuery})
+	if err != nil {
+		return false, err
+	}
+	resp, err := v.Client.Post(fmt.Sprintf("http://%s%s", addr, VectorGraphQLPath), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("query sources from vector %s failed, status code: %d", agentName, resp.StatusCode)
+	}
+
+	result := struct {
+		Data struct {
+			Sources struct {
+				Edges []struct {
+					Node struct {
+						ComponentID string `json:"componentId"`
+					} `json:"node"`
+				} `json:"edges"`
+			} `json:"sources"`
+		} `json:"data"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return false, err
+	}
+	for _, edge := range result.Data.Sources.Edges {
+		if edge.Node.ComponentID == getComponentID(logSource) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
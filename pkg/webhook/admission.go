@@ -0,0 +1,42 @@
+package webhook
+
+import (
+	"encoding/json"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The types of admission.k8s.io/v1beta1 used by the webhook, only the fields it reads and writes
+
+// AdmissionReview is sent by the apiserver with the request, and sent back with the response
+type AdmissionReview struct {
+	APIVersion string             `json:"apiVersion,omitempty"`
+	Kind       string             `json:"kind,omitempty"`
+	Request    *AdmissionRequest  `json:"request,omitempty"`
+	Response   *AdmissionResponse `json:"response,omitempty"`
+}
+
+type AdmissionRequest struct {
+	UID       string                  `json:"uid"`
+	Kind      metav1.GroupVersionKind `json:"kind"`
+	Namespace string                  `json:"namespace,omitempty"`
+	Operation string                  `json:"operation"`
+	Object    json.RawMessage         `json:"object,omitempty"`
+}
+
+type AdmissionResponse struct {
+	UID       string         `json:"uid"`
+	Allowed   bool           `json:"allowed"`
+	Result    *metav1.Status `json:"status,omitempty"`
+	Patch     []byte         `json:"patch,omitempty"`
+	PatchType *string        `json:"patchType,omitempty"`
+}
+
+// One operation of the json patch which mutates the pod
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+const PatchTypeJSONPatch = "JSONPatch"
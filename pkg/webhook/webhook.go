@@ -0,0 +1,206 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+// The path served for the MutatingWebhookConfiguration of pods
+const MutatePath = "/mutate"
+
+// Sidecar is the log agent of one agent type injected into the pods matched by its logConfigs
+type Sidecar struct {
+	AgentType    agent.AgentType
+	Inject       agent.SidecarFunc
+	DeployConfig agent.DeployConfig
+
+	// The logConfigs of the agent type, whose LabelSelector is used to match the pods
+	LogConfigs []api.LogConfig
+}
+
+// Webhook injects the sidecar log agents into the pods created, which collect the logs on the volumes that only
+// the pod can mount, and labels the pods with the logmanager, so that the AgentManager of the sidecars finds them.
+type Webhook struct {
+	// The name of logmanager, which is the value of the sidecar label
+	Name string
+
+	// The address to serve, and the tls cert and key, the apiserver only calls the webhook by https
+	Addr     string
+	CertFile string
+	KeyFile  string
+
+	Sidecars []Sidecar
+}
+
+// Serve the webhook until it fails
+func (w *Webhook) Run() {
+	logger := log.WithFields(log.Fields{
+		"func": "Webhook.Run",
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(MutatePath, w.mutateHandler)
+
+	logger.Infof("Start serving the sidecar webhook on %s", w.Addr)
+	err := http.ListenAndServeTLS(w.Addr, w.CertFile, w.KeyFile, mux)
+	if err != nil {
+		logger.Errorf("Serve the sidecar webhook failed, err: %v", err)
+	}
+}
+
+func (w *Webhook) mutateHandler(rw http.ResponseWriter, r *http.Request) {
+	logger := log.WithFields(log.Fields{
+		"func": "mutateHandler",
+	})
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	review := AdmissionReview{}
+	err = json.Unmarshal(body, &review)
+	if err != nil || review.Request == nil {
+		http.Error(rw, fmt.Sprintf("invalid admission review, err: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review.Response = w.review(review.Request)
+	review.Request = nil
+	data, err := json.Marshal(review)
+	if err != nil {
+		logger.Errorf("Marshal the admission review failed, err: %v", err)
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(data)
+}
+
+// Review the admission request, the pod is always allowed, so that the failure of injection never blocks the
+// workloads, and their logSources are pending with no log agent in its pod
+func (w *Webhook) review(request *AdmissionRequest) *AdmissionResponse {
+	logger := log.WithFields(log.Fields{
+		"func": "review",
+		"uid":  request.UID,
+	})
+
+	response := &AdmissionResponse{
+		UID:     request.UID,
+		Allowed: true,
+	}
+	if request.Kind.Kind != "Pod" || request.Operation != "CREATE" {
+		return response
+	}
+
+	pod := &v1.Pod{}
+	err := json.Unmarshal(request.Object, pod)
+	if err != nil {
+		logger.Errorf("Decode the pod failed, err: %v", err)
+		response.Result = &metav1.Status{Message: err.Error()}
+		return response
+	}
+
+	patch, err := w.mutate(pod, request.Namespace)
+	if err != nil {
+		logger.Errorf("Inject the sidecar into pod %s failed, err: %v", pod.Name, err)
+		response.Result = &metav1.Status{Message: err.Error()}
+		return response
+	}
+	if len(patch) != 0 {
+		patchType := PatchTypeJSONPatch
+		response.Patch = patch
+		response.PatchType = &patchType
+	}
+	return response
+}
+
+// Return the json patch which injects the sidecars matched by pod, nil if no sidecar is matched
+func (w *Webhook) mutate(pod *v1.Pod, namespace string) ([]byte, error) {
+	logger := log.WithFields(log.Fields{
+		"func": "mutate",
+	})
+
+	if pod.Namespace == "" {
+		pod.Namespace = namespace
+	}
+	if _, exist := pod.Labels[agent.SidecarLabel]; exist {
+		return nil, nil
+	}
+
+	operations := make([]PatchOperation, 0)
+	volumes := len(pod.Spec.Volumes)
+	for _, sidecar := range w.Sidecars {
+		logConfigs, err := matchLogConfigs(pod, sidecar.LogConfigs)
+		if err != nil {
+			return nil, err
+		}
+		if len(logConfigs) == 0 {
+			continue
+		}
+		// The configs of the sidecar are delivered by the name of pod, which is unknown for the pod of generateName.
+		// The LogConfigs of sidecar only select the pods of statefulset, so it is a pod of another workload matching
+		// the label selector as well.
+		if pod.Name == "" {
+			logger.Warnf("Pod %s* in namespace %s matches the sidecar of agent type %s but has no name, which is not injected",
+				pod.GenerateName, pod.Namespace, sidecar.AgentType)
+			continue
+		}
+
+		container, sidecarVolumes := sidecar.Inject(pod, logConfigs, sidecar.DeployConfig)
+		operations = append(operations, PatchOperation{Op: "add", Path: "/spec/containers/-", Value: container})
+		for _, volume := range sidecarVolumes {
+			if volumes == 0 {
+				operations = append(operations, PatchOperation{Op: "add", Path: "/spec/volumes", Value: []v1.Volume{volume}})
+			} else {
+				operations = append(operations, PatchOperation{Op: "add", Path: "/spec/volumes/-", Value: volume})
+			}
+			volumes++
+		}
+	}
+	if len(operations) == 0 {
+		return nil, nil
+	}
+
+	if pod.Labels == nil {
+		operations = append(operations, PatchOperation{Op: "add", Path: "/metadata/labels", Value: map[string]string{agent.SidecarLabel: w.Name}})
+	} else {
+		operations = append(operations, PatchOperation{Op: "add", Path: "/metadata/labels/" + escapePatchPath(agent.SidecarLabel), Value: w.Name})
+	}
+	return json.Marshal(operations)
+}
+
+// Return the logConfigs in the namespace of pod whose label selector matches pod
+func matchLogConfigs(pod *v1.Pod, logConfigs []api.LogConfig) ([]api.LogConfig, error) {
+	matched := make([]api.LogConfig, 0)
+	for _, logConfig := range logConfigs {
+		// The empty selector of the controller not found matches nothing, rather than every pod
+		if logConfig.Namespace != pod.Namespace || logConfig.LabelSelector == "" {
+			continue
+		}
+		selector, err := labels.Parse(logConfig.LabelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("parse label selector of log config %s failed, err: %v", logConfig.GetControllerName(), err)
+		}
+		if selector.Matches(labels.Set(pod.Labels)) {
+			matched = append(matched, logConfig)
+		}
+	}
+	return matched, nil
+}
+
+// Escape the key used in the path of json patch
+func escapePatchPath(key string) string {
+	return strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
@@ -0,0 +1,124 @@
+package webhook
+
+import (
+	"encoding/json"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fatsheep9146/kirklog/pkg/agent"
+	"github.com/fatsheep9146/kirklog/pkg/api"
+)
+
+func newTestWebhook() *Webhook {
+	return &Webhook{
+		Name: "kirklog",
+		Sidecars: []Sidecar{
+			{
+				AgentType: agent.LogkitSidecar,
+				Inject: func(pod *v1.Pod, logConfigs []api.LogConfig, config agent.DeployConfig) (v1.Container, []v1.Volume) {
+					return v1.Container{Name: "sidecar", VolumeMounts: agent.GetPodLogVolumeMounts(pod, logConfigs)},
+						[]v1.Volume{{Name: "sidecar-conf"}}
+				},
+				LogConfigs: []api.LogConfig{
+					{
+						Name:          "test",
+						Namespace:     "test-ns",
+						Kind:          "statefulset",
+						LabelSelector: "app=test",
+						Streams:       []api.LogStream{{Name: "applog", VolumeMount: "applog"}},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestMutate(t *testing.T) {
+	w := newTestWebhook()
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-0", Labels: map[string]string{"app": "test"}},
+		Spec: v1.PodSpec{
+			Volumes: []v1.Volume{{Name: "applog"}},
+		},
+	}
+
+	patch, err := w.mutate(pod, "test-ns")
+	if err != nil {
+		t.Fatalf("mutate pod failed, err: %v", err)
+	}
+	operations := make([]PatchOperation, 0)
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		t.Fatalf("decode patch failed, err: %v", err)
+	}
+	paths := make([]string, 0)
+	for _, op := range operations {
+		paths = append(paths, op.Path)
+	}
+	expected := []string{"/spec/containers/-", "/spec/volumes/-", "/metadata/labels/kirklog.io~1sidecar"}
+	if len(paths) != len(expected) {
+		t.Fatalf("patch should have paths %v, is %v", expected, paths)
+	}
+	for i := range expected {
+		if paths[i] != expected[i] {
+			t.Errorf("patch should have paths %v, is %v", expected, paths)
+			break
+		}
+	}
+	raw, _ := json.Marshal(operations[0].Value)
+	container := v1.Container{}
+	json.Unmarshal(raw, &container)
+	if len(container.VolumeMounts) != 1 || container.VolumeMounts[0].Name != "applog" || container.VolumeMounts[0].MountPath != "/statefulset_test_applog" {
+		t.Errorf("sidecar should mount the log volume of pod at /statefulset_test_applog, is %s", raw)
+	}
+
+	// The pod injected already, in another namespace, or not matched is not mutated
+	for _, c := range []struct {
+		labels    map[string]string
+		namespace string
+	}{
+		{map[string]string{"app": "test", agent.SidecarLabel: "kirklog"}, "test-ns"},
+		{map[string]string{"app": "test"}, "other-ns"},
+		{map[string]string{"app": "other"}, "test-ns"},
+	} {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-0", Labels: c.labels}}
+		patch, err := w.mutate(pod, c.namespace)
+		if err != nil || patch != nil {
+			t.Errorf("pod with labels %v in namespace %s should not be mutated, patch is %s, err: %v", c.labels, c.namespace, patch, err)
+		}
+	}
+}
+
+func TestReview(t *testing.T) {
+	w := newTestWebhook()
+	pod, _ := json.Marshal(v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-0", Labels: map[string]string{"app": "test"}}})
+
+	response := w.review(&AdmissionRequest{
+		UID:       "uid-1",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Namespace: "test-ns",
+		Operation: "CREATE",
+		Object:    pod,
+	})
+	if !response.Allowed || response.UID != "uid-1" || response.PatchType == nil || *response.PatchType != PatchTypeJSONPatch {
+		t.Errorf("pod should be allowed with json patch, response is %+v", response)
+	}
+
+	operations := make([]PatchOperation, 0)
+	json.Unmarshal(response.Patch, &operations)
+	if len(operations) != 3 || operations[1].Path != "/spec/volumes" || operations[2].Path != "/metadata/labels/kirklog.io~1sidecar" {
+		t.Errorf("patch should add the volumes array of the pod without volumes, is %s", response.Patch)
+	}
+
+	// The invalid pod is still allowed
+	response = w.review(&AdmissionRequest{
+		UID:       "uid-2",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Operation: "CREATE",
+		Object:    []byte("{"),
+	})
+	if !response.Allowed || response.Patch != nil {
+		t.Errorf("invalid pod should be allowed without patch, response is %+v", response)
+	}
+}